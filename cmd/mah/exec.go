@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+
+	"github.com/jonas-jonas/mah/internal/nexus"
+)
+
+var execCmd = &cobra.Command{
+	Use:   "exec [--servers a,b] [--sudo] -- <command>",
+	Short: "Run a command on the servers of a nexus",
+	Long: `Run a command on every server of the current nexus, of the nexus given with
+--nexus, or of all nexuses with --all. --servers narrows this down to the
+named servers. Output is printed as it arrives, each line prefixed with the
+server name; --group instead waits for every server and prints each distinct
+output once, with the servers that produced it. A summary of exit codes
+follows, and mah exits non-zero when the command failed anywhere.
+
+Examples:
+  mah exec -- uptime
+  mah exec --nexus prod --sudo -- systemctl restart docker
+  mah exec --all --group --max-parallel 5 -- cat /etc/os-release
+  mah exec --servers web1,web2 --timeout 10s -- df -h /`,
+	Args:          cobra.MinimumNArgs(1),
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		servers, _ := cmd.Flags().GetStringSlice("servers")
+		group, _ := cmd.Flags().GetBool("group")
+
+		var opts nexus.ExecOptions
+		opts.Sudo, _ = cmd.Flags().GetBool("sudo")
+		opts.MaxParallel, _ = cmd.Flags().GetInt("max-parallel")
+		opts.Timeout, _ = cmd.Flags().GetDuration("timeout")
+		if !group {
+			opts.Output = os.Stdout
+		}
+
+		return execCommand(strings.Join(args, " "), servers, group, opts)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(execCmd)
+
+	execCmd.Flags().StringSlice("servers", nil, "Only run on these servers")
+	execCmd.Flags().Bool("sudo", false, "Run the command with sudo")
+	execCmd.Flags().Int("max-parallel", 10, "Servers running the command at once (0 for all)")
+	execCmd.Flags().Duration("timeout", 0, "Time limit per server, including connecting (0 for none)")
+	execCmd.Flags().Bool("group", false, "Print each distinct output once instead of streaming it")
+}
+
+// execCommand runs a command on the target servers and prints a summary
+func execCommand(command string, only []string, group bool, opts nexus.ExecOptions) error {
+	serverNames, err := execTargets(only)
+	if err != nil {
+		return err
+	}
+
+	results := nexusManager.Exec(context.Background(), serverNames, command, opts)
+
+	if group {
+		printGroupedOutput(results)
+	}
+
+	// Summary of exit codes
+	failed := 0
+	fmt.Println()
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "%s\t%s\t%s\t%s\n",
+		color.CyanString("SERVER"),
+		color.CyanString("EXIT"),
+		color.CyanString("DURATION"),
+		color.CyanString("ERROR"))
+	for _, result := range results {
+		duration := result.Duration.Round(time.Millisecond)
+		switch {
+		case result.Err != nil:
+			failed++
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", result.Server, color.RedString("-"), duration, result.Err)
+		case result.Result.ExitCode != 0:
+			failed++
+			fmt.Fprintf(w, "%s\t%s\t%s\t\n", result.Server, color.RedString("%d", result.Result.ExitCode), duration)
+		default:
+			fmt.Fprintf(w, "%s\t%s\t%s\t\n", result.Server, color.GreenString("0"), duration)
+		}
+	}
+	w.Flush()
+
+	if failed > 0 {
+		return fmt.Errorf("command failed on %d of %d servers", failed, len(results))
+	}
+	return nil
+}
+
+// execTargets resolves the servers mah exec runs on from --all, --nexus or
+// the current nexus, narrowed down to only when it is set
+func execTargets(only []string) ([]string, error) {
+	config := configManager.GetConfig()
+	if config == nil {
+		return nil, fmt.Errorf("no configuration loaded")
+	}
+
+	var candidates []string
+	if allNexuses {
+		seen := make(map[string]bool)
+		for _, nexusConfig := range config.Nexuses {
+			for _, serverName := range nexusConfig.Servers {
+				if !seen[serverName] {
+					seen[serverName] = true
+					candidates = append(candidates, serverName)
+				}
+			}
+		}
+		sort.Strings(candidates)
+	} else {
+		current, err := nexusManager.GetCurrent()
+		if err != nil {
+			return nil, fmt.Errorf("no current nexus set; use --nexus or --all")
+		}
+		candidates = config.Nexuses[current.Name].Servers
+	}
+
+	if len(only) == 0 {
+		return candidates, nil
+	}
+
+	var serverNames []string
+	for _, serverName := range only {
+		if !containsString(candidates, serverName) {
+			if config.Servers[serverName] == nil {
+				return nil, fmt.Errorf("server '%s' not found in configuration", serverName)
+			}
+			return nil, fmt.Errorf("server '%s' is not in the selected nexus", serverName)
+		}
+		serverNames = append(serverNames, serverName)
+	}
+	return serverNames, nil
+}
+
+// printGroupedOutput prints each distinct output once, headed by the
+// servers that produced it
+func printGroupedOutput(results []nexus.ExecResult) {
+	var outputs []string
+	servers := make(map[string][]string)
+	for _, result := range results {
+		var output string
+		if result.Err != nil {
+			output = color.RedString("error: %v", result.Err) + "\n"
+		} else {
+			output = result.Result.Stdout + result.Result.Stderr
+		}
+
+		if _, ok := servers[output]; !ok {
+			outputs = append(outputs, output)
+		}
+		servers[output] = append(servers[output], result.Server)
+	}
+
+	for _, output := range outputs {
+		fmt.Printf("%s\n", color.CyanString("── %s (%d) ──", strings.Join(servers[output], ", "), len(servers[output])))
+		fmt.Print(output)
+		if output != "" && !strings.HasSuffix(output, "\n") {
+			fmt.Println()
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
 
@@ -56,12 +57,32 @@ Examples:
 		// Initialize nexus manager
 		nexusManager = nexus.NewManager(configManager)
 		
+		// --nexus targets a nexus for this command only
+		if currentNexus != "" && configManager.GetConfig() != nil {
+			if err := nexusManager.Use(currentNexus); err != nil {
+				return err
+			}
+		}
+		
 		return nil
 	},
 }
 
 func main() {
-	if err := rootCmd.Execute(); err != nil {
+	err := rootCmd.Execute()
+
+	// Close pooled server connections shared by the command
+	if nexusManager != nil {
+		nexusManager.Close()
+	}
+
+	if err != nil {
+		// Pass through exit codes of remote commands without extra output
+		var exitErr *exitCodeError
+		if errors.As(err, &exitErr) {
+			os.Exit(exitErr.code)
+		}
+
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
 	}
@@ -92,4 +113,14 @@ var versionCmd = &cobra.Command{
 		fmt.Printf("Built: %s\n", BuildTime)
 		fmt.Printf("Commit: %s\n", GitCommit)
 	},
-}
\ No newline at end of file
+}
+
+// exitCodeError makes mah exit with an exit code, such as that of a remote
+// command, without printing an error
+type exitCodeError struct {
+	code int
+}
+
+func (e *exitCodeError) Error() string {
+	return fmt.Sprintf("command exited with status %d", e.code)
+}
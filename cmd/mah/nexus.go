@@ -1,14 +1,26 @@
 package main
 
 import (
+	"bufio"
 	"context"
+	"encoding/json"
 	"fmt"
 	"os"
+	"sort"
+	"strings"
+	"sync"
 	"text/tabwriter"
 	"time"
 
 	"github.com/fatih/color"
 	"github.com/spf13/cobra"
+
+	"github.com/jonas-jonas/mah/internal/config"
+	"github.com/jonas-jonas/mah/internal/nexus"
+	"github.com/jonas-jonas/mah/internal/plugins/docker"
+	"github.com/jonas-jonas/mah/internal/server"
+	"github.com/jonas-jonas/mah/internal/state"
+	"github.com/jonas-jonas/mah/pkg"
 )
 
 var nexusCmd = &cobra.Command{
@@ -157,7 +169,14 @@ var nexusStatusCmd = &cobra.Command{
 			fmt.Println("│                                                    │")
 			fmt.Printf("│ %-50s │\n", color.CyanString("Server Details:"))
 
-			for serverID, serverStatus := range status.ServerStatuses {
+			serverIDs := make([]string, 0, len(status.ServerStatuses))
+			for serverID := range status.ServerStatuses {
+				serverIDs = append(serverIDs, serverID)
+			}
+			sort.Strings(serverIDs)
+
+			for _, serverID := range serverIDs {
+				serverStatus := status.ServerStatuses[serverID]
 				statusIcon := color.RedString("✗")
 				statusText := "OFFLINE"
 				if serverStatus.Online {
@@ -182,6 +201,14 @@ var nexusStatusCmd = &cobra.Command{
 				if serverStatus.Error != "" {
 					fmt.Printf("│   Error: %s\n", color.RedString(serverStatus.Error))
 				}
+
+				for _, service := range serverStatus.Services {
+					serviceIcon := color.GreenString("✓")
+					if service.Health != "healthy" {
+						serviceIcon = color.RedString("✗")
+					}
+					fmt.Printf("│   %s %-18s %s (%s, %d running)\n", serviceIcon, service.Name, service.Status, service.Health, service.Replicas)
+				}
 			}
 		}
 
@@ -191,9 +218,672 @@ var nexusStatusCmd = &cobra.Command{
 	},
 }
 
+var nexusPatchCmd = &cobra.Command{
+	Use:   "patch [nexus-name]",
+	Short: "Upgrade and reboot the servers of a nexus, one batch at a time",
+	Long: `Upgrade the packages on every server of a nexus, one batch of servers at a
+time. When a server needs a reboot afterwards, its services are stopped, the
+server is rebooted, and mah waits for SSH and then for the services to be
+healthy again before moving on. Patching stops at the first server that
+fails; servers in later batches are left untouched.
+
+Examples:
+  mah nexus patch prod
+  mah nexus patch prod --batch-size 2 --yes`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var nexusName string
+		if len(args) > 0 {
+			nexusName = args[0]
+		} else {
+			current, err := nexusManager.GetCurrent()
+			if err != nil {
+				return fmt.Errorf("no current nexus set and no nexus specified")
+			}
+			nexusName = current.Name
+		}
+
+		var opts patchOptions
+		opts.batchSize, _ = cmd.Flags().GetInt("batch-size")
+		opts.noReboot, _ = cmd.Flags().GetBool("no-reboot")
+		opts.rebootTimeout, _ = cmd.Flags().GetDuration("reboot-timeout")
+		opts.healthTimeout, _ = cmd.Flags().GetDuration("health-timeout")
+		opts.yes, _ = cmd.Flags().GetBool("yes")
+		if opts.batchSize < 1 {
+			return fmt.Errorf("--batch-size must be at least 1")
+		}
+		return patchNexus(nexusName, opts)
+	},
+}
+
+var nexusStateCmd = &cobra.Command{
+	Use:   "state [nexus-name]",
+	Short: "Show what mah deployed to a nexus",
+	Long: `Show the service revisions mah deployed to the servers of a nexus: the
+image and its registry digest, hashes of the rendered compose file and of the
+environment, and when and by whom each was deployed. Deployments are recorded
+under the state directory (~/.mah/state) by mah service deploy.
+
+Examples:
+  mah nexus state prod
+  mah nexus state prod --service blog --history
+  mah nexus state prod --json`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var nexusName string
+		if len(args) > 0 {
+			nexusName = args[0]
+		} else {
+			current, err := nexusManager.GetCurrent()
+			if err != nil {
+				return fmt.Errorf("no current nexus set and no nexus specified")
+			}
+			nexusName = current.Name
+		}
+
+		serviceName, _ := cmd.Flags().GetString("service")
+		history, _ := cmd.Flags().GetBool("history")
+		asJSON, _ := cmd.Flags().GetBool("json")
+		return showNexusState(nexusName, serviceName, history, asJSON)
+	},
+}
+
+var nexusPromoteCmd = &cobra.Command{
+	Use:   "promote <source-nexus> <target-nexus>",
+	Short: "Ship the services deployed in one nexus to another",
+	Long: `Deploy the exact revisions recorded in the source nexus to the target
+nexus. Images are pinned to the registry digest that was deployed in the
+source, and the rest of the recorded configuration is reused as it is. Only
+domains and environment come from the target: its nexus overrides applied to
+the service. Without --services, every service deployed in the source nexus
+that has servers in the target nexus is promoted.
+
+Examples:
+  mah nexus promote staging production
+  mah nexus promote staging production --services blog,shop --yes`,
+	Args:         cobra.ExactArgs(2),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		services, _ := cmd.Flags().GetStringSlice("services")
+		yes, _ := cmd.Flags().GetBool("yes")
+		return promoteNexus(args[0], args[1], services, yes)
+	},
+}
+
+var nexusDiffCmd = &cobra.Command{
+	Use:   "diff <nexus> <other-nexus>",
+	Short: "Show how two nexuses differ",
+	Long: `Compare the services and firewall rules of two nexuses: services present
+in only one, and differences in images, environment variable names,
+replicas, volumes and ports. Images, replicas, volumes and ports are
+compared as last deployed where mah recorded a deployment, and as configured
+otherwise. The services' containers are checked on every server as well,
+unless --offline is given.
+
+With --exit-code, mah exits with status 1 when the nexuses differ, so the
+command can gate a CI pipeline.
+
+Examples:
+  mah nexus diff staging production
+  mah nexus diff staging production --json --exit-code
+  mah nexus diff staging production --offline`,
+	Args:          cobra.ExactArgs(2),
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		asJSON, _ := cmd.Flags().GetBool("json")
+		exitCode, _ := cmd.Flags().GetBool("exit-code")
+		offline, _ := cmd.Flags().GetBool("offline")
+		return diffNexuses(args[0], args[1], asJSON, exitCode, offline)
+	},
+}
+
 func init() {
 	nexusCmd.AddCommand(nexusListCmd)
 	nexusCmd.AddCommand(nexusSwitchCmd)
 	nexusCmd.AddCommand(nexusCurrentCmd)  
 	nexusCmd.AddCommand(nexusStatusCmd)
-}
\ No newline at end of file
+	nexusCmd.AddCommand(nexusPatchCmd)
+	nexusCmd.AddCommand(nexusStateCmd)
+	nexusCmd.AddCommand(nexusPromoteCmd)
+	nexusCmd.AddCommand(nexusDiffCmd)
+
+	nexusPatchCmd.Flags().Int("batch-size", 1, "Number of servers patched at the same time")
+	nexusPatchCmd.Flags().Bool("no-reboot", false, "Upgrade packages but never reboot")
+	nexusPatchCmd.Flags().Duration("reboot-timeout", 10*time.Minute, "How long to wait for a server to come back after a reboot")
+	nexusPatchCmd.Flags().Duration("health-timeout", 5*time.Minute, "How long to wait for a server's services to become healthy")
+	nexusPatchCmd.Flags().BoolP("yes", "y", false, "Start patching without asking for confirmation")
+
+	nexusStateCmd.Flags().StringP("service", "s", "", "Only show this service")
+	nexusStateCmd.Flags().Bool("history", false, "Show every recorded revision, newest first")
+	nexusStateCmd.Flags().Bool("json", false, "Print the deployments as JSON")
+
+	nexusPromoteCmd.Flags().StringSlice("services", nil, "Only promote these services")
+	nexusPromoteCmd.Flags().BoolP("yes", "y", false, "Promote without asking for confirmation")
+
+	nexusDiffCmd.Flags().Bool("json", false, "Print the differences as JSON")
+	nexusDiffCmd.Flags().Bool("exit-code", false, "Exit with status 1 when the nexuses differ")
+	nexusDiffCmd.Flags().Bool("offline", false, "Skip checking the containers on the servers")
+}
+
+// patchOptions control how mah nexus patch works through the servers
+type patchOptions struct {
+	batchSize     int
+	noReboot      bool
+	rebootTimeout time.Duration
+	healthTimeout time.Duration
+	yes           bool
+}
+
+// patchNexus upgrades the servers of a nexus in batches, stopping after the
+// first batch in which a server fails
+func patchNexus(nexusName string, opts patchOptions) error {
+	config := configManager.GetConfig()
+	if config == nil {
+		return fmt.Errorf("no configuration loaded")
+	}
+
+	nexus := config.Nexuses[nexusName]
+	if nexus == nil {
+		return fmt.Errorf("nexus '%s' not found in configuration", nexusName)
+	}
+
+	var serverNames []string
+	for _, serverName := range nexus.Servers {
+		if config.Servers[serverName].IsLocal() {
+			color.Yellow("⚠️  Skipping '%s': mah does not reboot the machine it runs on", serverName)
+			continue
+		}
+		serverNames = append(serverNames, serverName)
+	}
+	if len(serverNames) == 0 {
+		return fmt.Errorf("nexus '%s' has no remote servers to patch", nexusName)
+	}
+
+	var batches [][]string
+	for start := 0; start < len(serverNames); start += opts.batchSize {
+		end := start + opts.batchSize
+		if end > len(serverNames) {
+			end = len(serverNames)
+		}
+		batches = append(batches, serverNames[start:end])
+	}
+
+	fmt.Printf("🩹 Patching nexus '%s' in %d batches:\n", nexusName, len(batches))
+	for i, batch := range batches {
+		fmt.Printf("   %d. %s\n", i+1, strings.Join(batch, ", "))
+	}
+
+	if !opts.yes {
+		fmt.Print("Start patching? [y/N]: ")
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		answer = strings.ToLower(strings.TrimSpace(answer))
+		if answer != "y" && answer != "yes" {
+			fmt.Println("Nothing patched.")
+			return nil
+		}
+	}
+
+	ctx := context.Background()
+
+	patched := 0
+	for _, batch := range batches {
+		errs := make([]error, len(batch))
+		var wg sync.WaitGroup
+		for i, serverName := range batch {
+			wg.Add(1)
+			go func(i int, serverName string) {
+				defer wg.Done()
+				errs[i] = patchServer(ctx, config, serverName, opts)
+			}(i, serverName)
+		}
+		wg.Wait()
+
+		var failed []string
+		for i, err := range errs {
+			if err != nil {
+				color.Red("❌ [%s] %v", batch[i], err)
+				failed = append(failed, batch[i])
+			}
+		}
+		patched += len(batch) - len(failed)
+		if len(failed) > 0 {
+			return fmt.Errorf("patching stopped after %s failed; %d of %d servers patched", strings.Join(failed, ", "), patched, len(serverNames))
+		}
+	}
+
+	color.Green("✅ Patched %d servers in nexus '%s'", patched, nexusName)
+	return nil
+}
+
+// patchServer upgrades one server and, when the upgrade needs it, reboots
+// the server with its services stopped
+func patchServer(ctx context.Context, config *config.Config, serverName string, opts patchOptions) error {
+	srv, err := nexusManager.Server(ctx, serverName)
+	if err != nil {
+		return err
+	}
+
+	distro := config.Servers[serverName].Distro
+	if distro == "" {
+		if distro, err = srv.GetDistro(ctx); err != nil {
+			return fmt.Errorf("failed to detect distribution: %w", err)
+		}
+	}
+	ops, err := server.NewFactory().Operations(srv, distro)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("📦 [%s] Upgrading packages...\n", serverName)
+	if err := ops.UpdateSystem(ctx); err != nil {
+		return fmt.Errorf("failed to upgrade packages: %w", err)
+	}
+
+	required, err := ops.RebootRequired(ctx)
+	if err != nil {
+		return err
+	}
+
+	services := servicesOn(config, serverName)
+	provider := docker.NewProvider(map[string]pkg.Server{serverName: srv}, config)
+
+	switch {
+	case !required:
+		fmt.Printf("✅ [%s] No reboot required\n", serverName)
+	case opts.noReboot:
+		color.Yellow("⚠️  [%s] Reboot required, skipped because of --no-reboot", serverName)
+	default:
+		for _, serviceName := range services {
+			fmt.Printf("🛑 [%s] Stopping service '%s'\n", serverName, serviceName)
+			if err := provider.StopOn(ctx, serviceName, serverName); err != nil {
+				return err
+			}
+		}
+
+		bootID, err := server.BootID(ctx, srv)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("🔄 [%s] Rebooting...\n", serverName)
+		if err := server.Reboot(ctx, srv); err != nil {
+			return err
+		}
+
+		waitCtx, cancel := context.WithTimeout(ctx, opts.rebootTimeout)
+		err = server.WaitForBoot(waitCtx, srv, bootID, 5*time.Second)
+		cancel()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("🔗 [%s] Back online\n", serverName)
+
+		for _, serviceName := range services {
+			fmt.Printf("🚀 [%s] Starting service '%s'\n", serverName, serviceName)
+			if err := provider.StartOn(ctx, serviceName, serverName); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Upgrading Docker restarts containers even without a reboot
+	for _, serviceName := range services {
+		if err := waitHealthy(ctx, provider, serviceName, serverName, opts.healthTimeout); err != nil {
+			return err
+		}
+	}
+
+	color.Green("✅ [%s] Patched", serverName)
+	return nil
+}
+
+// waitHealthy polls a service on a server until it is healthy or timeout
+// passes
+func waitHealthy(ctx context.Context, provider *docker.Provider, serviceName, serverName string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	for {
+		healthy, detail, err := provider.HealthOn(ctx, serviceName, serverName)
+		if err == nil && healthy {
+			fmt.Printf("💚 [%s] Service '%s' is healthy\n", serverName, serviceName)
+			return nil
+		}
+		if err != nil {
+			detail = err.Error()
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("service '%s' did not become healthy within %s: %s", serviceName, timeout, detail)
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+// servicesOn returns the names of the services deployed to a server, sorted
+func servicesOn(config *config.Config, serverName string) []string {
+	var names []string
+	for name, service := range config.Services {
+		if containsString(service.Servers, serverName) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// showNexusState prints the recorded deployments on the servers of a nexus
+func showNexusState(nexusName, serviceName string, history, asJSON bool) error {
+	config := configManager.GetConfig()
+	if config == nil {
+		return fmt.Errorf("no configuration loaded")
+	}
+
+	nexusConfig := config.Nexuses[nexusName]
+	if nexusConfig == nil {
+		return fmt.Errorf("nexus '%s' not found", nexusName)
+	}
+
+	store := state.NewStore(configManager.GetRuntimeConfig().StateDir)
+	current, err := store.Servers(nexusConfig.Servers)
+	if err != nil {
+		return err
+	}
+
+	var deployments []pkg.Deployment
+	for _, deployment := range current {
+		if serviceName != "" && deployment.Service != serviceName {
+			continue
+		}
+		if !history {
+			deployments = append(deployments, deployment)
+			continue
+		}
+
+		revisions, err := store.History(deployment.Server, deployment.Service)
+		if err != nil {
+			return err
+		}
+		for i := len(revisions) - 1; i >= 0; i-- {
+			deployments = append(deployments, revisions[i])
+		}
+	}
+
+	if asJSON {
+		if deployments == nil {
+			deployments = []pkg.Deployment{}
+		}
+		data, err := json.MarshalIndent(deployments, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to encode deployment state: %w", err)
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+
+	if len(deployments) == 0 {
+		color.Yellow("⚠️  No deployments recorded for nexus '%s'", nexusName)
+		return nil
+	}
+
+	fmt.Printf("📦 Deployments in nexus '%s':\n\n", nexusName)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+		color.CyanString("SERVER"),
+		color.CyanString("SERVICE"),
+		color.CyanString("IMAGE"),
+		color.CyanString("DIGEST"),
+		color.CyanString("COMPOSE"),
+		color.CyanString("ENV"),
+		color.CyanString("DEPLOYED"),
+		color.CyanString("BY"))
+	for _, deployment := range deployments {
+		digest := shortHash(deployment.ImageDigest)
+		if digest == "" {
+			digest = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			deployment.Server,
+			deployment.Service,
+			deployment.Image,
+			digest,
+			shortHash(deployment.ComposeHash),
+			shortHash(deployment.EnvHash),
+			deployment.DeployedAt.Local().Format("2006-01-02 15:04:05"),
+			deployment.DeployedBy)
+	}
+	w.Flush()
+
+	return nil
+}
+
+// shortHash shortens a "sha256:<hex>" hash for display
+func shortHash(hash string) string {
+	hash = strings.TrimPrefix(hash, "sha256:")
+	if len(hash) > 12 {
+		hash = hash[:12]
+	}
+	return hash
+}
+
+// promotion is a service revision about to be deployed to a target nexus
+type promotion struct {
+	source  pkg.Deployment
+	current *pkg.Deployment // on the target, nil when never deployed there
+	config  *pkg.ServiceConfig
+}
+
+// promoteNexus deploys the revisions recorded in the source nexus to the
+// servers of the target nexus
+func promoteNexus(sourceName, targetName string, only []string, yes bool) error {
+	config := configManager.GetConfig()
+	if config == nil {
+		return fmt.Errorf("no configuration loaded")
+	}
+
+	source := config.Nexuses[sourceName]
+	if source == nil {
+		return fmt.Errorf("nexus '%s' not found", sourceName)
+	}
+	target := config.Nexuses[targetName]
+	if target == nil {
+		return fmt.Errorf("nexus '%s' not found", targetName)
+	}
+	if sourceName == targetName {
+		return fmt.Errorf("source and target nexus are the same")
+	}
+
+	store := state.NewStore(configManager.GetRuntimeConfig().StateDir)
+	deployed, err := store.Servers(source.Servers)
+	if err != nil {
+		return err
+	}
+
+	// Every service deployed in the source, unless --services narrows it down
+	serviceNames := only
+	if len(serviceNames) == 0 {
+		for _, deployment := range deployed {
+			if !containsString(serviceNames, deployment.Service) && len(promotionTargets(config, target, deployment.Service)) > 0 {
+				serviceNames = append(serviceNames, deployment.Service)
+			}
+		}
+	}
+	if len(serviceNames) == 0 {
+		color.Yellow("⚠️  Nothing to promote: no services deployed in '%s' run in '%s'", sourceName, targetName)
+		return nil
+	}
+	sort.Strings(serviceNames)
+
+	var promotions []promotion
+	for _, serviceName := range serviceNames {
+		p, err := planPromotion(config, store, deployed, sourceName, targetName, serviceName)
+		if err != nil {
+			return err
+		}
+		promotions = append(promotions, p)
+	}
+
+	fmt.Printf("🚢 Promoting from '%s' to '%s':\n", sourceName, targetName)
+	for _, p := range promotions {
+		from := "not deployed"
+		if p.current != nil {
+			from = p.current.Image
+			if p.current.ImageDigest == p.source.ImageDigest {
+				from = "same image"
+			}
+		}
+		fmt.Printf("   %s: %s (%s)\n", p.config.Name, p.config.Image, from)
+		fmt.Printf("      servers: %s\n", strings.Join(p.config.Servers, ", "))
+	}
+
+	if !yes {
+		fmt.Print("Start promoting? [y/N]: ")
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		answer = strings.ToLower(strings.TrimSpace(answer))
+		if answer != "y" && answer != "yes" {
+			fmt.Println("Nothing promoted.")
+			return nil
+		}
+	}
+
+	for _, p := range promotions {
+		servers, err := connectServers(context.Background(), p.config.Servers, true)
+		if err != nil {
+			return err
+		}
+
+		dockerProvider := docker.NewProvider(servers, config).RecordTo(store)
+		if err := dockerProvider.Deploy(p.config); err != nil {
+			return fmt.Errorf("promoting service '%s' failed: %w", p.config.Name, err)
+		}
+	}
+
+	color.Green("✅ Promoted %d services from '%s' to '%s'", len(promotions), sourceName, targetName)
+	return nil
+}
+
+// planPromotion builds the deployment that ships a service's source
+// revision to the target nexus. The servers of the source must agree on the
+// revision, and its image must have a registry digest to pin.
+func planPromotion(config *config.Config, store *state.Store, deployed []pkg.Deployment, sourceName, targetName, serviceName string) (promotion, error) {
+	service := config.ServiceIn(targetName, serviceName)
+	if service == nil {
+		return promotion{}, fmt.Errorf("service '%s' not found in configuration", serviceName)
+	}
+
+	var revision *pkg.Deployment
+	for i, deployment := range deployed {
+		if deployment.Service != serviceName {
+			continue
+		}
+		if revision == nil {
+			revision = &deployed[i]
+			continue
+		}
+		if deployment.ImageDigest != revision.ImageDigest || deployment.ComposeHash != revision.ComposeHash {
+			return promotion{}, fmt.Errorf("service '%s' differs between %s and %s in nexus '%s'; deploy it again before promoting",
+				serviceName, revision.Server, deployment.Server, sourceName)
+		}
+	}
+	if revision == nil {
+		return promotion{}, fmt.Errorf("service '%s' has no recorded deployment in nexus '%s'", serviceName, sourceName)
+	}
+	if revision.ImageDigest == "" || revision.Config == nil {
+		return promotion{}, fmt.Errorf("service '%s' in nexus '%s' has no registry digest recorded; only pulled images can be promoted", serviceName, sourceName)
+	}
+
+	serverNames := promotionTargets(config, config.Nexuses[targetName], serviceName)
+	if len(serverNames) == 0 {
+		return promotion{}, fmt.Errorf("service '%s' has no servers in nexus '%s'", serviceName, targetName)
+	}
+
+	// The recorded configuration, with the target's servers, domains and
+	// environment
+	serviceConfig := *revision.Config
+	serviceConfig.Name = serviceName
+	serviceConfig.Image = docker.PinnedImage(revision.Image, revision.ImageDigest)
+	serviceConfig.Servers = serverNames
+	serviceConfig.Domains = service.Domains
+	serviceConfig.Environment = service.Environment
+
+	current, err := store.Latest(serverNames[0], serviceName)
+	if err != nil {
+		return promotion{}, err
+	}
+
+	return promotion{source: *revision, current: current, config: &serviceConfig}, nil
+}
+
+// promotionTargets returns the servers of a nexus that run a service
+func promotionTargets(config *config.Config, nexus *config.Nexus, serviceName string) []string {
+	service := config.Services[serviceName]
+	if service == nil {
+		return nil
+	}
+
+	var serverNames []string
+	for _, serverName := range service.Servers {
+		if containsString(nexus.Servers, serverName) {
+			serverNames = append(serverNames, serverName)
+		}
+	}
+	return serverNames
+}
+
+// diffNexuses prints the differences between two nexuses
+func diffNexuses(left, right string, asJSON, exitCode, offline bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	diff, err := nexusManager.Diff(ctx, left, right, !offline)
+	if err != nil {
+		return err
+	}
+
+	if asJSON {
+		if diff.Differences == nil {
+			diff.Differences = []nexus.Difference{}
+		}
+		data, err := json.MarshalIndent(diff, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to encode differences: %w", err)
+		}
+		fmt.Println(string(data))
+	} else if len(diff.Differences) == 0 {
+		color.Green("✅ Nexuses '%s' and '%s' do not differ", left, right)
+	} else {
+		fmt.Printf("🔍 Differences between '%s' and '%s':\n\n", left, right)
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n",
+			color.CyanString("SERVICE"),
+			color.CyanString("FIELD"),
+			color.CyanString(strings.ToUpper(left)),
+			color.CyanString(strings.ToUpper(right)))
+		for _, difference := range diff.Differences {
+			service := difference.Service
+			if service == "" {
+				service = "(nexus)"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n",
+				service,
+				difference.Field,
+				orDash(difference.Left),
+				orDash(difference.Right))
+		}
+		w.Flush()
+	}
+
+	if exitCode && len(diff.Differences) > 0 {
+		return &exitCodeError{code: 1}
+	}
+	return nil
+}
+
+// orDash shows empty values as a dash
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
@@ -1,12 +1,23 @@
 package main
 
 import (
+	"bufio"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
 	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
 
 	"github.com/fatih/color"
 	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh"
 
 	"github.com/jonas-jonas/mah/internal/config"
 	"github.com/jonas-jonas/mah/internal/server"
@@ -58,10 +69,169 @@ var serverInitCmd = &cobra.Command{
 	},
 }
 
+var serverTrustCmd = &cobra.Command{
+	Use:   "trust <server-name>",
+	Short: "Verify and record a server's SSH host key",
+	Long: `Connect to a server, show the fingerprint of its SSH host key and record it
+in MAH's known_hosts file (~/.mah/state/known_hosts) once confirmed.
+
+Compare the fingerprint with the one reported on the server itself, e.g.:
+  ssh-keygen -lf /etc/ssh/ssh_host_ed25519_key.pub`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		yes, _ := cmd.Flags().GetBool("yes")
+		return trustServer(args[0], yes)
+	},
+}
+
+var serverSSHCmd = &cobra.Command{
+	Use:   "ssh <server-name> [-- command...]",
+	Short: "Open an interactive shell on a server",
+	Long: `Open an interactive terminal session on a server using the credentials, jump
+hosts and host key settings from mah.yaml. Without a command a login shell is
+started; with --sudo it becomes a root shell, using the server's sudo or become
+settings.
+
+Examples:
+  mah server ssh web1
+  mah server ssh web1 --sudo
+  mah server ssh web1 -- htop`,
+	Args:          cobra.MinimumNArgs(1),
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		sudo, _ := cmd.Flags().GetBool("sudo")
+		return sshServer(args[0], args[1:], sudo)
+	},
+}
+
+var serverTunnelCmd = &cobra.Command{
+	Use:   "tunnel <server-name> <[bind:]port:host:hostport>...",
+	Short: "Forward ports over a server's SSH connection",
+	Long: `Forward ports over a server's SSH connection, like ssh -L. Each forward listens
+locally on port and connects to host:hostport as seen from the server. With
+--reverse the server listens on port and connects back to host:hostport on
+this machine, like ssh -R. Press Ctrl-C to stop forwarding.
+
+Examples:
+  mah server tunnel db1 5432:localhost:5432
+  mah server tunnel web1 8080:10.0.0.5:80 9090:10.0.0.6:9090
+  mah server tunnel web1 --reverse 9000:localhost:3000`,
+	Args: cobra.MinimumNArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		reverse, _ := cmd.Flags().GetBool("reverse")
+		return tunnelServer(args[0], args[1:], reverse)
+	},
+}
+
+var serverFactsCmd = &cobra.Command{
+	Use:   "facts <server-name>",
+	Short: "Show facts gathered from a server",
+	Long: `Show hardware, kernel, mounts, network interfaces, listening ports, cgroup and
+Docker versions of a server. Facts are gathered with a single command and
+cached in ~/.mah/state/facts; --refresh gathers them again.
+
+Examples:
+  mah server facts web1
+  mah server facts web1 --refresh
+  mah server facts web1 --json`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		refresh, _ := cmd.Flags().GetBool("refresh")
+		asJSON, _ := cmd.Flags().GetBool("json")
+		return showServerFacts(args[0], refresh, asJSON)
+	},
+}
+
+var serverBansCmd = &cobra.Command{
+	Use:   "bans <server-name>",
+	Short: "List or lift fail2ban bans on a server",
+	Long: `List the addresses fail2ban has banned on a server, by jail. --unban lifts
+the ban on an address in every jail, or only in the jail given by --jail.
+
+Examples:
+  mah server bans web1
+  mah server bans web1 --unban 203.0.113.7
+  mah server bans web1 --unban 203.0.113.7 --jail sshd`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		unban, _ := cmd.Flags().GetString("unban")
+		jail, _ := cmd.Flags().GetString("jail")
+		if unban == "" && jail != "" {
+			return fmt.Errorf("--jail requires --unban")
+		}
+		return serverBans(args[0], unban, jail)
+	},
+}
+
+var serverDockerConfigCmd = &cobra.Command{
+	Use:   "docker-config",
+	Short: "Manage the Docker daemon configuration of servers",
+	Long:  "Docker config commands render the docker section of mah.yaml to /etc/docker/daemon.json.",
+}
+
+var serverDockerConfigApplyCmd = &cobra.Command{
+	Use:   "apply <server-name>",
+	Short: "Write daemon.json on a server and restart Docker if it changed",
+	Long: `Render the docker section of the server, or of its nexus, to
+/etc/docker/daemon.json. dockerd validates the file before it is put in place,
+and Docker is only restarted when the file changed.
+
+Examples:
+  mah server docker-config apply web1`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return applyDockerConfig(args[0])
+	},
+}
+
+var serverUsersCmd = &cobra.Command{
+	Use:   "users",
+	Short: "Manage team accounts on servers",
+	Long:  "User commands keep the team accounts and SSH keys listed under users in mah.yaml in place on servers.",
+}
+
+var serverUsersSyncCmd = &cobra.Command{
+	Use:   "sync",
+	Short: "Create, update or remove team accounts on every server in a nexus",
+	Long: `Compare the team accounts on every server in a nexus with the users section
+of mah.yaml, show the differences and apply them after confirmation. Accounts
+are created with the configured keys as their authorized_keys, keys are
+replaced when they changed, and accounts of members who lost access are
+removed. Only accounts in the mah-users group are ever removed.
+
+Examples:
+  mah server users sync
+  mah server users sync --nexus prod --yes`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		yes, _ := cmd.Flags().GetBool("yes")
+		return syncUsers(yes)
+	},
+}
+
 func init() {
 	serverCmd.AddCommand(serverListCmd)
 	serverCmd.AddCommand(serverStatusCmd)
 	serverCmd.AddCommand(serverInitCmd)
+	serverCmd.AddCommand(serverTrustCmd)
+	serverCmd.AddCommand(serverSSHCmd)
+	serverCmd.AddCommand(serverTunnelCmd)
+	serverCmd.AddCommand(serverFactsCmd)
+	serverCmd.AddCommand(serverBansCmd)
+	serverCmd.AddCommand(serverDockerConfigCmd)
+	serverCmd.AddCommand(serverUsersCmd)
+	serverDockerConfigCmd.AddCommand(serverDockerConfigApplyCmd)
+	serverUsersCmd.AddCommand(serverUsersSyncCmd)
+
+	serverTrustCmd.Flags().BoolP("yes", "y", false, "Record the key without asking for confirmation")
+	serverSSHCmd.Flags().Bool("sudo", false, "Run the shell or command with sudo")
+	serverTunnelCmd.Flags().BoolP("reverse", "R", false, "Listen on the server and forward to this machine")
+	serverFactsCmd.Flags().Bool("refresh", false, "Gather facts again instead of using the cache")
+	serverFactsCmd.Flags().Bool("json", false, "Print facts as JSON")
+	serverBansCmd.Flags().String("unban", "", "Lift the ban on this IP address")
+	serverBansCmd.Flags().String("jail", "", "Only lift the ban in this jail")
+	serverUsersSyncCmd.Flags().BoolP("yes", "y", false, "Apply the changes without asking for confirmation")
 }
 
 // initializeServer initializes a server with Docker, firewall, and security hardening
@@ -78,26 +248,31 @@ func initializeServer(serverName string) error {
 
 	fmt.Printf("🚀 Initializing server '%s' (%s)...\n", serverName, serverConfig.Host)
 
-	// Create server instance
-	factory := server.NewFactory()
 	ctx := context.Background()
-	
-	srv, err := factory.CreateServerWithDistroDetection(ctx, serverName, serverConfig)
+
+	// Connect to server first (the connection is shared for the whole command)
+	fmt.Print("🔗 Connecting to server... ")
+	srv, err := nexusManager.Server(ctx, serverName)
 	if err != nil {
-		return fmt.Errorf("failed to create server instance: %w", err)
+		color.Red("FAILED")
+		return err
 	}
-	defer srv.Disconnect()
+	color.Green("OK")
 
+	// Detect distribution if not specified
+	if serverConfig.Distro == "" {
+		distro, err := srv.GetDistro(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to detect distribution: %w", err)
+		}
+		serverConfig.Distro = distro
+	}
 	fmt.Printf("📡 Detected distribution: %s\n", serverConfig.Distro)
 
-	// Connect to server first
-	fmt.Print("🔗 Connecting to server... ")
-	err = srv.Connect(ctx)
+	ops, err := server.NewFactory().Operations(srv, serverConfig.Distro)
 	if err != nil {
-		color.Red("FAILED")
-		return fmt.Errorf("failed to connect to server: %w", err)
+		return err
 	}
-	color.Green("OK")
 
 	// Perform health check
 	fmt.Print("🔍 Performing health check... ")
@@ -109,27 +284,38 @@ func initializeServer(serverName string) error {
 	color.Green("OK")
 
 	// Update system packages
-	fmt.Print("📦 Updating system packages... ")
-	err = updateSystemPackages(ctx, srv, serverConfig.Distro)
+	fmt.Println("📦 Updating system packages...")
+	err = ops.UpdateSystem(ctx)
 	if err != nil {
-		color.Red("FAILED")
+		color.Red("📦 Package update FAILED")
 		return fmt.Errorf("failed to update system packages: %w", err)
 	}
-	color.Green("OK")
+	color.Green("📦 Package update OK")
 
 	// Install Docker
 	fmt.Print("🐳 Installing Docker... ")
-	err = installDocker(ctx, srv, serverConfig.Distro)
+	err = ops.InstallDocker(ctx)
 	if err != nil {
 		color.Red("FAILED")
 		return fmt.Errorf("failed to install Docker: %w", err)
 	}
 	color.Green("OK")
 
+	// Configure the Docker daemon
+	if docker := config.DockerFor(serverName); docker != nil {
+		fmt.Print("🐳 Configuring Docker daemon... ")
+		err = ops.ConfigureDocker(ctx, dockerDaemon(docker))
+		if err != nil {
+			color.Red("FAILED")
+			return fmt.Errorf("failed to configure Docker daemon: %w", err)
+		}
+		color.Green("OK")
+	}
+
 	// Configure firewall
 	if config.Firewall != nil {
 		fmt.Print("🔥 Configuring firewall... ")
-		err = configureFirewall(ctx, srv, serverName, config, serverConfig.Distro)
+		err = ops.ConfigureFirewall(ctx, firewallRules(serverName, config))
 		if err != nil {
 			color.Red("FAILED")
 			return fmt.Errorf("failed to configure firewall: %w", err)
@@ -137,19 +323,45 @@ func initializeServer(serverName string) error {
 		color.Green("OK")
 	}
 
-	// Harden SSH
-	fmt.Print("🔐 Hardening SSH configuration... ")
-	err = hardenSSH(ctx, srv, serverConfig.Distro)
-	if err != nil {
-		color.Yellow("WARNING")
-		fmt.Printf("   SSH hardening failed (continuing): %v\n", err)
+	// Harden SSH (local servers are not reached over SSH)
+	if serverConfig.IsLocal() {
+		fmt.Println("🔐 Skipping SSH hardening for local server")
 	} else {
+		fmt.Print("🔐 Hardening SSH configuration... ")
+		policy := sshPolicy(config.SSHHardeningFor(serverName))
+		policy.TestLogin = func(ctx context.Context, port int) error {
+			return server.CheckLogin(ctx, srv, port)
+		}
+		err = ops.HardenSSH(ctx, policy)
+		if err != nil {
+			color.Yellow("WARNING")
+			fmt.Printf("   SSH hardening failed (continuing): %v\n", err)
+		} else {
+			color.Green("OK")
+			if policy.Port != 0 && policy.Port != serverConfig.SSHPort {
+				color.Yellow("   sshd now listens on port %d; set ssh_port: %d for '%s' in mah.yaml", policy.Port, policy.Port, serverName)
+			}
+		}
+	}
+
+	// Configure intrusion protection
+	if security := config.SecurityFor(serverName); security != nil && security.Fail2ban != nil {
+		fmt.Print("🛡️  Configuring fail2ban... ")
+		sshPort := serverConfig.SSHPort
+		if hardening := config.SSHHardeningFor(serverName); hardening != nil && hardening.Port != 0 {
+			sshPort = hardening.Port
+		}
+		err = ops.ConfigureFail2ban(ctx, fail2banPolicy(security.Fail2ban, sshPort))
+		if err != nil {
+			color.Red("FAILED")
+			return fmt.Errorf("failed to configure fail2ban: %w", err)
+		}
 		color.Green("OK")
 	}
 
 	// Configure automatic updates
 	fmt.Print("🔄 Configuring automatic updates... ")
-	err = configureAutomaticUpdates(ctx, srv, serverConfig.Distro)
+	err = ops.ConfigureAutomaticUpdates(ctx)
 	if err != nil {
 		color.Yellow("WARNING")
 		fmt.Printf("   Automatic updates failed (continuing): %v\n", err)
@@ -163,8 +375,8 @@ func initializeServer(serverName string) error {
 	return nil
 }
 
-// showServerStatus shows status for a specific server
-func showServerStatus(serverName string) error {
+// trustServer fetches a server's host key and records it after confirmation
+func trustServer(serverName string, yes bool) error {
 	config := configManager.GetConfig()
 	if config == nil {
 		return fmt.Errorf("no configuration loaded")
@@ -175,22 +387,154 @@ func showServerStatus(serverName string) error {
 		return fmt.Errorf("server '%s' not found in configuration", serverName)
 	}
 
-	// Create server instance
-	factory := server.NewFactory()
+	if serverConfig.IsLocal() {
+		return fmt.Errorf("server '%s' uses the local transport and has no SSH host key", serverName)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	hostKey, addr, err := server.FetchHostKey(ctx, serverConfig)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("🔑 Host key for '%s' (%s):\n", serverName, addr)
+	fmt.Printf("   Type:        %s\n", hostKey.Type())
+	fmt.Printf("   Fingerprint: %s\n", color.CyanString(ssh.FingerprintSHA256(hostKey)))
+
+	if serverConfig.HostKey != "" {
+		color.Yellow("⚠️  This server pins host_key in mah.yaml; the pin takes precedence over known_hosts.")
+	}
+
+	if !yes {
+		fmt.Print("Trust this key? [y/N]: ")
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		answer = strings.ToLower(strings.TrimSpace(answer))
+		if answer != "y" && answer != "yes" {
+			fmt.Println("Host key not recorded.")
+			return nil
+		}
+	}
+
+	if err := server.TrustHostKey(serverConfig, hostKey); err != nil {
+		return fmt.Errorf("failed to record host key: %w", err)
+	}
+
+	color.Green("✅ Host key for '%s' recorded", serverName)
+	return nil
+}
+
+// tunnelServer forwards ports over a server's SSH connection until Ctrl-C
+func tunnelServer(serverName string, specs []string, reverse bool) error {
+	var forwards []server.Forward
+	for _, spec := range specs {
+		forward, err := server.ParseForward(spec, reverse)
+		if err != nil {
+			return err
+		}
+		forwards = append(forwards, forward)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	srv, err := nexusManager.Server(ctx, serverName)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("🔀 Tunnelling through server '%s'\n", serverName)
+	return runForwards(ctx, srv, forwards)
+}
+
+// runForwards serves port forwards concurrently until ctx is cancelled
+func runForwards(ctx context.Context, srv pkg.Server, forwards []server.Forward) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Open all listeners first, so a port in use fails before anything runs
+	var forwarders []*server.Forwarder
+	for _, forward := range forwards {
+		forwarder, err := server.NewForwarder(ctx, srv, forward)
+		if err != nil {
+			for _, opened := range forwarders {
+				opened.Close()
+			}
+			return err
+		}
+		forwarders = append(forwarders, forwarder)
+		fmt.Printf("   %s\n", forward)
+	}
+	fmt.Println("Press Ctrl-C to stop.")
+
+	errs := make(chan error, len(forwarders))
+	for _, forwarder := range forwarders {
+		go func(forwarder *server.Forwarder) {
+			errs <- forwarder.Serve(ctx)
+		}(forwarder)
+	}
+
+	// A failing forward stops the others
+	var firstErr error
+	for range forwarders {
+		if err := <-errs; err != nil && firstErr == nil {
+			firstErr = err
+			cancel()
+		}
+	}
+
+	if firstErr == nil {
+		color.Green("✅ Forwarding stopped")
+	}
+	return firstErr
+}
+
+// sshServer opens an interactive terminal session on a server
+func sshServer(serverName string, command []string, sudo bool) error {
 	ctx := context.Background()
-	
-	srv, err := factory.CreateServer(serverName, serverConfig)
+
+	srv, err := nexusManager.Server(ctx, serverName)
 	if err != nil {
-		return fmt.Errorf("failed to create server instance: %w", err)
+		return err
 	}
-	defer srv.Disconnect()
+
+	result, err := srv.Interactive(ctx, strings.Join(command, " "), pkg.StreamOptions{
+		Sudo:   sudo,
+		Stdin:  os.Stdin,
+		Stdout: os.Stdout,
+		Stderr: os.Stderr,
+	})
+	if err != nil {
+		return err
+	}
+
+	if result.ExitCode != 0 {
+		return &exitCodeError{code: result.ExitCode}
+	}
+	return nil
+}
+
+// showServerStatus shows status for a specific server
+func showServerStatus(serverName string) error {
+	config := configManager.GetConfig()
+	if config == nil {
+		return fmt.Errorf("no configuration loaded")
+	}
+
+	serverConfig := config.Servers[serverName]
+	if serverConfig == nil {
+		return fmt.Errorf("server '%s' not found in configuration", serverName)
+	}
+
+	ctx := context.Background()
 
 	fmt.Printf("📊 Server Status: %s (%s)\n", serverName, serverConfig.Host)
 	fmt.Println("─────────────────────────────────────")
 
 	// Test connectivity
 	fmt.Print("🔗 Connectivity: ")
-	err = srv.Connect(ctx)
+	srv, err := nexusManager.Server(ctx, serverName)
 	if err != nil {
 		color.Red("DISCONNECTED")
 		fmt.Printf("   Error: %v\n", err)
@@ -272,42 +616,362 @@ func showAllServerStatus() error {
 	return nil
 }
 
-// Helper functions for different distributions
+// showServerFacts prints the facts of a server, gathering them unless cached
+func showServerFacts(serverName string, refresh, asJSON bool) error {
+	config := configManager.GetConfig()
+	if config == nil {
+		return fmt.Errorf("no configuration loaded")
+	}
 
-func updateSystemPackages(ctx context.Context, srv pkg.Server, distro string) error {
-	switch strings.ToLower(distro) {
-	case "ubuntu":
-		ops := server.NewUbuntuOperations(srv)
-		return ops.UpdateSystem(ctx)
-	case "debian":
-		ops := server.NewDebianOperations(srv)
-		return ops.UpdateSystem(ctx)
-	case "rocky", "rhel", "centos", "fedora":
-		ops := server.NewRockyOperations(srv)
-		return ops.UpdateSystem(ctx)
-	default:
-		return fmt.Errorf("unsupported distribution: %s", distro)
+	serverConfig := config.Servers[serverName]
+	if serverConfig == nil {
+		return fmt.Errorf("server '%s' not found in configuration", serverName)
 	}
+
+	stateDir := configManager.GetRuntimeConfig().StateDir
+
+	var facts *pkg.ResourceInfo
+	cached := false
+	if !refresh {
+		if info, err := server.LoadFacts(stateDir, serverName); err == nil {
+			facts = info
+			cached = true
+		} else if !errors.Is(err, os.ErrNotExist) {
+			color.Yellow("⚠️  Ignoring cached facts: %v", err)
+		}
+	}
+
+	if facts == nil {
+		ctx := context.Background()
+
+		srv, err := nexusManager.Server(ctx, serverName)
+		if err != nil {
+			return err
+		}
+
+		facts, err = srv.GetResources(ctx)
+		if err != nil {
+			return err
+		}
+
+		if err := server.SaveFacts(stateDir, serverName, facts); err != nil {
+			color.Yellow("⚠️  Failed to cache facts: %v", err)
+		}
+	}
+
+	if asJSON {
+		data, err := json.MarshalIndent(facts, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to encode facts: %w", err)
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+
+	source := "gathered now"
+	if cached {
+		source = fmt.Sprintf("cached %s ago, use --refresh to update", time.Since(facts.GatheredAt).Round(time.Second))
+	}
+
+	fmt.Printf("📋 Server Facts: %s (%s)\n", serverName, serverConfig.Host)
+	fmt.Printf("   %s (%s)\n", facts.GatheredAt.Local().Format("2006-01-02 15:04:05"), source)
+	fmt.Println("─────────────────────────────────────")
+
+	fmt.Printf("🖥️  Host: %s, kernel %s (%s), up %s, cgroup v%d\n",
+		facts.Hostname, facts.Kernel, facts.CPU.Arch, formatUptime(facts.Uptime), facts.CgroupVersion)
+	fmt.Printf("⚙️  CPU: %d cores, %s (%.1f%% used)\n", facts.CPU.Cores, facts.CPU.Model, facts.CPU.Usage)
+	fmt.Printf("🧠 Memory: %s (%.1f%% used)\n", formatBytes(facts.Memory.Total), facts.Memory.Usage)
+	fmt.Printf("📈 Load: %.2f, %.2f, %.2f\n", facts.Load.Load1, facts.Load.Load5, facts.Load.Load15)
+
+	if facts.Docker != "" {
+		compose := "not installed"
+		if facts.Compose != "" {
+			compose = facts.Compose
+		}
+		fmt.Printf("🐳 Docker: %s, compose %s\n", facts.Docker, compose)
+	} else {
+		fmt.Println("🐳 Docker: not installed")
+	}
+
+	fmt.Println("💾 Mounts:")
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, mount := range facts.Mounts {
+		fmt.Fprintf(w, "   %s\t%s\t%s\t%.1f%% used\t%s\n",
+			mount.Path, mount.FSType, formatBytes(mount.Total), mount.Usage, mount.Device)
+	}
+	w.Flush()
+
+	fmt.Println("🌐 Interfaces:")
+	for _, iface := range facts.Interfaces {
+		fmt.Fprintf(w, "   %s\t%s\t%s\t%s\n", iface.Name, iface.State, iface.MAC, strings.Join(iface.Addresses, ", "))
+	}
+	w.Flush()
+
+	fmt.Println("🔌 Listening ports:")
+	for _, port := range facts.ListeningPorts {
+		fmt.Fprintf(w, "   %s\t%s\n", port.Protocol, net.JoinHostPort(port.Address, strconv.Itoa(port.Port)))
+	}
+	w.Flush()
+
+	return nil
 }
 
-func installDocker(ctx context.Context, srv pkg.Server, distro string) error {
-	switch strings.ToLower(distro) {
-	case "ubuntu":
-		ops := server.NewUbuntuOperations(srv)
-		return ops.InstallDocker(ctx)
-	case "debian":
-		ops := server.NewDebianOperations(srv)
-		return ops.InstallDocker(ctx)
-	case "rocky", "rhel", "centos", "fedora":
-		ops := server.NewRockyOperations(srv)
-		return ops.InstallDocker(ctx)
-	default:
-		return fmt.Errorf("unsupported distribution: %s", distro)
+// serverBans lists the fail2ban bans on a server, or lifts one
+func serverBans(serverName, unban, jail string) error {
+	config := configManager.GetConfig()
+	if config == nil {
+		return fmt.Errorf("no configuration loaded")
+	}
+
+	if config.Servers[serverName] == nil {
+		return fmt.Errorf("server '%s' not found in configuration", serverName)
+	}
+
+	ctx := context.Background()
+
+	srv, err := nexusManager.Server(ctx, serverName)
+	if err != nil {
+		return err
+	}
+
+	if unban != "" {
+		if err := server.Unban(ctx, srv, unban, jail); err != nil {
+			return err
+		}
+		color.Green("✅ Unbanned %s on '%s'", unban, serverName)
+		return nil
 	}
+
+	bans, err := server.ListBans(ctx, srv)
+	if err != nil {
+		return err
+	}
+
+	if len(bans) == 0 {
+		fmt.Printf("🛡️  No banned addresses on '%s'\n", serverName)
+		return nil
+	}
+
+	fmt.Printf("🛡️  Banned addresses on '%s':\n", serverName)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "   JAIL\tIP")
+	for _, ban := range bans {
+		fmt.Fprintf(w, "   %s\t%s\n", ban.Jail, ban.IP)
+	}
+	w.Flush()
+
+	return nil
 }
 
-func configureFirewall(ctx context.Context, srv pkg.Server, serverName string, config *config.Config, distro string) error {
-	// Collect firewall rules
+// applyDockerConfig writes the Docker daemon configuration of a server
+func applyDockerConfig(serverName string) error {
+	config := configManager.GetConfig()
+	if config == nil {
+		return fmt.Errorf("no configuration loaded")
+	}
+
+	serverConfig := config.Servers[serverName]
+	if serverConfig == nil {
+		return fmt.Errorf("server '%s' not found in configuration", serverName)
+	}
+
+	docker := config.DockerFor(serverName)
+	if docker == nil {
+		return fmt.Errorf("no docker section configured for '%s' or its nexus", serverName)
+	}
+
+	ctx := context.Background()
+
+	srv, err := nexusManager.Server(ctx, serverName)
+	if err != nil {
+		return err
+	}
+
+	if serverConfig.Distro == "" {
+		distro, err := srv.GetDistro(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to detect distribution: %w", err)
+		}
+		serverConfig.Distro = distro
+	}
+
+	ops, err := server.NewFactory().Operations(srv, serverConfig.Distro)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("🐳 Configuring Docker daemon on '%s'... ", serverName)
+	if err := ops.ConfigureDocker(ctx, dockerDaemon(docker)); err != nil {
+		color.Red("FAILED")
+		return fmt.Errorf("failed to configure Docker daemon: %w", err)
+	}
+	color.Green("OK")
+
+	return nil
+}
+
+// userPlan holds the account changes for one server
+type userPlan struct {
+	serverName string
+	srv        pkg.Server
+	changes    []pkg.UserChange
+}
+
+// syncUsers reconciles the team accounts on every server in the current
+// nexus with the users section of the configuration
+func syncUsers(yes bool) error {
+	config := configManager.GetConfig()
+	if config == nil {
+		return fmt.Errorf("no configuration loaded")
+	}
+
+	current, err := nexusManager.GetCurrent()
+	if err != nil {
+		return fmt.Errorf("no current nexus set; use --nexus")
+	}
+	nexusName := current.Name
+	nexus, ok := config.Nexuses[nexusName]
+	if !ok || nexus == nil {
+		return fmt.Errorf("nexus '%s' not found in configuration", nexusName)
+	}
+
+	var desired []pkg.UserAccount
+	for _, name := range config.UsersFor(nexusName) {
+		user := config.Users[name]
+		desired = append(desired, pkg.UserAccount{Name: name, Shell: user.Shell, Keys: user.Keys})
+	}
+
+	ctx := context.Background()
+
+	// Work out the changes for every server before touching any of them
+	var plans []userPlan
+	failed := 0
+	for _, serverName := range nexus.Servers {
+		serverConfig, ok := config.Servers[serverName]
+		if !ok || serverConfig == nil {
+			return fmt.Errorf("server '%s' not found in configuration", serverName)
+		}
+		if serverConfig.IsLocal() {
+			color.Yellow("⚠️  Skipping '%s': team accounts are only managed over SSH", serverName)
+			continue
+		}
+
+		srv, err := nexusManager.Server(ctx, serverName)
+		if err == nil {
+			var current []pkg.UserAccount
+			if current, err = server.ListTeamUsers(ctx, srv); err == nil {
+				plans = append(plans, userPlan{serverName, srv, server.PlanUsers(desired, current)})
+				continue
+			}
+		}
+		color.Red("❌ %s: %v", serverName, err)
+		failed++
+	}
+
+	pending := 0
+	for _, plan := range plans {
+		if len(plan.changes) == 0 {
+			fmt.Printf("✅ %s: in sync\n", plan.serverName)
+			continue
+		}
+		pending += len(plan.changes)
+		fmt.Printf("📋 %s:\n", plan.serverName)
+		printUserChanges(plan.changes)
+	}
+
+	if pending > 0 {
+		if !yes {
+			fmt.Print("Apply these changes? [y/N]: ")
+			answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+			answer = strings.ToLower(strings.TrimSpace(answer))
+			if answer != "y" && answer != "yes" {
+				fmt.Println("No changes applied.")
+				return nil
+			}
+		}
+
+		for _, plan := range plans {
+			for _, change := range plan.changes {
+				if err := server.ApplyUserChange(ctx, plan.srv, change); err != nil {
+					color.Red("❌ %s: %v", plan.serverName, err)
+					failed++
+					continue
+				}
+				color.Green("✅ %s: %sd %s", plan.serverName, change.Action, change.Account.Name)
+			}
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("team accounts on nexus '%s' are not fully in sync (%d errors)", nexusName, failed)
+	}
+	if pending == 0 {
+		color.Green("✅ Team accounts on nexus '%s' are in sync", nexusName)
+	}
+	return nil
+}
+
+// printUserChanges prints account changes as a diff
+func printUserChanges(changes []pkg.UserChange) {
+	for _, change := range changes {
+		switch change.Action {
+		case pkg.UserCreate:
+			fmt.Println(color.GreenString("   + %s", change.Account.Name))
+		case pkg.UserUpdate:
+			fmt.Println(color.YellowString("   ~ %s", change.Account.Name))
+		case pkg.UserRemove:
+			fmt.Println(color.RedString("   - %s (home directory is kept)", change.Account.Name))
+		}
+		for _, key := range change.AddKeys {
+			fmt.Println(color.GreenString("       + %s", describeKey(key)))
+		}
+		for _, key := range change.RemoveKeys {
+			fmt.Println(color.RedString("       - %s", describeKey(key)))
+		}
+	}
+}
+
+// describeKey shortens an authorized_keys line to its type, fingerprint and
+// comment
+func describeKey(line string) string {
+	key, comment, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+	if err != nil {
+		if len(line) > 40 {
+			line = line[:40] + "..."
+		}
+		return line
+	}
+	return strings.TrimSpace(fmt.Sprintf("%s %s %s", key.Type(), ssh.FingerprintSHA256(key), comment))
+}
+
+// formatBytes formats a byte count with a binary unit
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for v := n / unit; v >= unit; v /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// formatUptime formats an uptime in seconds as days, hours and minutes
+func formatUptime(seconds int64) string {
+	d := time.Duration(seconds) * time.Second
+	days := int(d.Hours()) / 24
+	hours := int(d.Hours()) % 24
+	minutes := int(d.Minutes()) % 60
+	if days > 0 {
+		return fmt.Sprintf("%dd %dh %dm", days, hours, minutes)
+	}
+	return fmt.Sprintf("%dh %dm", hours, minutes)
+}
+
+// firewallRules collects the global and server-specific firewall rules
+func firewallRules(serverName string, config *config.Config) []pkg.FirewallRule {
 	var rules []pkg.FirewallRule
 
 	// Add global rules
@@ -334,65 +998,85 @@ func configureFirewall(ctx context.Context, srv pkg.Server, serverName string, c
 		}
 	}
 
-	switch strings.ToLower(distro) {
-	case "ubuntu":
-		ops := server.NewUbuntuOperations(srv)
-		return ops.ConfigureFirewall(ctx, rules)
-	case "debian":
-		ops := server.NewDebianOperations(srv)
-		return ops.ConfigureFirewall(ctx, rules)
-	case "rocky", "rhel", "centos", "fedora":
-		ops := server.NewRockyOperations(srv)
-		return ops.ConfigureFirewall(ctx, rules)
-	default:
-		return fmt.Errorf("unsupported distribution: %s", distro)
+	// Keep the hardened SSH port reachable, or sshd moves to a port the
+	// firewall drops
+	if hardening := config.SSHHardeningFor(serverName); hardening != nil && hardening.Port != 0 && !allowsTCP(rules, hardening.Port) {
+		rules = append(rules, pkg.FirewallRule{
+			Port:     hardening.Port,
+			Protocol: "tcp",
+			Source:   "any",
+			Action:   "allow",
+			Comment:  "SSH",
+		})
 	}
+
+	return rules
 }
 
-func hardenSSH(ctx context.Context, srv pkg.Server, distro string) error {
-	switch strings.ToLower(distro) {
-	case "ubuntu":
-		ops := server.NewUbuntuOperations(srv)
-		return ops.HardenSSH(ctx)
-	case "debian":
-		ops := server.NewDebianOperations(srv)
-		return ops.HardenSSH(ctx)
-	case "rocky", "rhel", "centos", "fedora":
-		ops := server.NewRockyOperations(srv)
-		return ops.HardenSSH(ctx)
-	default:
-		return fmt.Errorf("unsupported distribution: %s", distro)
+// allowsTCP reports whether rules already allow TCP on port from anywhere
+func allowsTCP(rules []pkg.FirewallRule, port int) bool {
+	for _, rule := range rules {
+		if rule.Port != port || rule.Action != "allow" {
+			continue
+		}
+		if (rule.Protocol == "" || strings.Contains(rule.Protocol, "tcp")) && (rule.Source == "" || rule.Source == "any") {
+			return true
+		}
 	}
+	return false
 }
 
-func configureAutomaticUpdates(ctx context.Context, srv pkg.Server, distro string) error {
-	switch strings.ToLower(distro) {
-	case "ubuntu":
-		ops := server.NewUbuntuOperations(srv)
-		return ops.ConfigureAutomaticUpdates(ctx)
-	case "debian":
-		ops := server.NewDebianOperations(srv)
-		return ops.ConfigureAutomaticUpdates(ctx)
-	case "rocky", "rhel", "centos", "fedora":
-		ops := server.NewRockyOperations(srv)
-		return ops.ConfigureAutomaticUpdates(ctx)
-	default:
-		return fmt.Errorf("unsupported distribution: %s", distro)
+// sshPolicy converts an SSH hardening block to the policy applied by the
+// distro operations; nil selects the defaults
+func sshPolicy(hardening *config.SSHHardeningConfig) pkg.SSHPolicy {
+	if hardening == nil {
+		return pkg.SSHPolicy{}
+	}
+	return pkg.SSHPolicy{
+		Port:                   hardening.Port,
+		AllowUsers:             hardening.AllowUsers,
+		Ciphers:                hardening.Ciphers,
+		MaxAuthTries:           hardening.MaxAuthTries,
+		PermitRootLogin:        hardening.PermitRootLogin,
+		PasswordAuthentication: hardening.PasswordAuthentication,
 	}
 }
 
+// fail2banPolicy converts a fail2ban block to the policy applied by the
+// distro operations
+func fail2banPolicy(f2b *config.Fail2banConfig, sshPort int) pkg.Fail2banPolicy {
+	return pkg.Fail2banPolicy{
+		SSHPort:    sshPort,
+		BanTime:    f2b.BanTime,
+		FindTime:   f2b.FindTime,
+		MaxRetry:   f2b.MaxRetry,
+		IgnoreIPs:  f2b.IgnoreIPs,
+		TraefikLog: f2b.TraefikLog,
+	}
+}
+
+// dockerDaemon converts a docker block to the daemon settings applied by the
+// distro operations
+func dockerDaemon(docker *config.DockerConfig) pkg.DockerDaemon {
+	daemon := pkg.DockerDaemon{
+		LogDriver:       docker.LogDriver,
+		LogMaxSize:      docker.LogMaxSize,
+		LogMaxFile:      docker.LogMaxFile,
+		RegistryMirrors: docker.RegistryMirrors,
+		LiveRestore:     docker.LiveRestore,
+		UsernsRemap:     docker.UsernsRemap,
+	}
+	for _, pool := range docker.DefaultAddressPools {
+		daemon.DefaultAddressPools = append(daemon.DefaultAddressPools, pkg.AddressPool{Base: pool.Base, Size: pool.Size})
+	}
+	return daemon
+}
+
+// getDockerStatus returns the state of the Docker service on a server
 func getDockerStatus(ctx context.Context, srv pkg.Server, distro string) (string, error) {
-	switch strings.ToLower(distro) {
-	case "ubuntu":
-		ops := server.NewUbuntuOperations(srv)
-		return ops.GetDockerStatus(ctx)
-	case "debian":
-		ops := server.NewDebianOperations(srv)
-		return ops.GetDockerStatus(ctx)
-	case "rocky", "rhel", "centos", "fedora":
-		ops := server.NewRockyOperations(srv)
-		return ops.GetDockerStatus(ctx)
-	default:
-		return "unknown", fmt.Errorf("unsupported distribution: %s", distro)
-	}
-}
\ No newline at end of file
+	ops, err := server.NewFactory().Operations(srv, distro)
+	if err != nil {
+		return "unknown", err
+	}
+	return ops.GetDockerStatus(ctx)
+}
@@ -3,13 +3,21 @@ package main
 import (
 	"context"
 	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
 	"strings"
+	"syscall"
 
 	"github.com/fatih/color"
 	"github.com/spf13/cobra"
+	"golang.org/x/term"
 
+	"github.com/jonas-jonas/mah/internal/config"
 	"github.com/jonas-jonas/mah/internal/plugins/docker"
 	"github.com/jonas-jonas/mah/internal/server"
+	"github.com/jonas-jonas/mah/internal/state"
 	"github.com/jonas-jonas/mah/pkg"
 )
 
@@ -134,6 +142,43 @@ var serviceScaleCmd = &cobra.Command{
 	},
 }
 
+var serviceExecCmd = &cobra.Command{
+	Use:   "exec <service-name> -- <command...>",
+	Short: "Run a command inside a service's container",
+	Long: `Run a command inside a service's container with docker compose exec. When run
+from a terminal the command gets a TTY, so interactive programs work as usual.
+The exit code of the command is passed through.
+
+Examples:
+  mah service exec blog -- sh
+  mah service exec mysql --server db1 -- mysql -u root -p`,
+	Args:          cobra.MinimumNArgs(2),
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		serverName, _ := cmd.Flags().GetString("server")
+		return execInService(args[0], serverName, args[1:])
+	},
+}
+
+var serviceForwardCmd = &cobra.Command{
+	Use:   "forward <service-name> <[local-port:]port>...",
+	Short: "Forward local ports to a service's container",
+	Long: `Forward local ports to a service's container over the SSH connection, so
+internal services without published ports can be reached from your machine.
+Ports published on the server are used directly, otherwise the container's IP
+on its Docker network is resolved. Press Ctrl-C to stop forwarding.
+
+Examples:
+  mah service forward mysql 3306
+  mah service forward mysql 13306:3306 --server db1`,
+	Args: cobra.MinimumNArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		serverName, _ := cmd.Flags().GetString("server")
+		return forwardService(args[0], serverName, args[1:])
+	},
+}
+
 func init() {
 	serviceCmd.AddCommand(serviceListCmd)
 	serviceCmd.AddCommand(serviceDeployCmd)
@@ -143,9 +188,13 @@ func init() {
 	serviceCmd.AddCommand(serviceRemoveCmd)
 	serviceCmd.AddCommand(serviceRestartCmd)
 	serviceCmd.AddCommand(serviceScaleCmd)
+	serviceCmd.AddCommand(serviceExecCmd)
+	serviceCmd.AddCommand(serviceForwardCmd)
 	
 	// Add flags
 	serviceLogsCmd.Flags().BoolP("follow", "f", false, "Follow log output")
+	serviceExecCmd.Flags().StringP("server", "s", "", "Server to run on (defaults to the service's first server)")
+	serviceForwardCmd.Flags().StringP("server", "s", "", "Server to forward through (defaults to the service's first server)")
 }
 
 // deployService deploys a service to servers
@@ -164,39 +213,46 @@ func deployService(serviceName string) error {
 	fmt.Printf("   Image: %s\n", service.Image)
 	fmt.Printf("   Servers: %v\n", service.Servers)
 
-	// Create server instances for deployment
-	servers := make(map[string]pkg.Server)
-	factory := server.NewFactory()
+	// Connect to deployment servers (connections are pooled)
+	servers, err := connectServers(context.Background(), service.Servers, true)
+	if err != nil {
+		return err
+	}
 
-	for _, serverName := range service.Servers {
-		serverConfig := config.Servers[serverName]
-		if serverConfig == nil {
-			return fmt.Errorf("server '%s' not found in configuration", serverName)
-		}
+	// Create Docker provider, recording what gets deployed
+	store := state.NewStore(configManager.GetRuntimeConfig().StateDir)
+	dockerProvider := docker.NewProvider(servers, config).RecordTo(store)
 
-		srv, err := factory.CreateServer(serverName, serverConfig)
-		if err != nil {
-			return fmt.Errorf("failed to create server instance for '%s': %w", serverName, err)
+	// Each nexus can override the service's domains and environment, so
+	// deploy to the servers of one nexus at a time
+	byNexus := make(map[string][]string)
+	var nexusNames []string
+	for _, serverName := range service.Servers {
+		nexusName := config.Servers[serverName].Nexus
+		if _, ok := byNexus[nexusName]; !ok {
+			nexusNames = append(nexusNames, nexusName)
 		}
-		defer srv.Disconnect()
+		byNexus[nexusName] = append(byNexus[nexusName], serverName)
+	}
 
-		// Connect to the server
-		ctx := context.Background()
-		if err := srv.Connect(ctx); err != nil {
-			return fmt.Errorf("failed to connect to server '%s': %w", serverName, err)
+	for _, nexusName := range nexusNames {
+		serviceConfig := serviceConfigFor(serviceName, config.ServiceIn(nexusName, serviceName), byNexus[nexusName])
+		if err := dockerProvider.Deploy(serviceConfig); err != nil {
+			return fmt.Errorf("deployment failed: %w", err)
 		}
-
-		servers[serverName] = srv
 	}
 
-	// Create Docker provider
-	dockerProvider := docker.NewProvider(servers, config)
+	color.Green("✅ Service '%s' deployed successfully!", serviceName)
+	return nil
+}
 
-	// Convert config.Service to pkg.ServiceConfig
-	serviceConfig := &pkg.ServiceConfig{
+// serviceConfigFor converts a configured service into the deployment
+// configuration for the given servers
+func serviceConfigFor(serviceName string, service *config.Service, serverNames []string) *pkg.ServiceConfig {
+	return &pkg.ServiceConfig{
 		Name:        serviceName,
 		Image:       service.Image,
-		Servers:     service.Servers,
+		Servers:     serverNames,
 		Domains:     service.Domains,
 		Public:      service.Public,
 		Internal:    service.Internal,
@@ -208,15 +264,6 @@ func deployService(serviceName string) error {
 		Labels:      service.Labels,
 		Replicas:    service.Replicas,
 	}
-
-	// Deploy service
-	err := dockerProvider.Deploy(serviceConfig)
-	if err != nil {
-		return fmt.Errorf("deployment failed: %w", err)
-	}
-
-	color.Green("✅ Service '%s' deployed successfully!", serviceName)
-	return nil
 }
 
 // showServiceStatus shows status for a specific service
@@ -231,31 +278,8 @@ func showServiceStatus(serviceName string) error {
 		return fmt.Errorf("service '%s' not found in configuration", serviceName)
 	}
 
-	// Create server instances
-	servers := make(map[string]pkg.Server)
-	factory := server.NewFactory()
-
-	for _, serverName := range service.Servers {
-		serverConfig := config.Servers[serverName]
-		if serverConfig == nil {
-			continue
-		}
-
-		srv, err := factory.CreateServer(serverName, serverConfig)
-		if err != nil {
-			continue
-		}
-		defer srv.Disconnect()
-
-		// Connect to the server
-		ctx := context.Background()
-		if err := srv.Connect(ctx); err != nil {
-			fmt.Printf("⚠️  Warning: failed to connect to server '%s': %v\n", serverName, err)
-			continue
-		}
-
-		servers[serverName] = srv
-	}
+	// Connect to the service's servers (connections are pooled)
+	servers, _ := connectServers(context.Background(), service.Servers, false)
 
 	if len(servers) == 0 {
 		return fmt.Errorf("no accessible servers found for service '%s'", serviceName)
@@ -368,39 +392,20 @@ func showServiceLogs(serviceName string, follow bool) error {
 		return fmt.Errorf("service '%s' not found in configuration", serviceName)
 	}
 
-	// Create server instances
-	servers := make(map[string]pkg.Server)
-	factory := server.NewFactory()
-
-	for _, serverName := range service.Servers {
-		serverConfig := config.Servers[serverName]
-		if serverConfig == nil {
-			continue
-		}
-
-		srv, err := factory.CreateServer(serverName, serverConfig)
-		if err != nil {
-			continue
-		}
-		defer srv.Disconnect()
-
-		// Connect to the server
-		ctx := context.Background()
-		if err := srv.Connect(ctx); err != nil {
-			fmt.Printf("⚠️  Warning: failed to connect to server '%s': %v\n", serverName, err)
-			continue
-		}
-
-		servers[serverName] = srv
-	}
+	// Connect to the service's servers (connections are pooled)
+	servers, _ := connectServers(context.Background(), service.Servers, false)
 
 	if len(servers) == 0 {
 		return fmt.Errorf("no accessible servers found for service '%s'", serviceName)
 	}
 
+	// Stop following on Ctrl-C, which also ends the remote log commands
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	// Create Docker provider and get logs
 	dockerProvider := docker.NewProvider(servers, config)
-	logChan, err := dockerProvider.Logs(serviceName, follow)
+	logChan, err := dockerProvider.Logs(ctx, serviceName, follow)
 	if err != nil {
 		return fmt.Errorf("failed to get service logs: %w", err)
 	}
@@ -428,31 +433,8 @@ func stopService(serviceName string) error {
 		return fmt.Errorf("service '%s' not found in configuration", serviceName)
 	}
 
-	// Create server instances
-	servers := make(map[string]pkg.Server)
-	factory := server.NewFactory()
-
-	for _, serverName := range service.Servers {
-		serverConfig := config.Servers[serverName]
-		if serverConfig == nil {
-			continue
-		}
-
-		srv, err := factory.CreateServer(serverName, serverConfig)
-		if err != nil {
-			continue
-		}
-		defer srv.Disconnect()
-
-		// Connect to the server
-		ctx := context.Background()
-		if err := srv.Connect(ctx); err != nil {
-			fmt.Printf("⚠️  Warning: failed to connect to server '%s': %v\n", serverName, err)
-			continue
-		}
-
-		servers[serverName] = srv
-	}
+	// Connect to the service's servers (connections are pooled)
+	servers, _ := connectServers(context.Background(), service.Servers, false)
 
 	if len(servers) == 0 {
 		return fmt.Errorf("no accessible servers found for service '%s'", serviceName)
@@ -492,31 +474,8 @@ func removeService(serviceName string) error {
 		return fmt.Errorf("service '%s' not found in configuration", serviceName)
 	}
 
-	// Create server instances
-	servers := make(map[string]pkg.Server)
-	factory := server.NewFactory()
-
-	for _, serverName := range service.Servers {
-		serverConfig := config.Servers[serverName]
-		if serverConfig == nil {
-			continue
-		}
-
-		srv, err := factory.CreateServer(serverName, serverConfig)
-		if err != nil {
-			continue
-		}
-		defer srv.Disconnect()
-
-		// Connect to the server
-		ctx := context.Background()
-		if err := srv.Connect(ctx); err != nil {
-			fmt.Printf("⚠️  Warning: failed to connect to server '%s': %v\n", serverName, err)
-			continue
-		}
-
-		servers[serverName] = srv
-	}
+	// Connect to the service's servers (connections are pooled)
+	servers, _ := connectServers(context.Background(), service.Servers, false)
 
 	if len(servers) == 0 {
 		return fmt.Errorf("no accessible servers found for service '%s'", serviceName)
@@ -561,31 +520,8 @@ func scaleService(serviceName string, replicas int) error {
 		return fmt.Errorf("service '%s' not found in configuration", serviceName)
 	}
 
-	// Create server instances
-	servers := make(map[string]pkg.Server)
-	factory := server.NewFactory()
-
-	for _, serverName := range service.Servers {
-		serverConfig := config.Servers[serverName]
-		if serverConfig == nil {
-			continue
-		}
-
-		srv, err := factory.CreateServer(serverName, serverConfig)
-		if err != nil {
-			continue
-		}
-		defer srv.Disconnect()
-
-		// Connect to the server
-		ctx := context.Background()
-		if err := srv.Connect(ctx); err != nil {
-			fmt.Printf("⚠️  Warning: failed to connect to server '%s': %v\n", serverName, err)
-			continue
-		}
-
-		servers[serverName] = srv
-	}
+	// Connect to the service's servers (connections are pooled)
+	servers, _ := connectServers(context.Background(), service.Servers, false)
 
 	if len(servers) == 0 {
 		return fmt.Errorf("no accessible servers found for service '%s'", serviceName)
@@ -603,4 +539,161 @@ func scaleService(serviceName string, replicas int) error {
 
 	color.Green("✅ Service '%s' scaled to %d replicas successfully!", serviceName, replicas)
 	return nil
-}
\ No newline at end of file
+}
+
+// execInService runs a command inside a service's container on one server
+func execInService(serviceName, serverName string, command []string) error {
+	config := configManager.GetConfig()
+	if config == nil {
+		return fmt.Errorf("no configuration loaded")
+	}
+
+	service := config.Services[serviceName]
+	if service == nil {
+		return fmt.Errorf("service '%s' not found in configuration", serviceName)
+	}
+
+	serverName, err := serviceServer(serviceName, service, serverName)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+
+	srv, err := nexusManager.Server(ctx, serverName)
+	if err != nil {
+		return err
+	}
+
+	quoted := make([]string, len(command))
+	for i, arg := range command {
+		quoted[i] = server.ShellQuote(arg)
+	}
+
+	composeFile := fmt.Sprintf("/opt/mah/services/%s/docker-compose.yml", serviceName)
+	opts := pkg.StreamOptions{
+		Sudo:   true,
+		Stdin:  os.Stdin,
+		Stdout: os.Stdout,
+		Stderr: os.Stderr,
+	}
+
+	// Only allocate a TTY when attached to a terminal, so piping works too
+	var result *pkg.Result
+	if term.IsTerminal(int(os.Stdin.Fd())) {
+		cmd := fmt.Sprintf("docker compose -f %s exec %s %s", composeFile, serviceName, strings.Join(quoted, " "))
+		result, err = srv.Interactive(ctx, cmd, opts)
+	} else {
+		// Without a terminal, Ctrl-C stops the command
+		streamCtx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		cmd := fmt.Sprintf("docker compose -f %s exec -T %s %s", composeFile, serviceName, strings.Join(quoted, " "))
+		result, err = srv.Stream(streamCtx, cmd, opts)
+	}
+	if err != nil {
+		return err
+	}
+
+	if result.ExitCode != 0 {
+		return &exitCodeError{code: result.ExitCode}
+	}
+	return nil
+}
+
+// forwardService forwards local ports to a service's container ports
+func forwardService(serviceName, serverName string, specs []string) error {
+	config := configManager.GetConfig()
+	if config == nil {
+		return fmt.Errorf("no configuration loaded")
+	}
+
+	service := config.Services[serviceName]
+	if service == nil {
+		return fmt.Errorf("service '%s' not found in configuration", serviceName)
+	}
+
+	serverName, err := serviceServer(serviceName, service, serverName)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	srv, err := nexusManager.Server(ctx, serverName)
+	if err != nil {
+		return err
+	}
+
+	dockerProvider := docker.NewProvider(map[string]pkg.Server{serverName: srv}, config)
+
+	var forwards []server.Forward
+	for _, spec := range specs {
+		// Accept "port" or "local-port:port"
+		localPort, containerPort, err := server.ParsePortPair(spec)
+		if err != nil {
+			return err
+		}
+
+		target, err := dockerProvider.Address(ctx, serviceName, serverName, containerPort)
+		if err != nil {
+			return err
+		}
+
+		forwards = append(forwards, server.Forward{
+			Listen: net.JoinHostPort("127.0.0.1", strconv.Itoa(localPort)),
+			Target: target,
+		})
+	}
+
+	fmt.Printf("🔀 Forwarding ports of service '%s' on server '%s'\n", serviceName, serverName)
+	return runForwards(ctx, srv, forwards)
+}
+
+// serviceServer picks the server to operate on for a service, defaulting to
+// the service's first server
+func serviceServer(serviceName string, service *config.Service, serverName string) (string, error) {
+	if serverName == "" {
+		if len(service.Servers) == 0 {
+			return "", fmt.Errorf("service '%s' has no servers configured", serviceName)
+		}
+		return service.Servers[0], nil
+	}
+
+	if !containsString(service.Servers, serverName) {
+		return "", fmt.Errorf("service '%s' is not deployed to server '%s'", serviceName, serverName)
+	}
+	return serverName, nil
+}
+
+// containsString reports whether list contains s
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// connectServers returns pooled connections for the named servers. Unless
+// required is set, unreachable servers are skipped with a warning.
+func connectServers(ctx context.Context, serverNames []string, required bool) (map[string]pkg.Server, error) {
+	servers := make(map[string]pkg.Server)
+
+	for _, serverName := range serverNames {
+		srv, err := nexusManager.Server(ctx, serverName)
+		if err != nil {
+			if required {
+				return nil, err
+			}
+			fmt.Printf("⚠️  Warning: %v\n", err)
+			continue
+		}
+
+		servers[serverName] = srv
+	}
+
+	return servers, nil
+}
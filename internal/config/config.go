@@ -2,12 +2,15 @@ package config
 
 import (
 	"fmt"
+	"net"
 	"os"
 	"path/filepath"
 	"regexp"
 	"strings"
 
+	"github.com/go-viper/mapstructure/v2"
 	"github.com/spf13/viper"
+	"golang.org/x/crypto/ssh"
 	"gopkg.in/yaml.v3"
 )
 
@@ -44,7 +47,12 @@ func (m *Manager) LoadConfig(configPath string) error {
 	
 	// Unmarshal into struct
 	var config Config
-	if err := m.viper.Unmarshal(&config); err != nil {
+	decodeHook := viper.DecodeHook(mapstructure.ComposeDecodeHookFunc(
+		jumpDecodeHook,
+		mapstructure.StringToTimeDurationHookFunc(),
+		mapstructure.StringToSliceHookFunc(","),
+	))
+	if err := m.viper.Unmarshal(&config, decodeHook); err != nil {
 		return fmt.Errorf("failed to unmarshal config: %w", err)
 	}
 	
@@ -249,34 +257,96 @@ func (m *Manager) validateConfig(config *Config) error {
 		if server == nil {
 			return fmt.Errorf("server '%s': configuration is nil", name)
 		}
+		
+		// Validate transport; local servers need no SSH settings
+		switch strings.ToLower(server.Transport) {
+		case "", TransportSSH, TransportLocal:
+		default:
+			return fmt.Errorf("server '%s': invalid transport '%s' (must be ssh or local)", name, server.Transport)
+		}
+		if server.IsLocal() {
+			if server.Jump != nil {
+				return fmt.Errorf("server '%s': jump is not supported with transport local", name)
+			}
+			if server.Host == "" {
+				server.Host = "localhost"
+			}
+		}
+		
 		if server.Host == "" {
 			return fmt.Errorf("server '%s': host is required", name)
 		}
-		if server.SSHUser == "" {
+		if server.SSHUser == "" && !server.IsLocal() {
 			return fmt.Errorf("server '%s': ssh_user is required (got '%s')", name, server.SSHUser)
 		}
-		if server.SSHKey == "" {
-			return fmt.Errorf("server '%s': ssh_key is required", name)
-		}
 		if server.Nexus == "" {
 			return fmt.Errorf("server '%s': nexus is required", name)
 		}
 		
-		// Expand SSH key path
-		if strings.HasPrefix(server.SSHKey, "~/") {
-			homeDir, _ := os.UserHomeDir()
-			server.SSHKey = filepath.Join(homeDir, server.SSHKey[2:])
+		// SSH key is optional when authenticating through ssh-agent
+		if server.SSHKey != "" && !server.IsLocal() {
+			// Expand SSH key path
+			if strings.HasPrefix(server.SSHKey, "~/") {
+				homeDir, _ := os.UserHomeDir()
+				server.SSHKey = filepath.Join(homeDir, server.SSHKey[2:])
+			}
+			
+			// Check if SSH key exists
+			if _, err := os.Stat(server.SSHKey); os.IsNotExist(err) {
+				return fmt.Errorf("server '%s': SSH key file not found: %s", name, server.SSHKey)
+			}
+		}
+		
+		// Validate host key checking mode
+		switch strings.ToLower(server.HostKeyCheck) {
+		case "", "tofu", "strict":
+		default:
+			return fmt.Errorf("server '%s': invalid host_key_check '%s' (must be tofu or strict)", name, server.HostKeyCheck)
+		}
+		
+		// Validate privilege escalation
+		if server.Become != nil {
+			switch server.BecomeMethod() {
+			case BecomeSudo, BecomeNone:
+			case BecomeDoas:
+				if server.Become.Password != "" {
+					return fmt.Errorf("server '%s': become.password is not supported with doas, which only reads passwords from a terminal; use a nopass rule in doas.conf", name)
+				}
+			default:
+				return fmt.Errorf("server '%s': invalid become.method '%s' (must be sudo, doas or none)", name, server.Become.Method)
+			}
+		}
+		
+		if server.Security != nil {
+			if err := validateSecurity(server.Security); err != nil {
+				return fmt.Errorf("server '%s': security: %w", name, err)
+			}
 		}
 		
-		// Check if SSH key exists
-		if _, err := os.Stat(server.SSHKey); os.IsNotExist(err) {
-			return fmt.Errorf("server '%s': SSH key file not found: %s", name, server.SSHKey)
+		if server.Docker != nil {
+			if err := validateDocker(server.Docker); err != nil {
+				return fmt.Errorf("server '%s': docker: %w", name, err)
+			}
 		}
 		
 		// Set defaults
 		if server.SSHPort == 0 {
 			server.SSHPort = 22
 		}
+		
+		// Validate SSH hardening against the user mah logs in as
+		if policy := config.SSHHardeningFor(name); policy != nil && !server.IsLocal() {
+			if err := validateSSHHardening(policy, server.SSHUser); err != nil {
+				return fmt.Errorf("server '%s': ssh_hardening: %w", name, err)
+			}
+		}
+	}
+	
+	// Resolve jump hosts once all servers are known
+	for name, server := range config.Servers {
+		if err := resolveJump(config, server.Jump, []string{name}); err != nil {
+			return fmt.Errorf("server '%s': %w", name, err)
+		}
 	}
 	
 	// Validate nexuses
@@ -295,6 +365,34 @@ func (m *Manager) validateConfig(config *Config) error {
 				return fmt.Errorf("nexus '%s': references non-existent server '%s'", name, serverName)
 			}
 		}
+		
+		if nexus.Security != nil {
+			if err := validateSecurity(nexus.Security); err != nil {
+				return fmt.Errorf("nexus '%s': security: %w", name, err)
+			}
+		}
+		
+		if nexus.Docker != nil {
+			if err := validateDocker(nexus.Docker); err != nil {
+				return fmt.Errorf("nexus '%s': docker: %w", name, err)
+			}
+		}
+		
+		for serviceName, override := range nexus.Services {
+			if config.Services[serviceName] == nil {
+				return fmt.Errorf("nexus '%s': services: references non-existent service '%s'", name, serviceName)
+			}
+			if override == nil {
+				return fmt.Errorf("nexus '%s': services: '%s' is empty", name, serviceName)
+			}
+		}
+	}
+	
+	// Validate team users
+	for name, user := range config.Users {
+		if err := validateTeamUser(config, name, user); err != nil {
+			return fmt.Errorf("user '%s': %w", name, err)
+		}
 	}
 	
 	// Validate services
@@ -344,6 +442,191 @@ func (m *Manager) validateConfig(config *Config) error {
 	return nil
 }
 
+// validateSSHHardening validates an SSH hardening policy for a server that
+// mah reaches as sshUser
+func validateSSHHardening(policy *SSHHardeningConfig, sshUser string) error {
+	if policy.Port < 0 || policy.Port > 65535 {
+		return fmt.Errorf("invalid port %d", policy.Port)
+	}
+	if policy.MaxAuthTries < 0 {
+		return fmt.Errorf("invalid max_auth_tries %d", policy.MaxAuthTries)
+	}
+	
+	switch policy.PermitRootLogin {
+	case "", "no", "prohibit-password", "forced-commands-only", "yes":
+	default:
+		return fmt.Errorf("invalid permit_root_login '%s' (must be no, prohibit-password, forced-commands-only or yes)", policy.PermitRootLogin)
+	}
+	
+	// Leaving out the SSH user would lock mah out of the server
+	if len(policy.AllowUsers) > 0 {
+		allowed := false
+		for _, user := range policy.AllowUsers {
+			if user == sshUser {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return fmt.Errorf("allow_users must include ssh_user '%s'", sshUser)
+		}
+	}
+	
+	return nil
+}
+
+// teamUserName matches the account names mah creates for team members
+var teamUserName = regexp.MustCompile(`^[a-z_][a-z0-9_-]{0,31}$`)
+
+// validateTeamUser validates a team member and checks that mah can manage
+// their account on the servers they have access to
+func validateTeamUser(config *Config, name string, user *TeamUser) error {
+	if user == nil {
+		return fmt.Errorf("configuration is nil")
+	}
+	if !teamUserName.MatchString(name) || name == "root" {
+		return fmt.Errorf("invalid account name (lowercase letters, digits, _ and -, at most 32 characters)")
+	}
+	if user.Shell != "" && (!filepath.IsAbs(user.Shell) || strings.ContainsAny(user.Shell, " \t\n'")) {
+		return fmt.Errorf("shell must be an absolute path, got '%s'", user.Shell)
+	}
+	
+	if len(user.Keys) == 0 {
+		return fmt.Errorf("at least one key is required")
+	}
+	for i, key := range user.Keys {
+		if strings.ContainsAny(strings.TrimSpace(key), "\r\n") {
+			return fmt.Errorf("keys[%d]: must be a single line", i)
+		}
+		if _, _, _, _, err := ssh.ParseAuthorizedKey([]byte(key)); err != nil {
+			return fmt.Errorf("keys[%d]: %w", i, err)
+		}
+	}
+	
+	if len(user.Nexuses) == 0 {
+		return fmt.Errorf("at least one nexus is required")
+	}
+	for _, nexusName := range user.Nexuses {
+		nexus := config.Nexuses[nexusName]
+		if nexus == nil {
+			return fmt.Errorf("references non-existent nexus '%s'", nexusName)
+		}
+		
+		for _, serverName := range nexus.Servers {
+			server := config.Servers[serverName]
+			if server.IsLocal() {
+				continue
+			}
+			
+			// Syncing would replace the keys mah itself logs in with
+			if server.SSHUser == name {
+				return fmt.Errorf("is the ssh_user of server '%s'", serverName)
+			}
+			
+			if policy := config.SSHHardeningFor(serverName); policy != nil && len(policy.AllowUsers) > 0 {
+				allowed := false
+				for _, allowUser := range policy.AllowUsers {
+					if allowUser == name {
+						allowed = true
+						break
+					}
+				}
+				if !allowed {
+					return fmt.Errorf("not in ssh_hardening.allow_users of server '%s'", serverName)
+				}
+			}
+		}
+	}
+	
+	return nil
+}
+
+// fail2banTime matches fail2ban time values such as 600, 10m, 1h30m or -1
+var fail2banTime = regexp.MustCompile(`^(-1|([0-9]+(s|m|h|d|w|mo|y)?)+)$`)
+
+// validateSecurity validates intrusion protection settings, which end up in
+// fail2ban's configuration files
+func validateSecurity(security *SecurityConfig) error {
+	f2b := security.Fail2ban
+	if f2b == nil {
+		return nil
+	}
+	
+	for field, value := range map[string]string{"bantime": f2b.BanTime, "findtime": f2b.FindTime} {
+		if value != "" && !fail2banTime.MatchString(value) {
+			return fmt.Errorf("fail2ban.%s: invalid time '%s' (e.g. 600, 10m, 1h or -1)", field, value)
+		}
+	}
+	if f2b.MaxRetry < 0 {
+		return fmt.Errorf("fail2ban.maxretry: invalid value %d", f2b.MaxRetry)
+	}
+	
+	for _, ip := range f2b.IgnoreIPs {
+		if net.ParseIP(ip) == nil {
+			if _, _, err := net.ParseCIDR(ip); err != nil {
+				return fmt.Errorf("fail2ban.ignore_ips: invalid IP or CIDR '%s'", ip)
+			}
+		}
+	}
+	
+	if f2b.TraefikLog != "" && (!filepath.IsAbs(f2b.TraefikLog) || strings.ContainsAny(f2b.TraefikLog, " \t\n")) {
+		return fmt.Errorf("fail2ban.traefik_log: must be an absolute path without spaces, got '%s'", f2b.TraefikLog)
+	}
+	
+	return nil
+}
+
+var (
+	// dockerLogSize matches json-file and local log sizes such as 10m or 1g
+	dockerLogSize = regexp.MustCompile(`^[0-9]+[kmg]?$`)
+
+	// dockerUsernsRemap matches userns-remap values: default, user or user:group
+	dockerUsernsRemap = regexp.MustCompile(`^(default|[a-z_][a-z0-9_-]*(:[a-z_][a-z0-9_-]*)?)$`)
+)
+
+// validateDocker validates Docker daemon settings before they are written
+// to daemon.json
+func validateDocker(docker *DockerConfig) error {
+	switch docker.LogDriver {
+	case "", "json-file", "local":
+		if docker.LogMaxSize != "" && !dockerLogSize.MatchString(docker.LogMaxSize) {
+			return fmt.Errorf("invalid log_max_size '%s' (e.g. 10m or 1g)", docker.LogMaxSize)
+		}
+		if docker.LogMaxFile < 0 {
+			return fmt.Errorf("invalid log_max_file %d", docker.LogMaxFile)
+		}
+	case "journald", "syslog", "none":
+		if docker.LogMaxSize != "" || docker.LogMaxFile != 0 {
+			return fmt.Errorf("log_max_size and log_max_file only apply to the json-file and local log drivers")
+		}
+	default:
+		return fmt.Errorf("invalid log_driver '%s' (must be json-file, local, journald, syslog or none)", docker.LogDriver)
+	}
+	
+	for _, mirror := range docker.RegistryMirrors {
+		if !strings.HasPrefix(mirror, "https://") && !strings.HasPrefix(mirror, "http://") {
+			return fmt.Errorf("invalid registry mirror '%s' (must be an http or https URL)", mirror)
+		}
+	}
+	
+	for _, pool := range docker.DefaultAddressPools {
+		_, base, err := net.ParseCIDR(pool.Base)
+		if err != nil {
+			return fmt.Errorf("default_address_pools: invalid base '%s'", pool.Base)
+		}
+		ones, bits := base.Mask.Size()
+		if pool.Size < ones || pool.Size > bits {
+			return fmt.Errorf("default_address_pools: size %d does not fit in %s", pool.Size, pool.Base)
+		}
+	}
+	
+	if docker.UsernsRemap != "" && !dockerUsernsRemap.MatchString(docker.UsernsRemap) {
+		return fmt.Errorf("invalid userns_remap '%s' (must be default, user or user:group)", docker.UsernsRemap)
+	}
+	
+	return nil
+}
+
 // validateFirewallRule validates a single firewall rule
 func (m *Manager) validateFirewallRule(rule FirewallRule, context string) error {
 	if rule.Port <= 0 || rule.Port > 65535 {
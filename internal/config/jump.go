@@ -0,0 +1,111 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+
+	"gopkg.in/yaml.v3"
+)
+
+// UnmarshalYAML accepts either a server name or an inline jump host
+func (j *JumpConfig) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		j.Server = value.Value
+		return nil
+	}
+
+	type plain JumpConfig
+	return value.Decode((*plain)(j))
+}
+
+// MarshalYAML writes jumps that only name a server back in their short form
+func (j JumpConfig) MarshalYAML() (interface{}, error) {
+	if j.Server != "" && j.Host == "" {
+		return j.Server, nil
+	}
+
+	type plain JumpConfig
+	return plain(j), nil
+}
+
+// Target returns the server configuration to connect to for this jump
+func (j *JumpConfig) Target() *Server {
+	if j.target != nil {
+		return j.target
+	}
+	if j.Server != "" {
+		return nil // named jumps are resolved during validation
+	}
+	return j.inlineServer()
+}
+
+// Name returns a human-readable identifier for the jump host
+func (j *JumpConfig) Name() string {
+	if j.Server != "" {
+		return j.Server
+	}
+	return fmt.Sprintf("%s@%s", j.SSHUser, j.Host)
+}
+
+// inlineServer converts inline jump settings to a server configuration
+func (j *JumpConfig) inlineServer() *Server {
+	port := j.SSHPort
+	if port == 0 {
+		port = 22
+	}
+
+	return &Server{
+		Host:             j.Host,
+		SSHUser:          j.SSHUser,
+		SSHKey:           j.SSHKey,
+		SSHKeyPassphrase: j.SSHKeyPassphrase,
+		SSHPort:          port,
+		HostKey:          j.HostKey,
+		HostKeyCheck:     j.HostKeyCheck,
+		Jump:             j.Jump,
+	}
+}
+
+// jumpDecodeHook lets viper decode the short "jump: <server>" form
+func jumpDecodeHook(from reflect.Type, to reflect.Type, data interface{}) (interface{}, error) {
+	if from.Kind() != reflect.String {
+		return data, nil
+	}
+	if to == reflect.TypeOf(JumpConfig{}) || to == reflect.TypeOf(&JumpConfig{}) {
+		return map[string]interface{}{"server": data}, nil
+	}
+	return data, nil
+}
+
+// resolveJump links a jump to its target server and rejects unknown
+// servers and jump cycles. visited holds the servers already on the chain.
+func resolveJump(config *Config, jump *JumpConfig, visited []string) error {
+	if jump == nil {
+		return nil
+	}
+
+	if jump.Server == "" {
+		if jump.Host == "" {
+			return fmt.Errorf("jump host requires either a server name or a host")
+		}
+		if jump.SSHUser == "" {
+			return fmt.Errorf("jump host '%s' requires ssh_user", jump.Host)
+		}
+		jump.target = jump.inlineServer()
+		return resolveJump(config, jump.Jump, visited)
+	}
+
+	for _, name := range visited {
+		if name == jump.Server {
+			return fmt.Errorf("jump chain loops back to server '%s'", jump.Server)
+		}
+	}
+
+	target := config.Servers[jump.Server]
+	if target == nil {
+		return fmt.Errorf("jump references non-existent server '%s'", jump.Server)
+	}
+	jump.target = target
+
+	return resolveJump(config, target.Jump, append(visited, jump.Server))
+}
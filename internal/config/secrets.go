@@ -55,12 +55,10 @@ func (sm *SecretManager) InitializeEncryption(keySource string) error {
 		}
 		key = []byte(keyEnv)
 	case "prompt":
-		fmt.Print("Enter master key for secrets encryption: ")
-		keyBytes, err := term.ReadPassword(int(syscall.Stdin))
+		keyBytes, err := ReadPassword("Enter master key for secrets encryption: ")
 		if err != nil {
-			return fmt.Errorf("failed to read password: %w", err)
+			return err
 		}
-		fmt.Println() // Add newline after password input
 		key = keyBytes
 	case "file":
 		keyFile := filepath.Join(filepath.Dir(sm.secretsFile), ".mah-key")
@@ -100,6 +98,22 @@ func (sm *SecretManager) InitializeEncryption(keySource string) error {
 	return nil
 }
 
+// ReadPassword prompts for a password on the terminal without echoing it
+func ReadPassword(prompt string) ([]byte, error) {
+	if !term.IsTerminal(int(syscall.Stdin)) {
+		return nil, fmt.Errorf("cannot prompt for password: stdin is not a terminal")
+	}
+	
+	fmt.Print(prompt)
+	password, err := term.ReadPassword(int(syscall.Stdin))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read password: %w", err)
+	}
+	fmt.Println() // Add newline after password input
+	
+	return password, nil
+}
+
 // EncryptSecret encrypts a secret value
 func (sm *SecretManager) EncryptSecret(plaintext string) (string, error) {
 	if sm.gcm == nil {
@@ -291,6 +305,7 @@ func SanitizeConfigForGit(configPath string, outputPath string) error {
 	sensitivePatterns := map[string]string{
 		`host:\s*"([^"]+)"`:           `host: "YOUR_SERVER_IP"`,
 		`ssh_key:\s*"([^"]+)"`:       `ssh_key: "~/.ssh/your_key"`,
+		`ssh_key_passphrase:\s*"([^"]+)"`: `ssh_key_passphrase: "${SSH_KEY_PASSPHRASE}"`,
 		`username:\s*"([^"]+)"`:      `username: "${NAMECOM_USERNAME}"`,
 		`token:\s*"([^"]+)"`:         `token: "${NAMECOM_TOKEN}"`,
 		`password:\s*"([^"]+)"`:      `password: "${DB_PASSWORD}"`,
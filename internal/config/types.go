@@ -1,5 +1,10 @@
 package config
 
+import (
+	"sort"
+	"strings"
+)
+
 // Config represents the main MAH configuration
 type Config struct {
 	Version  string              `yaml:"version" mapstructure:"version"`
@@ -9,17 +14,232 @@ type Config struct {
 	Services map[string]*Service `yaml:"services" mapstructure:"services"`
 	Plugins  *PluginConfigs      `yaml:"plugins" mapstructure:"plugins"`
 	Firewall *FirewallConfig     `yaml:"firewall" mapstructure:"firewall"`
+
+	// SSH hardening applied by mah server init; servers may override it
+	SSHHardening *SSHHardeningConfig `yaml:"ssh_hardening,omitempty" mapstructure:"ssh_hardening"`
+
+	// Team accounts managed by mah server users sync, by login name
+	Users map[string]*TeamUser `yaml:"users,omitempty" mapstructure:"users"`
 }
 
 // Server represents a server configuration
 type Server struct {
 	Host    string `yaml:"host" mapstructure:"host"`
 	SSHUser string `yaml:"ssh_user" mapstructure:"ssh_user"`
-	SSHKey  string `yaml:"ssh_key" mapstructure:"ssh_key"`
+	SSHKey  string `yaml:"ssh_key,omitempty" mapstructure:"ssh_key"` // optional when using ssh-agent
 	SSHPort int    `yaml:"ssh_port,omitempty" mapstructure:"ssh_port"`
 	Sudo    bool   `yaml:"sudo" mapstructure:"sudo"`
 	Distro  string `yaml:"distro" mapstructure:"distro"`
 	Nexus   string `yaml:"nexus" mapstructure:"nexus"`
+
+	// Transport used to reach the server: ssh (default) or local
+	Transport string `yaml:"transport,omitempty" mapstructure:"transport"`
+
+	// SSH key passphrase, usually "${SECRET_NAME}" resolved from the secrets store
+	SSHKeyPassphrase string `yaml:"ssh_key_passphrase,omitempty" mapstructure:"ssh_key_passphrase"`
+
+	// Host key verification
+	HostKey      string `yaml:"host_key,omitempty" mapstructure:"host_key"`             // pinned SHA256 fingerprint
+	HostKeyCheck string `yaml:"host_key_check,omitempty" mapstructure:"host_key_check"` // tofu (default), strict
+
+	// Maximum concurrent SSH sessions on the shared connection (default 8)
+	MaxSessions int `yaml:"max_sessions,omitempty" mapstructure:"max_sessions"`
+
+	// Jump host (bastion) used to reach this server
+	Jump *JumpConfig `yaml:"jump,omitempty" mapstructure:"jump"`
+
+	// Privilege escalation for commands that need root; takes precedence over sudo
+	Become *BecomeConfig `yaml:"become,omitempty" mapstructure:"become"`
+
+	// SSH hardening for this server, replacing the global ssh_hardening block
+	SSHHardening *SSHHardeningConfig `yaml:"ssh_hardening,omitempty" mapstructure:"ssh_hardening"`
+
+	// Intrusion protection for this server, replacing the nexus security block
+	Security *SecurityConfig `yaml:"security,omitempty" mapstructure:"security"`
+
+	// Docker daemon settings for this server, replacing the nexus docker block
+	Docker *DockerConfig `yaml:"docker,omitempty" mapstructure:"docker"`
+}
+
+// Server transports
+const (
+	TransportSSH   = "ssh"
+	TransportLocal = "local"
+)
+
+// IsLocal reports whether the server is the local machine, reached without SSH
+func (s *Server) IsLocal() bool {
+	return strings.ToLower(s.Transport) == TransportLocal
+}
+
+// Privilege escalation methods for BecomeConfig.Method
+const (
+	BecomeSudo = "sudo"
+	BecomeDoas = "doas"
+	BecomeNone = "none"
+)
+
+// BecomeConfig describes how commands are run with elevated privileges
+type BecomeConfig struct {
+	Method   string `yaml:"method,omitempty" mapstructure:"method"`     // sudo (default), doas, none
+	User     string `yaml:"user,omitempty" mapstructure:"user"`         // target user (default root)
+	Password string `yaml:"password,omitempty" mapstructure:"password"` // usually "${SECRET_NAME}" resolved from the secrets store
+}
+
+// BecomeMethod returns the privilege escalation method of the server. Without
+// a become block, sudo: true selects sudo and anything else none.
+func (s *Server) BecomeMethod() string {
+	if s.Become != nil {
+		if s.Become.Method == "" {
+			return BecomeSudo
+		}
+		return strings.ToLower(s.Become.Method)
+	}
+	if s.Sudo {
+		return BecomeSudo
+	}
+	return BecomeNone
+}
+
+// CanBecome reports whether commands can be run with elevated privileges
+func (s *Server) CanBecome() bool {
+	return s.BecomeMethod() != BecomeNone
+}
+
+// BecomePassword returns the password for privilege escalation, or an empty
+// string when none is set or its secret could not be resolved
+func (s *Server) BecomePassword() string {
+	if s.Become == nil || strings.HasPrefix(s.Become.Password, "${") {
+		return ""
+	}
+	return s.Become.Password
+}
+
+// SSHHardeningConfig is the sshd policy written to a managed drop-in. Unset
+// fields keep mah's defaults: no root login, no passwords, three tries and
+// modern ciphers.
+type SSHHardeningConfig struct {
+	Port                   int      `yaml:"port,omitempty" mapstructure:"port"`               // sshd port, default unchanged
+	AllowUsers             []string `yaml:"allow_users,omitempty" mapstructure:"allow_users"` // only these users may log in
+	Ciphers                []string `yaml:"ciphers,omitempty" mapstructure:"ciphers"`
+	MaxAuthTries           int      `yaml:"max_auth_tries,omitempty" mapstructure:"max_auth_tries"`
+	PermitRootLogin        string   `yaml:"permit_root_login,omitempty" mapstructure:"permit_root_login"` // no (default), prohibit-password, yes
+	PasswordAuthentication bool     `yaml:"password_authentication,omitempty" mapstructure:"password_authentication"`
+}
+
+// SSHHardeningFor returns the SSH hardening policy of a server, or nil when
+// neither the server nor the configuration sets one
+func (c *Config) SSHHardeningFor(serverName string) *SSHHardeningConfig {
+	if server := c.Servers[serverName]; server != nil && server.SSHHardening != nil {
+		return server.SSHHardening
+	}
+	return c.SSHHardening
+}
+
+// SecurityConfig configures intrusion protection set up by mah server init
+type SecurityConfig struct {
+	Fail2ban *Fail2banConfig `yaml:"fail2ban,omitempty" mapstructure:"fail2ban"`
+}
+
+// Fail2banConfig configures fail2ban with a jail for sshd and, when
+// traefik_log is set, one for failed logins in Traefik's access log
+type Fail2banConfig struct {
+	BanTime    string   `yaml:"bantime,omitempty" mapstructure:"bantime"`   // default 1h
+	FindTime   string   `yaml:"findtime,omitempty" mapstructure:"findtime"` // default 10m
+	MaxRetry   int      `yaml:"maxretry,omitempty" mapstructure:"maxretry"` // default 5
+	IgnoreIPs  []string `yaml:"ignore_ips,omitempty" mapstructure:"ignore_ips"`
+	TraefikLog string   `yaml:"traefik_log,omitempty" mapstructure:"traefik_log"` // access log in common log format on the host
+}
+
+// SecurityFor returns the security settings of a server, falling back to
+// those of its nexus, or nil when neither sets any
+func (c *Config) SecurityFor(serverName string) *SecurityConfig {
+	server := c.Servers[serverName]
+	if server == nil {
+		return nil
+	}
+	if server.Security != nil {
+		return server.Security
+	}
+	if nexus := c.Nexuses[server.Nexus]; nexus != nil {
+		return nexus.Security
+	}
+	return nil
+}
+
+// DockerConfig is the Docker daemon configuration mah renders to
+// /etc/docker/daemon.json. Logs rotate at 10m with 3 files unless set.
+type DockerConfig struct {
+	LogDriver           string              `yaml:"log_driver,omitempty" mapstructure:"log_driver"` // default json-file
+	LogMaxSize          string              `yaml:"log_max_size,omitempty" mapstructure:"log_max_size"`
+	LogMaxFile          int                 `yaml:"log_max_file,omitempty" mapstructure:"log_max_file"`
+	RegistryMirrors     []string            `yaml:"registry_mirrors,omitempty" mapstructure:"registry_mirrors"`
+	DefaultAddressPools []AddressPoolConfig `yaml:"default_address_pools,omitempty" mapstructure:"default_address_pools"`
+	LiveRestore         bool                `yaml:"live_restore,omitempty" mapstructure:"live_restore"`
+	UsernsRemap         string              `yaml:"userns_remap,omitempty" mapstructure:"userns_remap"` // "default" or user[:group]
+}
+
+// AddressPoolConfig is a range Docker allocates network subnets from
+type AddressPoolConfig struct {
+	Base string `yaml:"base" mapstructure:"base"` // e.g. 172.80.0.0/16
+	Size int    `yaml:"size" mapstructure:"size"` // e.g. 24
+}
+
+// DockerFor returns the Docker daemon settings of a server, falling back to
+// those of its nexus, or nil when neither sets any
+func (c *Config) DockerFor(serverName string) *DockerConfig {
+	server := c.Servers[serverName]
+	if server == nil {
+		return nil
+	}
+	if server.Docker != nil {
+		return server.Docker
+	}
+	if nexus := c.Nexuses[server.Nexus]; nexus != nil {
+		return nexus.Docker
+	}
+	return nil
+}
+
+// TeamUser is a team member with an account on every server of the
+// nexuses listed in Nexuses
+type TeamUser struct {
+	Keys    []string `yaml:"keys" mapstructure:"keys"` // authorized_keys lines
+	Nexuses []string `yaml:"nexuses" mapstructure:"nexuses"`
+	Shell   string   `yaml:"shell,omitempty" mapstructure:"shell"` // login shell for new accounts
+}
+
+// UsersFor returns the names of the team members with access to a nexus,
+// sorted
+func (c *Config) UsersFor(nexusName string) []string {
+	var names []string
+	for name, user := range c.Users {
+		for _, nexus := range user.Nexuses {
+			if nexus == nexusName {
+				names = append(names, name)
+				break
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// JumpConfig describes a jump host. It either names another server entry
+// (jump: bastion) or gives the connection details inline. Inline jump hosts
+// can be chained through their own jump field.
+type JumpConfig struct {
+	Server           string      `yaml:"server,omitempty" mapstructure:"server"`
+	Host             string      `yaml:"host,omitempty" mapstructure:"host"`
+	SSHUser          string      `yaml:"ssh_user,omitempty" mapstructure:"ssh_user"`
+	SSHKey           string      `yaml:"ssh_key,omitempty" mapstructure:"ssh_key"`
+	SSHKeyPassphrase string      `yaml:"ssh_key_passphrase,omitempty" mapstructure:"ssh_key_passphrase"`
+	SSHPort          int         `yaml:"ssh_port,omitempty" mapstructure:"ssh_port"`
+	HostKey          string      `yaml:"host_key,omitempty" mapstructure:"host_key"`
+	HostKeyCheck     string      `yaml:"host_key_check,omitempty" mapstructure:"host_key_check"`
+	Jump             *JumpConfig `yaml:"jump,omitempty" mapstructure:"jump"`
+
+	target *Server // resolved during validation
 }
 
 // Nexus represents a nexus (logical grouping) configuration
@@ -27,6 +247,55 @@ type Nexus struct {
 	Description string   `yaml:"description" mapstructure:"description"`
 	Servers     []string `yaml:"servers" mapstructure:"servers"`
 	Environment string   `yaml:"environment" mapstructure:"environment"`
+
+	// Intrusion protection for the nexus' servers
+	Security *SecurityConfig `yaml:"security,omitempty" mapstructure:"security"`
+
+	// Docker daemon settings for the nexus' servers
+	Docker *DockerConfig `yaml:"docker,omitempty" mapstructure:"docker"`
+
+	// Per-service settings on the nexus' servers, keyed by service name
+	Services map[string]*ServiceOverride `yaml:"services,omitempty" mapstructure:"services"`
+}
+
+// ServiceOverride changes a service's domains and environment on the
+// servers of one nexus. Domains replace the service's own; environment
+// variables are merged into them.
+type ServiceOverride struct {
+	Domains     map[string]string `yaml:"domains,omitempty" mapstructure:"domains"`
+	Environment map[string]string `yaml:"environment,omitempty" mapstructure:"environment"`
+}
+
+// ServiceIn returns a service with the overrides of a nexus applied, or nil
+// when the service does not exist. The service itself is left unchanged.
+func (c *Config) ServiceIn(nexusName, serviceName string) *Service {
+	service := c.Services[serviceName]
+	if service == nil {
+		return nil
+	}
+
+	var override *ServiceOverride
+	if nexus := c.Nexuses[nexusName]; nexus != nil {
+		override = nexus.Services[serviceName]
+	}
+	if override == nil {
+		return service
+	}
+
+	merged := *service
+	if len(override.Domains) > 0 {
+		merged.Domains = override.Domains
+	}
+	if len(override.Environment) > 0 {
+		merged.Environment = make(map[string]string, len(service.Environment)+len(override.Environment))
+		for key, value := range service.Environment {
+			merged.Environment[key] = value
+		}
+		for key, value := range override.Environment {
+			merged.Environment[key] = value
+		}
+	}
+	return &merged
 }
 
 // Service represents a service configuration
@@ -69,8 +338,8 @@ type PluginConfig struct {
 
 // FirewallConfig represents firewall configuration
 type FirewallConfig struct {
-	Global         []FirewallRule            `yaml:"global"`
-	ServerSpecific map[string][]FirewallRule `yaml:"server_specific"`
+	Global         []FirewallRule            `yaml:"global" mapstructure:"global"`
+	ServerSpecific map[string][]FirewallRule `yaml:"server_specific" mapstructure:"server_specific"`
 }
 
 // FirewallRule represents a firewall rule in configuration
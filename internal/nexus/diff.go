@@ -0,0 +1,287 @@
+package nexus
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jonas-jonas/mah/internal/config"
+	"github.com/jonas-jonas/mah/internal/state"
+)
+
+// Difference is one way two nexuses differ. For lists, Left and Right hold
+// the entries found only on that side.
+type Difference struct {
+	Service string `json:"service,omitempty"` // empty for nexus-wide differences
+	Field   string `json:"field"`             // service, image, environment, replicas, volumes, ports, firewall or status
+	Left    string `json:"left"`
+	Right   string `json:"right"`
+}
+
+// Diff compares two nexuses
+type Diff struct {
+	Left        string       `json:"left"`
+	Right       string       `json:"right"`
+	Differences []Difference `json:"differences"`
+}
+
+// nexusSpec is what a nexus runs, as far as Diff compares it
+type nexusSpec struct {
+	services map[string]*serviceSpec
+	firewall []string
+}
+
+// serviceSpec is how a nexus runs a service
+type serviceSpec struct {
+	image       string
+	imageDigest string // empty unless a deployment was recorded
+	envKeys     []string
+	replicas    int
+	volumes     []string
+	ports       []string
+	servers     int    // servers of the nexus that run the service
+	status      string // empty unless live status was gathered
+}
+
+// Diff compares the services and firewall rules of two nexuses. Images,
+// replicas, volumes and ports are compared as last deployed where mah
+// recorded a deployment, and as configured otherwise. With live, the
+// services' containers are checked on every server as well.
+func (m *Manager) Diff(ctx context.Context, left, right string, live bool) (*Diff, error) {
+	cfg := m.configMgr.GetConfig()
+	if cfg == nil {
+		return nil, fmt.Errorf("no configuration loaded")
+	}
+
+	var store *state.Store
+	if runtime := m.configMgr.GetRuntimeConfig(); runtime != nil {
+		store = state.NewStore(runtime.StateDir)
+	}
+
+	leftSpec, err := m.nexusSpec(ctx, cfg, store, left, live)
+	if err != nil {
+		return nil, err
+	}
+	rightSpec, err := m.nexusSpec(ctx, cfg, store, right, live)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Diff{Left: left, Right: right, Differences: compareNexuses(leftSpec, rightSpec)}, nil
+}
+
+// nexusSpec gathers what a nexus runs from the configuration, the recorded
+// deployments and, with live, the servers themselves
+func (m *Manager) nexusSpec(ctx context.Context, cfg *config.Config, store *state.Store, nexusName string, live bool) (*nexusSpec, error) {
+	nexus := cfg.Nexuses[nexusName]
+	if nexus == nil {
+		return nil, fmt.Errorf("nexus '%s' not found", nexusName)
+	}
+
+	spec := &nexusSpec{services: make(map[string]*serviceSpec)}
+	for name, service := range cfg.Services {
+		total := 0
+		for _, serverName := range service.Servers {
+			if containsServer(nexus.Servers, serverName) {
+				total++
+			}
+		}
+		if total == 0 {
+			continue
+		}
+
+		service = cfg.ServiceIn(nexusName, name)
+		envKeys := make([]string, 0, len(service.Environment))
+		for key := range service.Environment {
+			envKeys = append(envKeys, key)
+		}
+		sort.Strings(envKeys)
+
+		spec.services[name] = &serviceSpec{
+			image:    service.Image,
+			envKeys:  envKeys,
+			replicas: service.Replicas,
+			volumes:  service.Volumes,
+			ports:    service.Ports,
+			servers:  total,
+		}
+	}
+
+	// What was actually deployed takes precedence over the configuration
+	if store != nil {
+		deployments, err := store.Servers(nexus.Servers)
+		if err != nil {
+			return nil, err
+		}
+		for _, deployment := range deployments {
+			service := spec.services[deployment.Service]
+			if service == nil || service.imageDigest != "" || deployment.Config == nil {
+				continue
+			}
+			service.image = deployment.Image
+			service.imageDigest = deployment.ImageDigest
+			service.replicas = deployment.Config.Replicas
+			service.volumes = deployment.Config.Volumes
+			service.ports = deployment.Config.Ports
+		}
+	}
+
+	rules := make(map[string]bool)
+	if cfg.Firewall != nil {
+		for _, rule := range cfg.Firewall.Global {
+			rules[firewallRule(rule)] = true
+		}
+		for _, serverName := range nexus.Servers {
+			for _, rule := range cfg.Firewall.ServerSpecific[serverName] {
+				rules[firewallRule(rule)] = true
+			}
+		}
+	}
+	for rule := range rules {
+		spec.firewall = append(spec.firewall, rule)
+	}
+	sort.Strings(spec.firewall)
+
+	if live {
+		status, err := m.Status(ctx, nexusName)
+		if err != nil {
+			return nil, err
+		}
+
+		running := make(map[string]int)
+		for _, serverStatus := range status.ServerStatuses {
+			for _, serviceStatus := range serverStatus.Services {
+				if serviceStatus.Status == "running" {
+					running[serviceStatus.Name]++
+				}
+			}
+		}
+		for name, service := range spec.services {
+			service.status = "running on all servers"
+			if running[name] < service.servers {
+				service.status = fmt.Sprintf("running on %d/%d servers", running[name], service.servers)
+			}
+		}
+	}
+
+	return spec, nil
+}
+
+// compareNexuses lists the differences between two nexuses, sorted by
+// service with nexus-wide differences last
+func compareNexuses(left, right *nexusSpec) []Difference {
+	var names []string
+	for name := range left.services {
+		names = append(names, name)
+	}
+	for name := range right.services {
+		if left.services[name] == nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var differences []Difference
+	for _, name := range names {
+		l, r := left.services[name], right.services[name]
+		switch {
+		case r == nil:
+			differences = append(differences, Difference{Service: name, Field: "service", Left: "present", Right: "missing"})
+			continue
+		case l == nil:
+			differences = append(differences, Difference{Service: name, Field: "service", Left: "missing", Right: "present"})
+			continue
+		}
+
+		sameImage := l.image == r.image
+		if l.imageDigest != "" && r.imageDigest != "" {
+			sameImage = l.imageDigest == r.imageDigest
+		}
+		if !sameImage {
+			differences = append(differences, Difference{Service: name, Field: "image", Left: l.describeImage(), Right: r.describeImage()})
+		}
+
+		differences = appendListDifference(differences, name, "environment", l.envKeys, r.envKeys)
+
+		if l.replicas != r.replicas {
+			differences = append(differences, Difference{Service: name, Field: "replicas", Left: strconv.Itoa(l.replicas), Right: strconv.Itoa(r.replicas)})
+		}
+
+		differences = appendListDifference(differences, name, "volumes", l.volumes, r.volumes)
+		differences = appendListDifference(differences, name, "ports", l.ports, r.ports)
+
+		if l.status != r.status {
+			differences = append(differences, Difference{Service: name, Field: "status", Left: l.status, Right: r.status})
+		}
+	}
+
+	return appendListDifference(differences, "", "firewall", left.firewall, right.firewall)
+}
+
+// appendListDifference adds a difference when two lists do not hold the
+// same entries, ignoring their order
+func appendListDifference(differences []Difference, service, field string, left, right []string) []Difference {
+	onlyLeft, onlyRight := onlyIn(left, right), onlyIn(right, left)
+	if len(onlyLeft) == 0 && len(onlyRight) == 0 {
+		return differences
+	}
+	return append(differences, Difference{
+		Service: service,
+		Field:   field,
+		Left:    strings.Join(onlyLeft, ", "),
+		Right:   strings.Join(onlyRight, ", "),
+	})
+}
+
+// onlyIn returns the entries of list that are not in other
+func onlyIn(list, other []string) []string {
+	have := make(map[string]bool, len(other))
+	for _, entry := range other {
+		have[entry] = true
+	}
+
+	var only []string
+	for _, entry := range list {
+		if !have[entry] {
+			only = append(only, entry)
+		}
+	}
+	return only
+}
+
+// describeImage shows an image with the start of its digest, when known
+func (s *serviceSpec) describeImage() string {
+	if s.imageDigest == "" {
+		return s.image
+	}
+	digest := strings.TrimPrefix(s.imageDigest, "sha256:")
+	if len(digest) > 12 {
+		digest = digest[:12]
+	}
+	return fmt.Sprintf("%s (%s)", s.image, digest)
+}
+
+// firewallRule renders a firewall rule for comparison
+func firewallRule(rule config.FirewallRule) string {
+	protocol := rule.Protocol
+	if protocol == "" {
+		protocol = "tcp"
+	}
+	from := rule.From
+	if from == "" {
+		from = "any"
+	}
+	return fmt.Sprintf("%d/%s from %s", rule.Port, protocol, from)
+}
+
+// containsServer reports whether serverNames holds name
+func containsServer(serverNames []string, name string) bool {
+	for _, serverName := range serverNames {
+		if serverName == name {
+			return true
+		}
+	}
+	return false
+}
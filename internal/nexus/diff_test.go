@@ -0,0 +1,99 @@
+package nexus
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCompareNexuses(t *testing.T) {
+	blog := serviceSpec{
+		image:    "ghost:5",
+		envKeys:  []string{"DB_HOST", "DB_PASSWORD"},
+		replicas: 1,
+		volumes:  []string{"ghost:/var/lib/ghost"},
+		ports:    []string{"2368:2368"},
+	}
+
+	tests := []struct {
+		name  string
+		left  func(*serviceSpec)
+		right func(*serviceSpec)
+		want  []Difference
+	}{
+		{
+			name: "same",
+		},
+		{
+			name:  "same digest under another name",
+			left:  func(s *serviceSpec) { s.imageDigest = "sha256:aaa" },
+			right: func(s *serviceSpec) { s.image, s.imageDigest = "ghost@sha256:aaa", "sha256:aaa" },
+		},
+		{
+			name:  "different digest",
+			left:  func(s *serviceSpec) { s.imageDigest = "sha256:aaa" },
+			right: func(s *serviceSpec) { s.imageDigest = "sha256:bbb" },
+			want:  []Difference{{Service: "blog", Field: "image", Left: "ghost:5 (aaa)", Right: "ghost:5 (bbb)"}},
+		},
+		{
+			name:  "different tag",
+			right: func(s *serviceSpec) { s.image = "ghost:4" },
+			want:  []Difference{{Service: "blog", Field: "image", Left: "ghost:5", Right: "ghost:4"}},
+		},
+		{
+			name:  "env keys, replicas and ports",
+			left:  func(s *serviceSpec) { s.envKeys = []string{"DB_HOST", "DEBUG"} },
+			right: func(s *serviceSpec) { s.replicas = 3; s.ports = []string{"2368:2368", "9090:9090"} },
+			want: []Difference{
+				{Service: "blog", Field: "environment", Left: "DEBUG", Right: "DB_PASSWORD"},
+				{Service: "blog", Field: "replicas", Left: "1", Right: "3"},
+				{Service: "blog", Field: "ports", Right: "9090:9090"},
+			},
+		},
+		{
+			name:  "status",
+			left:  func(s *serviceSpec) { s.status = "running on all servers" },
+			right: func(s *serviceSpec) { s.status = "running on 1/2 servers" },
+			want:  []Difference{{Service: "blog", Field: "status", Left: "running on all servers", Right: "running on 1/2 servers"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			left, right := blog, blog
+			if tt.left != nil {
+				tt.left(&left)
+			}
+			if tt.right != nil {
+				tt.right(&right)
+			}
+
+			got := compareNexuses(
+				&nexusSpec{services: map[string]*serviceSpec{"blog": &left}},
+				&nexusSpec{services: map[string]*serviceSpec{"blog": &right}},
+			)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("compareNexuses() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompareNexusesServicesAndFirewall(t *testing.T) {
+	left := &nexusSpec{
+		services: map[string]*serviceSpec{"blog": {image: "ghost:5"}, "mysql": {image: "mysql:8"}},
+		firewall: []string{"22/tcp from any", "3306/tcp from 10.0.0.0/8"},
+	}
+	right := &nexusSpec{
+		services: map[string]*serviceSpec{"blog": {image: "ghost:5"}, "shop": {image: "shop:1"}},
+		firewall: []string{"22/tcp from any"},
+	}
+
+	want := []Difference{
+		{Service: "mysql", Field: "service", Left: "present", Right: "missing"},
+		{Service: "shop", Field: "service", Left: "missing", Right: "present"},
+		{Field: "firewall", Left: "3306/tcp from 10.0.0.0/8"},
+	}
+	if got := compareNexuses(left, right); !reflect.DeepEqual(got, want) {
+		t.Errorf("compareNexuses() = %+v, want %+v", got, want)
+	}
+}
@@ -2,17 +2,23 @@ package nexus
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/jonas-jonas/mah/internal/config"
+	"github.com/jonas-jonas/mah/internal/plugins/docker"
+	"github.com/jonas-jonas/mah/internal/server"
 	"github.com/jonas-jonas/mah/pkg"
 )
 
 // Manager handles nexus operations and management
 type Manager struct {
 	configMgr    *config.Manager
-	servers      map[string]pkg.Server
+	pool         *server.Pool
 	currentNexus string
 	mu           sync.RWMutex
 }
@@ -21,10 +27,31 @@ type Manager struct {
 func NewManager(configMgr *config.Manager) *Manager {
 	return &Manager{
 		configMgr: configMgr,
-		servers:   make(map[string]pkg.Server),
+		pool:      server.NewPool(server.NewFactory()),
 	}
 }
 
+// Server returns a connected server by its configuration name. Connections
+// are pooled and shared for the lifetime of the manager.
+func (m *Manager) Server(ctx context.Context, name string) (pkg.Server, error) {
+	cfg := m.configMgr.GetConfig()
+	if cfg == nil {
+		return nil, fmt.Errorf("no configuration loaded")
+	}
+
+	serverConfig := cfg.Servers[name]
+	if serverConfig == nil {
+		return nil, fmt.Errorf("server '%s' not found in configuration", name)
+	}
+
+	return m.pool.Get(ctx, name, serverConfig)
+}
+
+// Close disconnects all pooled server connections
+func (m *Manager) Close() error {
+	return m.pool.Close()
+}
+
 // Nexus represents a nexus with its configuration and servers
 type Nexus struct {
 	Name        string              `json:"name"`
@@ -119,6 +146,14 @@ func (m *Manager) Get(name string) (*Nexus, error) {
 // GetCurrent returns the currently active nexus
 func (m *Manager) GetCurrent() (*Nexus, error) {
 	currentNexusName := m.configMgr.GetCurrentNexus()
+
+	// A nexus chosen with Use takes precedence over the recorded one
+	m.mu.RLock()
+	if m.currentNexus != "" {
+		currentNexusName = m.currentNexus
+	}
+	m.mu.RUnlock()
+
 	if currentNexusName == "" {
 		return nil, fmt.Errorf("no current nexus set")
 	}
@@ -126,6 +161,20 @@ func (m *Manager) GetCurrent() (*Nexus, error) {
 	return m.Get(currentNexusName)
 }
 
+// Use makes name the current nexus for the lifetime of the manager, without
+// recording it like Switch does
+func (m *Manager) Use(name string) error {
+	if _, err := m.Get(name); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.currentNexus = name
+	return nil
+}
+
 // Switch switches to a different nexus
 func (m *Manager) Switch(name string) error {
 	// First validate nexus exists without holding any locks
@@ -165,6 +214,7 @@ func (m *Manager) Status(ctx context.Context, name string) (*Status, error) {
 		status   *ServerStatus
 	}, len(nexus.Servers))
 
+	cfg := m.configMgr.GetConfig()
 	for _, server := range nexus.Servers {
 		wg.Add(1)
 		go func(srv pkg.Server) {
@@ -178,12 +228,13 @@ func (m *Manager) Status(ctx context.Context, name string) (*Status, error) {
 				serverStatus.Error = err.Error()
 			} else {
 				serverStatus.Online = true
-				status.ServersOnline++
 
 				// Get resource information
 				if resources, err := srv.GetResources(ctx); err == nil {
 					serverStatus.Resources = resources
 				}
+
+				serverStatus.Services = serviceStatuses(ctx, cfg, srv)
 			}
 
 			statusChan <- struct {
@@ -199,15 +250,52 @@ func (m *Manager) Status(ctx context.Context, name string) (*Status, error) {
 	}()
 
 	// Collect results
+	servicesHealthy := true
 	for result := range statusChan {
 		status.ServerStatuses[result.serverID] = result.status
+		if result.status.Online {
+			status.ServersOnline++
+		}
+		status.ServicesTotal += len(result.status.Services)
+		for _, service := range result.status.Services {
+			if service.Health != "healthy" {
+				servicesHealthy = false
+			}
+		}
 	}
 
-	status.Healthy = status.ServersOnline == status.ServersTotal
+	status.Healthy = status.ServersOnline == status.ServersTotal && servicesHealthy
 
 	return status, nil
 }
 
+// serviceStatuses returns the status of the services configured for a
+// server, sorted by name. Services whose status cannot be read are reported
+// as error.
+func serviceStatuses(ctx context.Context, cfg *config.Config, srv pkg.Server) []*pkg.ServiceStatus {
+	var names []string
+	for name, service := range cfg.Services {
+		for _, serverName := range service.Servers {
+			if serverName == srv.ID() {
+				names = append(names, name)
+				break
+			}
+		}
+	}
+	sort.Strings(names)
+
+	provider := docker.NewProvider(map[string]pkg.Server{srv.ID(): srv}, cfg)
+	var statuses []*pkg.ServiceStatus
+	for _, name := range names {
+		serviceStatus, err := provider.StatusOn(ctx, name, srv.ID())
+		if err != nil {
+			serviceStatus = &pkg.ServiceStatus{Name: name, Status: "error", Health: "unknown"}
+		}
+		statuses = append(statuses, serviceStatus)
+	}
+	return statuses
+}
+
 // ExecuteOnAll executes a command on all servers in the current nexus
 func (m *Manager) ExecuteOnAll(ctx context.Context, cmd string, sudo bool) (map[string]*pkg.Result, error) {
 	currentNexus, err := m.GetCurrent()
@@ -220,51 +308,28 @@ func (m *Manager) ExecuteOnAll(ctx context.Context, cmd string, sudo bool) (map[
 
 // ExecuteOnNexus executes a command on all servers in a specific nexus
 func (m *Manager) ExecuteOnNexus(ctx context.Context, nexusName, cmd string, sudo bool) (map[string]*pkg.Result, error) {
-	nexus, err := m.Get(nexusName)
-	if err != nil {
-		return nil, err
+	cfg := m.configMgr.GetConfig()
+	if cfg == nil {
+		return nil, fmt.Errorf("no configuration loaded")
 	}
 
-	results := make(map[string]*pkg.Result)
-	var wg sync.WaitGroup
-	resultChan := make(chan struct {
-		serverID string
-		result   *pkg.Result
-		error    error
-	}, len(nexus.Servers))
-
-	// Execute command on each server in parallel
-	for _, server := range nexus.Servers {
-		wg.Add(1)
-		go func(srv pkg.Server) {
-			defer wg.Done()
-
-			result, err := srv.Execute(ctx, cmd, sudo)
-			resultChan <- struct {
-				serverID string
-				result   *pkg.Result
-				error    error
-			}{srv.ID(), result, err}
-		}(server)
+	nexusConfig := cfg.Nexuses[nexusName]
+	if nexusConfig == nil {
+		return nil, fmt.Errorf("nexus '%s' not found", nexusName)
 	}
 
-	go func() {
-		wg.Wait()
-		close(resultChan)
-	}()
-
-	// Collect results
+	results := make(map[string]*pkg.Result)
 	var lastError error
-	for result := range resultChan {
-		if result.error != nil {
-			lastError = result.error
+	for _, result := range m.Exec(ctx, nexusConfig.Servers, cmd, ExecOptions{Sudo: sudo}) {
+		if result.Err != nil {
+			lastError = result.Err
 			// Create error result
-			results[result.serverID] = &pkg.Result{
+			results[result.Server] = &pkg.Result{
 				ExitCode: -1,
-				Stderr:   result.error.Error(),
+				Stderr:   result.Err.Error(),
 			}
 		} else {
-			results[result.serverID] = result.result
+			results[result.Server] = result.Result
 		}
 	}
 
@@ -275,45 +340,100 @@ func (m *Manager) ExecuteOnNexus(ctx context.Context, nexusName, cmd string, sud
 	return results, nil
 }
 
-// getServersForNexus loads server instances for a nexus
-func (m *Manager) getServersForNexus(nexusName string) ([]pkg.Server, error) {
-	serverConfigs, err := m.configMgr.GetNexusServers(nexusName)
-	if err != nil {
-		return nil, err
+// ExecOptions control how Exec runs a command across servers
+type ExecOptions struct {
+	Sudo        bool
+	MaxParallel int           // servers running the command at once; 0 runs all at once
+	Timeout     time.Duration // per server, including connecting; 0 waits forever
+
+	// Output receives the output of every server as it arrives, each line
+	// prefixed with the server name. When nil, output is kept in the results.
+	Output io.Writer
+}
+
+// ExecResult is the outcome of a command on one server
+type ExecResult struct {
+	Server   string
+	Result   *pkg.Result // nil when the command could not be run
+	Err      error
+	Duration time.Duration
+}
+
+// Exec runs cmd on the named servers, at most opts.MaxParallel at a time,
+// and returns the results in the order of serverNames
+func (m *Manager) Exec(ctx context.Context, serverNames []string, cmd string, opts ExecOptions) []ExecResult {
+	results := make([]ExecResult, len(serverNames))
+
+	parallel := opts.MaxParallel
+	if parallel <= 0 || parallel > len(serverNames) {
+		parallel = len(serverNames)
 	}
+	slots := make(chan struct{}, parallel)
 
-	var servers []pkg.Server
-	for _, serverConfig := range serverConfigs {
-		// Create server instance (this will be implemented when we have server package)
-		// For now, we'll just create a placeholder
-		servers = append(servers, &mockServer{
-			id:   fmt.Sprintf("%s-%s", serverConfig.Host, serverConfig.SSHUser),
-			host: serverConfig.Host,
-		})
+	var wg sync.WaitGroup
+	for i, name := range serverNames {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
+			start := time.Now()
+			result, err := m.execOne(ctx, name, cmd, opts)
+			results[i] = ExecResult{Server: name, Result: result, Err: err, Duration: time.Since(start)}
+		}(i, name)
 	}
+	wg.Wait()
 
-	return servers, nil
+	return results
 }
 
-// mockServer is a temporary placeholder until we implement the real server package
-type mockServer struct {
-	id   string
-	host string
-}
+// execOne runs cmd on one server within the per-server timeout
+func (m *Manager) execOne(ctx context.Context, name, cmd string, opts ExecOptions) (*pkg.Result, error) {
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+
+	srv, err := m.Server(ctx, name)
+	if err == nil {
+		var result *pkg.Result
+		if opts.Output != nil {
+			result, err = pkg.StreamPrefixed(ctx, srv, cmd, opts.Sudo, opts.Output)
+		} else {
+			result, err = srv.Execute(ctx, cmd, opts.Sudo)
+		}
+		if err == nil {
+			return result, nil
+		}
+	}
 
-func (s *mockServer) Connect(ctx context.Context) error         { return nil }
-func (s *mockServer) Execute(ctx context.Context, cmd string, sudo bool) (*pkg.Result, error) {
-	return &pkg.Result{ExitCode: 0, Stdout: "mock output"}, nil
+	if errors.Is(err, context.DeadlineExceeded) && opts.Timeout > 0 {
+		return nil, fmt.Errorf("timed out after %s", opts.Timeout)
+	}
+	return nil, err
 }
-func (s *mockServer) TransferFile(ctx context.Context, local, remote string) error { return nil }
-func (s *mockServer) Disconnect() error                        { return nil }
-func (s *mockServer) GetDistro(ctx context.Context) (string, error) { return "ubuntu", nil }
-func (s *mockServer) GetResources(ctx context.Context) (*pkg.ResourceInfo, error) {
-	return &pkg.ResourceInfo{
-		CPU:    pkg.CPUInfo{Cores: 4, Usage: 25.0},
-		Memory: pkg.MemoryInfo{Total: 8000000000, Used: 2000000000, Usage: 25.0},
-	}, nil
+
+// getServersForNexus returns the servers of a nexus, keyed by their
+// configuration name. They connect on first use and share the pooled
+// connections.
+func (m *Manager) getServersForNexus(nexusName string) ([]pkg.Server, error) {
+	cfg := m.configMgr.GetConfig()
+	nexusConfig := cfg.Nexuses[nexusName]
+	if nexusConfig == nil {
+		return nil, fmt.Errorf("nexus '%s' not found", nexusName)
+	}
+
+	var servers []pkg.Server
+	for _, serverName := range nexusConfig.Servers {
+		serverConfig := cfg.Servers[serverName]
+		if serverConfig == nil {
+			return nil, fmt.Errorf("server '%s' referenced by nexus '%s' not found", serverName, nexusName)
+		}
+		servers = append(servers, m.pool.Lazy(serverName, serverConfig))
+	}
+
+	return servers, nil
 }
-func (s *mockServer) HealthCheck(ctx context.Context) error { return nil }
-func (s *mockServer) ID() string                           { return s.id }
-func (s *mockServer) Host() string                         { return s.host }
\ No newline at end of file
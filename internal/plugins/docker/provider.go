@@ -2,11 +2,19 @@ package docker
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
+	"os"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/jonas-jonas/mah/internal/config"
+	"github.com/jonas-jonas/mah/internal/server"
+	"github.com/jonas-jonas/mah/internal/state"
 	"github.com/jonas-jonas/mah/pkg"
 )
 
@@ -14,6 +22,7 @@ import (
 type Provider struct {
 	servers map[string]pkg.Server
 	config  *config.Config
+	state   *state.Store
 }
 
 // NewProvider creates a new Docker provider
@@ -24,6 +33,12 @@ func NewProvider(servers map[string]pkg.Server, config *config.Config) *Provider
 	}
 }
 
+// RecordTo makes Deploy record every successful deployment in store
+func (p *Provider) RecordTo(store *state.Store) *Provider {
+	p.state = store
+	return p
+}
+
 // Deploy deploys a service using Docker Compose
 func (p *Provider) Deploy(serviceConfig *pkg.ServiceConfig) error {
 	ctx := context.Background()
@@ -49,6 +64,12 @@ func (p *Provider) Deploy(serviceConfig *pkg.ServiceConfig) error {
 		}
 
 		fmt.Printf("✅ Service '%s' deployed successfully to '%s'\n", serviceConfig.Name, serverName)
+
+		if p.state != nil {
+			if err := p.recordDeployment(ctx, server, serverName, serviceConfig, composeContent); err != nil {
+				fmt.Printf("⚠️  Failed to record deployment state: %v\n", err)
+			}
+		}
 	}
 
 	return nil
@@ -143,9 +164,9 @@ func (p *Provider) Status(serviceName string) (*pkg.ServiceStatus, error) {
 	}, nil
 }
 
-// Logs streams logs from a service
-func (p *Provider) Logs(serviceName string, follow bool) (<-chan string, error) {
-	ctx := context.Background()
+// Logs streams logs from a service on all of its servers, prefixing each
+// line with the server name. Following stops when ctx is cancelled.
+func (p *Provider) Logs(ctx context.Context, serviceName string, follow bool) (<-chan string, error) {
 	logChan := make(chan string, 100)
 
 	// Find service configuration
@@ -155,54 +176,272 @@ func (p *Provider) Logs(serviceName string, follow bool) (<-chan string, error)
 		return logChan, fmt.Errorf("service '%s' not found", serviceName)
 	}
 
-	// Stream logs from first server
 	if len(service.Servers) == 0 {
 		close(logChan)
 		return logChan, fmt.Errorf("service '%s' has no servers configured", serviceName)
 	}
 
-	serverName := service.Servers[0]
+	// Stream logs from every server concurrently
+	var wg sync.WaitGroup
+	for _, serverName := range service.Servers {
+		server, exists := p.servers[serverName]
+		if !exists {
+			continue
+		}
+
+		wg.Add(1)
+		go func(serverName string, server pkg.Server) {
+			defer wg.Done()
+			streamLogs(ctx, server, serverName, serviceName, follow, logChan)
+		}(serverName, server)
+	}
+
+	go func() {
+		wg.Wait()
+		close(logChan)
+	}()
+
+	return logChan, nil
+}
+
+// streamLogs sends the logs of a service on one server to logChan
+func streamLogs(ctx context.Context, server pkg.Server, serverName, serviceName string, follow bool, logChan chan<- string) {
+	send := func(line string) {
+		if strings.TrimSpace(line) == "" {
+			return
+		}
+		select {
+		case logChan <- fmt.Sprintf("[%s] %s", serverName, line):
+		case <-ctx.Done():
+		}
+	}
+
+	followFlag := ""
+	if follow {
+		followFlag = "-f"
+	}
+
+	stdout := pkg.NewLineWriter(send)
+	stderr := pkg.NewLineWriter(send)
+
+	cmd := fmt.Sprintf("sh -c 'cd /opt/mah/services/%s && docker compose logs %s --tail=50'", serviceName, followFlag)
+	result, err := server.Stream(ctx, cmd, pkg.StreamOptions{
+		Stdout: stdout,
+		Stderr: stderr,
+	})
+	stdout.Flush()
+	stderr.Flush()
+
+	if err != nil {
+		if ctx.Err() == nil {
+			send(fmt.Sprintf("Error getting logs: %v", err))
+		}
+		return
+	}
+
+	if result.ExitCode != 0 {
+		send(fmt.Sprintf("Log command failed with exit code %d", result.ExitCode))
+	}
+}
+
+// Address returns the address at which a service's container port can be
+// reached from the server itself: the published host port when there is one,
+// otherwise the container's IP on its Docker network
+func (p *Provider) Address(ctx context.Context, serviceName, serverName string, port int) (string, error) {
 	server, exists := p.servers[serverName]
 	if !exists {
-		close(logChan)
-		return logChan, fmt.Errorf("server '%s' not found", serverName)
+		return "", fmt.Errorf("server '%s' not found", serverName)
 	}
 
-	// Start log streaming in a goroutine
-	go func() {
-		defer close(logChan)
+	composeFile := fmt.Sprintf("/opt/mah/services/%s/docker-compose.yml", serviceName)
 
-		followFlag := ""
-		if follow {
-			followFlag = "-f"
+	// Published ports are reachable on the server's loopback interface
+	cmd := fmt.Sprintf("docker compose -f %s port %s %d", composeFile, serviceName, port)
+	result, err := server.Execute(ctx, cmd, true)
+	if err != nil {
+		return "", fmt.Errorf("failed to look up published port: %w", err)
+	}
+	if result.ExitCode == 0 {
+		if _, hostPort, err := net.SplitHostPort(strings.TrimSpace(result.Stdout)); err == nil && hostPort != "0" {
+			return net.JoinHostPort("127.0.0.1", hostPort), nil
 		}
+	}
 
-		cmd := fmt.Sprintf("sh -c 'cd /opt/mah/services/%s && docker compose logs %s --tail=50'", serviceName, followFlag)
-		result, err := server.Execute(ctx, cmd, false)
-		if err != nil {
-			logChan <- fmt.Sprintf("Error getting logs: %v", err)
-			return
+	// Otherwise connect to the container directly
+	cmd = fmt.Sprintf("sh -c 'docker inspect -f \"{{range .NetworkSettings.Networks}}{{.IPAddress}} {{end}}\" $(docker compose -f %s ps -q %s | head -1)'",
+		composeFile, serviceName)
+	result, err = server.Execute(ctx, cmd, true)
+	if err != nil {
+		return "", fmt.Errorf("failed to look up container address: %w", err)
+	}
+	if result.ExitCode != 0 {
+		return "", fmt.Errorf("service '%s' is not running on server '%s'", serviceName, serverName)
+	}
+
+	if ips := strings.Fields(result.Stdout); len(ips) > 0 {
+		return net.JoinHostPort(ips[0], strconv.Itoa(port)), nil
+	}
+	return "", fmt.Errorf("container of service '%s' has no IP address on server '%s'", serviceName, serverName)
+}
+
+// StopOn stops a service's containers on one server, keeping them for StartOn
+func (p *Provider) StopOn(ctx context.Context, serviceName, serverName string) error {
+	return p.composeOn(ctx, serviceName, serverName, "stop")
+}
+
+// StartOn starts a service's containers on one server
+func (p *Provider) StartOn(ctx context.Context, serviceName, serverName string) error {
+	return p.composeOn(ctx, serviceName, serverName, "up -d")
+}
+
+// composeOn runs a docker compose subcommand for a service on one server
+func (p *Provider) composeOn(ctx context.Context, serviceName, serverName, subcommand string) error {
+	server, exists := p.servers[serverName]
+	if !exists {
+		return fmt.Errorf("server '%s' not found", serverName)
+	}
+
+	cmd := fmt.Sprintf("sh -c 'cd /opt/mah/services/%s && docker compose %s'", serviceName, subcommand)
+	result, err := server.Execute(ctx, cmd, true)
+	if err != nil {
+		return fmt.Errorf("failed to run docker compose %s for service '%s' on server '%s': %w", subcommand, serviceName, serverName, err)
+	}
+	if result.ExitCode != 0 {
+		return fmt.Errorf("docker compose %s failed for service '%s' on server '%s': %s", subcommand, serviceName, serverName, result.Stderr)
+	}
+	return nil
+}
+
+// composeContainer is a container as listed by docker compose ps
+type composeContainer struct {
+	Name   string `json:"Name"`
+	State  string `json:"State"`
+	Health string `json:"Health"`
+}
+
+// HealthOn reports whether a service is up on one server: every container
+// is running and, where it has a health check, healthy. When it is not, the
+// returned detail names the containers that are not ready.
+func (p *Provider) HealthOn(ctx context.Context, serviceName, serverName string) (bool, string, error) {
+	containers, err := p.containersOn(ctx, serviceName, serverName)
+	if err != nil {
+		return false, "", err
+	}
+	if len(containers) == 0 {
+		return false, "no containers", nil
+	}
+
+	var waiting []string
+	for _, c := range containers {
+		switch {
+		case c.State != "running":
+			waiting = append(waiting, fmt.Sprintf("%s %s", c.Name, c.State))
+		case c.Health != "" && c.Health != "healthy":
+			waiting = append(waiting, fmt.Sprintf("%s %s", c.Name, c.Health))
 		}
+	}
+	return len(waiting) == 0, strings.Join(waiting, ", "), nil
+}
 
-		if result.ExitCode != 0 {
-			logChan <- fmt.Sprintf("Log command failed: %s", result.Stderr)
-			return
+// StatusOn returns the status of a service's containers on one server.
+// Status is running, degraded, stopped or not_deployed; Replicas counts the
+// running containers.
+func (p *Provider) StatusOn(ctx context.Context, serviceName, serverName string) (*pkg.ServiceStatus, error) {
+	status := &pkg.ServiceStatus{Name: serviceName, Status: "not_deployed", Health: "unknown"}
+	if service := p.config.Services[serviceName]; service != nil {
+		status.Ports = extractPortNumbers(service.Ports)
+	}
+
+	containers, err := p.containersOn(ctx, serviceName, serverName)
+	if err != nil {
+		if errors.Is(err, errNotDeployed) {
+			return status, nil
 		}
+		return nil, err
+	}
+	if len(containers) == 0 {
+		return status, nil
+	}
 
-		// Send logs line by line
-		lines := strings.Split(result.Stdout, "\n")
-		for _, line := range lines {
-			if strings.TrimSpace(line) != "" {
-				select {
-				case logChan <- line:
-				case <-ctx.Done():
-					return
-				}
-			}
+	status.Health = "healthy"
+	for _, c := range containers {
+		if c.State == "running" {
+			status.Replicas++
 		}
-	}()
+		switch {
+		case c.State != "running" || c.Health == "unhealthy":
+			status.Health = "unhealthy"
+		case c.Health == "starting" && status.Health == "healthy":
+			status.Health = "starting"
+		}
+	}
 
-	return logChan, nil
+	switch status.Replicas {
+	case len(containers):
+		status.Status = "running"
+	case 0:
+		status.Status = "stopped"
+	default:
+		status.Status = "degraded"
+	}
+	return status, nil
+}
+
+// errNotDeployed is returned by containersOn for services without a compose
+// project on the server
+var errNotDeployed = errors.New("service not deployed")
+
+// containersOn lists a service's containers on one server, including
+// stopped ones
+func (p *Provider) containersOn(ctx context.Context, serviceName, serverName string) ([]composeContainer, error) {
+	server, exists := p.servers[serverName]
+	if !exists {
+		return nil, fmt.Errorf("server '%s' not found", serverName)
+	}
+
+	serviceDir := fmt.Sprintf("/opt/mah/services/%s", serviceName)
+	cmd := fmt.Sprintf("sh -c 'test -d %[1]s || exit 3; cd %[1]s && docker compose ps -a --format json'", serviceDir)
+	result, err := server.Execute(ctx, cmd, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get service status: %w", err)
+	}
+	if result.ExitCode == 3 {
+		return nil, errNotDeployed
+	}
+	if result.ExitCode != 0 {
+		return nil, fmt.Errorf("docker compose ps failed for service '%s' on server '%s': %s", serviceName, serverName, result.Stderr)
+	}
+
+	return parseComposePS(result.Stdout)
+}
+
+// parseComposePS parses docker compose ps --format json, which older
+// Compose versions print as an array and newer ones as one object per line
+func parseComposePS(output string) ([]composeContainer, error) {
+	output = strings.TrimSpace(output)
+	if output == "" {
+		return nil, nil
+	}
+
+	var containers []composeContainer
+	if strings.HasPrefix(output, "[") {
+		if err := json.Unmarshal([]byte(output), &containers); err != nil {
+			return nil, fmt.Errorf("failed to parse docker compose ps output: %w", err)
+		}
+		return containers, nil
+	}
+
+	for _, line := range strings.Split(output, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		var c composeContainer
+		if err := json.Unmarshal([]byte(line), &c); err != nil {
+			return nil, fmt.Errorf("failed to parse docker compose ps output: %w", err)
+		}
+		containers = append(containers, c)
+	}
+	return containers, nil
 }
 
 // Remove removes a service
@@ -246,30 +485,25 @@ func (p *Provider) Remove(serviceName string) error {
 }
 
 // deployToServer deploys a service to a specific server
-func (p *Provider) deployToServer(ctx context.Context, server pkg.Server, serviceConfig *pkg.ServiceConfig, composeContent string) error {
+func (p *Provider) deployToServer(ctx context.Context, srv pkg.Server, serviceConfig *pkg.ServiceConfig, composeContent string) error {
 	// Ensure server is connected
-	err := server.Connect(ctx)
+	err := srv.Connect(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to connect to server: %w", err)
 	}
 
 	// Create service directory
 	serviceDir := fmt.Sprintf("/opt/mah/services/%s", serviceConfig.Name)
-	result, err := server.Execute(ctx, fmt.Sprintf("mkdir -p %s", serviceDir), true)
+	result, err := srv.Execute(ctx, fmt.Sprintf("mkdir -p %s", serviceDir), true)
 	if err != nil {
 		return fmt.Errorf("failed to create service directory: %w", err)
 	}
 
-	// Write docker-compose.yml file using sudo tee to handle permissions properly
+	// Write docker-compose.yml file as root
 	composeFile := fmt.Sprintf("%s/docker-compose.yml", serviceDir)
-	cmd := fmt.Sprintf("cat << 'EOF' | sudo tee %s > /dev/null\n%s\nEOF", composeFile, composeContent)
-	result, err = server.Execute(ctx, cmd, false)
-	if err != nil {
+	if err := server.WriteFile(ctx, srv, composeFile, composeContent+"\n"); err != nil {
 		return fmt.Errorf("failed to write docker-compose file: %w", err)
 	}
-	if result.ExitCode != 0 {
-		return fmt.Errorf("failed to write docker-compose file: %s", result.Stderr)
-	}
 
 	// Create .env file if service has environment variables
 	if len(serviceConfig.Environment) > 0 {
@@ -279,19 +513,14 @@ func (p *Provider) deployToServer(ctx context.Context, server pkg.Server, servic
 		}
 
 		envFile := fmt.Sprintf("%s/.env", serviceDir)
-		cmd = fmt.Sprintf("cat << 'EOF' | sudo tee %s > /dev/null\n%s\nEOF", envFile, envContent)
-		result, err = server.Execute(ctx, cmd, false)
-		if err != nil {
+		if err := server.WriteFile(ctx, srv, envFile, envContent); err != nil {
 			return fmt.Errorf("failed to write .env file: %w", err)
 		}
-		if result.ExitCode != 0 {
-			return fmt.Errorf("failed to write .env file: %s", result.Stderr)
-		}
 	}
 
-	// Pull images
-	cmd = fmt.Sprintf("sh -c 'cd %s && docker compose pull'", serviceDir)
-	result, err = server.Execute(ctx, cmd, true)
+	// Pull images, showing progress as it happens
+	cmd := fmt.Sprintf("sh -c 'cd %s && docker compose pull'", serviceDir)
+	result, err = pkg.StreamPrefixed(ctx, srv, cmd, true, os.Stdout)
 	if err != nil {
 		return fmt.Errorf("failed to pull Docker images: %w", err)
 	}
@@ -301,7 +530,7 @@ func (p *Provider) deployToServer(ctx context.Context, server pkg.Server, servic
 
 	// Deploy service
 	cmd = fmt.Sprintf("sh -c 'cd %s && docker compose up -d'", serviceDir)
-	result, err = server.Execute(ctx, cmd, true)
+	result, err = srv.Execute(ctx, cmd, true)
 	if err != nil {
 		return fmt.Errorf("failed to deploy service: %w", err)
 	}
@@ -312,6 +541,73 @@ func (p *Provider) deployToServer(ctx context.Context, server pkg.Server, servic
 	return nil
 }
 
+// recordDeployment records the revision just deployed to a server
+func (p *Provider) recordDeployment(ctx context.Context, server pkg.Server, serverName string, serviceConfig *pkg.ServiceConfig, composeContent string) error {
+	digest, err := imageDigest(ctx, server, serviceConfig.Image)
+	if err != nil {
+		return err
+	}
+
+	return p.state.Record(pkg.Deployment{
+		Service:     serviceConfig.Name,
+		Server:      serverName,
+		Image:       serviceConfig.Image,
+		ImageDigest: digest,
+		ComposeHash: state.Hash(composeContent),
+		EnvHash:     state.EnvHash(serviceConfig.Environment),
+		DeployedAt:  time.Now().UTC(),
+		DeployedBy:  state.Operator(),
+		Config:      serviceConfig,
+	})
+}
+
+// imageDigest returns the registry digest of an image pulled on a server.
+// Images that were built locally have none and return an empty digest.
+func imageDigest(ctx context.Context, server pkg.Server, image string) (string, error) {
+	cmd := fmt.Sprintf("docker image inspect --format '{{json .RepoDigests}}' %s", image)
+	result, err := server.Execute(ctx, cmd, true)
+	if err != nil {
+		return "", fmt.Errorf("failed to inspect image: %w", err)
+	}
+	if result.ExitCode != 0 {
+		return "", fmt.Errorf("failed to inspect image '%s': %s", image, strings.TrimSpace(result.Stderr))
+	}
+
+	var repoDigests []string
+	if err := json.Unmarshal([]byte(strings.TrimSpace(result.Stdout)), &repoDigests); err != nil {
+		return "", fmt.Errorf("failed to parse image digests: %w", err)
+	}
+
+	// An image pulled by digest has that digest first; otherwise prefer the
+	// one from the repository the image names
+	repository := imageRepository(image)
+	for _, repoDigest := range repoDigests {
+		if repo, digest, ok := strings.Cut(repoDigest, "@"); ok && repo == repository {
+			return digest, nil
+		}
+	}
+	if len(repoDigests) > 0 {
+		if _, digest, ok := strings.Cut(repoDigests[0], "@"); ok {
+			return digest, nil
+		}
+	}
+	return "", nil
+}
+
+// PinnedImage returns image pinned to a registry digest, dropping its tag
+func PinnedImage(image, digest string) string {
+	return imageRepository(image) + "@" + digest
+}
+
+// imageRepository strips the tag and digest from an image reference
+func imageRepository(image string) string {
+	image, _, _ = strings.Cut(image, "@")
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		image = image[:i]
+	}
+	return image
+}
+
 // generateComposeFile generates a docker-compose.yml file for the service
 func (p *Provider) generateComposeFile(serviceConfig *pkg.ServiceConfig) (string, error) {
 	compose := ComposeFile{
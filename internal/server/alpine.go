@@ -0,0 +1,341 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/jonas-jonas/mah/pkg"
+)
+
+// iptablesChain holds the rules managed by mah. INPUT jumps to it first, so
+// chains added by Docker and other tools are left alone.
+const iptablesChain = "MAH-INPUT"
+
+// alpineUpgradeScript is run daily by crond to apply package upgrades
+const alpineUpgradeScript = `#!/bin/sh
+# Managed by mah: apply package upgrades daily
+apk upgrade --update-cache --quiet
+`
+
+// AlpineOperations provides Alpine Linux-specific server operations
+type AlpineOperations struct {
+	server pkg.Server
+}
+
+// NewAlpineOperations creates Alpine Linux operations for a server
+func NewAlpineOperations(server pkg.Server) *AlpineOperations {
+	return &AlpineOperations{server: server}
+}
+
+// InstallDocker installs Docker from the Alpine community repository
+func (a *AlpineOperations) InstallDocker(ctx context.Context) error {
+	// Check if Docker is already installed
+	result, err := a.server.Execute(ctx, "which docker", false)
+	if err == nil && result.ExitCode == 0 {
+		// Docker is installed, check version
+		result, err = a.server.Execute(ctx, "docker --version", false)
+		if err == nil && result.ExitCode == 0 {
+			return nil // Docker is already installed and working
+		}
+	}
+
+	// Docker lives in the community repository, which is commented out on
+	// minimal installs. Only the release's own branch is enabled, never the
+	// edge/community line setup-apkrepos may leave behind.
+	result, err = a.server.Execute(ctx, `sed -i -E 's|^#[[:space:]]*(.*/v[0-9]+\.[0-9]+/community)$|\1|' /etc/apk/repositories`, true)
+	if err != nil {
+		return fmt.Errorf("failed to enable community repository: %w", err)
+	}
+	if result.ExitCode != 0 {
+		return fmt.Errorf("community repository setup failed: %s", result.Stderr)
+	}
+
+	result, err = a.server.Execute(ctx, "apk update", true)
+	if err != nil {
+		return fmt.Errorf("failed to update package index: %w", err)
+	}
+	if result.ExitCode != 0 {
+		return fmt.Errorf("package index update failed: %s", result.Stderr)
+	}
+
+	// Install Docker and the compose plugin
+	result, err = a.server.Execute(ctx, "apk add docker docker-cli-compose", true)
+	if err != nil {
+		return fmt.Errorf("failed to install Docker: %w", err)
+	}
+	if result.ExitCode != 0 {
+		return fmt.Errorf("Docker installation failed: %s", result.Stderr)
+	}
+
+	// Enable Docker at boot and start it
+	result, err = a.server.Execute(ctx, "rc-update add docker default", true)
+	if err != nil {
+		return fmt.Errorf("failed to enable Docker service: %w", err)
+	}
+	if result.ExitCode != 0 {
+		return fmt.Errorf("Docker service enable failed: %s", result.Stderr)
+	}
+
+	result, err = a.server.Execute(ctx, "rc-service docker start", true)
+	if err != nil {
+		return fmt.Errorf("failed to start Docker service: %w", err)
+	}
+	if result.ExitCode != 0 {
+		return fmt.Errorf("Docker service start failed: %s", result.Stderr)
+	}
+
+	// Add user to docker group (if not root)
+	result, err = a.server.Execute(ctx, "whoami", false)
+	if err == nil && result.ExitCode == 0 {
+		user := strings.TrimSpace(result.Stdout)
+		if user != "root" {
+			a.server.Execute(ctx, fmt.Sprintf("addgroup %s docker", user), true)
+		}
+	}
+
+	// Verify Docker installation
+	result, err = a.server.Execute(ctx, "docker --version", true)
+	if err != nil {
+		return fmt.Errorf("failed to verify Docker installation: %w", err)
+	}
+	if result.ExitCode != 0 {
+		return fmt.Errorf("Docker verification failed: %s", result.Stderr)
+	}
+
+	return nil
+}
+
+// ConfigureFirewall sets up iptables on Alpine Linux and saves the rules so
+// the OpenRC iptables services restore them at boot
+func (a *AlpineOperations) ConfigureFirewall(ctx context.Context, rules []pkg.FirewallRule) error {
+	// Install iptables if not present
+	result, err := a.server.Execute(ctx, "which iptables-restore ip6tables-restore", false)
+	if err != nil || result.ExitCode != 0 {
+		result, err = a.server.Execute(ctx, "apk add iptables ip6tables", true)
+		if err != nil {
+			return fmt.Errorf("failed to install iptables: %w", err)
+		}
+		if result.ExitCode != 0 {
+			return fmt.Errorf("iptables installation failed: %s", result.Stderr)
+		}
+	}
+
+	for _, family := range []string{"iptables", "ip6tables"} {
+		// Replace the managed chain in one step, so the server is never
+		// left with half a rule set
+		ruleset := iptablesRuleset(family, rules)
+		result, err = runWithInput(ctx, a.server, family+"-restore --noflush", ruleset)
+		if err != nil {
+			return fmt.Errorf("failed to load %s rules: %w", family, err)
+		}
+		if result.ExitCode != 0 {
+			return fmt.Errorf("%s rules failed to load: %s", family, result.Stderr)
+		}
+
+		// Jump to the managed chain from INPUT once
+		jump := fmt.Sprintf("%[1]s -C INPUT -j %[2]s 2>/dev/null || %[1]s -I INPUT 1 -j %[2]s", family, iptablesChain)
+		result, err = a.server.Execute(ctx, "sh -c "+ShellQuote(jump), true)
+		if err != nil {
+			return fmt.Errorf("failed to enable %s rules: %w", family, err)
+		}
+		if result.ExitCode != 0 {
+			return fmt.Errorf("%s rules enable failed: %s", family, result.Stderr)
+		}
+
+		// Persist the rules and restore them at boot
+		result, err = a.server.Execute(ctx, fmt.Sprintf("rc-service %s save", family), true)
+		if err != nil {
+			return fmt.Errorf("failed to save %s rules: %w", family, err)
+		}
+		if result.ExitCode != 0 {
+			return fmt.Errorf("%s rules save failed: %s", family, result.Stderr)
+		}
+
+		result, err = a.server.Execute(ctx, fmt.Sprintf("rc-update add %s default", family), true)
+		if err != nil {
+			return fmt.Errorf("failed to enable %s service: %w", family, err)
+		}
+		if result.ExitCode != 0 {
+			return fmt.Errorf("%s service enable failed: %s", family, result.Stderr)
+		}
+	}
+
+	return nil
+}
+
+// iptablesRuleset renders rules in iptables-restore format for iptables or
+// ip6tables. Rules with a source of the other address family are skipped.
+func iptablesRuleset(family string, rules []pkg.FirewallRule) string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "*filter\n:%s - [0:0]\n", iptablesChain)
+	fmt.Fprintf(&b, "-A %s -i lo -j ACCEPT\n", iptablesChain)
+	fmt.Fprintf(&b, "-A %s -m conntrack --ctstate RELATED,ESTABLISHED -j ACCEPT\n", iptablesChain)
+	if family == "ip6tables" {
+		fmt.Fprintf(&b, "-A %s -p ipv6-icmp -j ACCEPT\n", iptablesChain)
+	} else {
+		fmt.Fprintf(&b, "-A %s -p icmp -j ACCEPT\n", iptablesChain)
+	}
+
+	for _, rule := range rules {
+		source := ""
+		if rule.Source != "any" && rule.Source != "" {
+			if strings.Contains(rule.Source, ":") != (family == "ip6tables") {
+				continue
+			}
+			source = " -s " + rule.Source
+		}
+
+		target := "ACCEPT"
+		if rule.Action == "deny" {
+			target = "DROP"
+		}
+
+		comment := ""
+		if rule.Comment != "" {
+			comment = fmt.Sprintf(" -m comment --comment \"%s\"", strings.ReplaceAll(rule.Comment, "\"", ""))
+		}
+
+		protocols := []string{rule.Protocol}
+		switch rule.Protocol {
+		case "":
+			protocols = []string{"tcp"}
+		case "tcp/udp":
+			protocols = []string{"tcp", "udp"}
+		}
+
+		for _, protocol := range protocols {
+			fmt.Fprintf(&b, "-A %s%s -p %s --dport %d%s -j %s\n",
+				iptablesChain, source, protocol, rule.Port, comment, target)
+		}
+	}
+
+	// Deny everything else
+	fmt.Fprintf(&b, "-A %s -j DROP\nCOMMIT\n", iptablesChain)
+	return b.String()
+}
+
+// UpdateSystem updates Alpine Linux system packages
+func (a *AlpineOperations) UpdateSystem(ctx context.Context) error {
+	// Update package index
+	result, err := pkg.StreamPrefixed(ctx, a.server, "apk update", true, os.Stdout)
+	if err != nil {
+		return fmt.Errorf("failed to update package index: %w", err)
+	}
+	if result.ExitCode != 0 {
+		return fmt.Errorf("package index update failed: %s", result.Stderr)
+	}
+
+	// Upgrade packages
+	result, err = pkg.StreamPrefixed(ctx, a.server, "apk upgrade", true, os.Stdout)
+	if err != nil {
+		return fmt.Errorf("failed to upgrade packages: %w", err)
+	}
+	if result.ExitCode != 0 {
+		return fmt.Errorf("package upgrade failed: %s", result.Stderr)
+	}
+
+	return nil
+}
+
+// ConfigureAutomaticUpdates sets up a daily upgrade job run by crond. apk
+// has no security-only upgrades, so all packages are upgraded.
+func (a *AlpineOperations) ConfigureAutomaticUpdates(ctx context.Context) error {
+	const script = "/etc/periodic/daily/mah-apk-upgrade"
+
+	if err := WriteFile(ctx, a.server, script, alpineUpgradeScript); err != nil {
+		return fmt.Errorf("failed to configure automatic updates: %w", err)
+	}
+
+	result, err := a.server.Execute(ctx, fmt.Sprintf("chmod 755 %s", script), true)
+	if err != nil {
+		return fmt.Errorf("failed to make upgrade job executable: %w", err)
+	}
+	if result.ExitCode != 0 {
+		return fmt.Errorf("upgrade job setup failed: %s", result.Stderr)
+	}
+
+	// crond runs the periodic jobs
+	result, err = a.server.Execute(ctx, "rc-update add crond default", true)
+	if err != nil {
+		return fmt.Errorf("failed to enable crond: %w", err)
+	}
+	if result.ExitCode != 0 {
+		return fmt.Errorf("crond enable failed: %s", result.Stderr)
+	}
+
+	result, err = a.server.Execute(ctx, "rc-service crond start", true)
+	if err != nil {
+		return fmt.Errorf("failed to start crond: %w", err)
+	}
+	if result.ExitCode != 0 {
+		return fmt.Errorf("crond start failed: %s", result.Stderr)
+	}
+
+	return nil
+}
+
+// HardenSSH applies the SSH policy as a managed sshd drop-in. Alpine's
+// sshd_config may predate drop-ins, in which case the Include is added.
+func (a *AlpineOperations) HardenSSH(ctx context.Context, policy pkg.SSHPolicy) error {
+	// Minimal installs may run dropbear instead of OpenSSH
+	result, err := a.server.Execute(ctx, "which sshd", false)
+	if err != nil || result.ExitCode != 0 {
+		return fmt.Errorf("OpenSSH server not installed (dropbear is not supported)")
+	}
+
+	return hardenSSH(ctx, a.server, policy, "rc-service sshd restart")
+}
+
+// ConfigureFail2ban installs fail2ban and sets up its jails
+func (a *AlpineOperations) ConfigureFail2ban(ctx context.Context, policy pkg.Fail2banPolicy) error {
+	return configureFail2ban(ctx, a.server, policy, alpineFail2ban)
+}
+
+// ConfigureDocker writes the Docker daemon configuration and restarts Docker
+// when it changed
+func (a *AlpineOperations) ConfigureDocker(ctx context.Context, daemon pkg.DockerDaemon) error {
+	return configureDockerDaemon(ctx, a.server, daemon, "rc-service docker restart")
+}
+
+// RebootRequired reports whether installed updates need a reboot. apk
+// removes the modules of the kernel it replaces, so a running kernel without
+// modules has been upgraded.
+func (a *AlpineOperations) RebootRequired(ctx context.Context) (bool, error) {
+	result, err := a.server.Execute(ctx, `sh -c 'test -d "/lib/modules/$(uname -r)"'`, false)
+	if err != nil {
+		return false, fmt.Errorf("failed to check for a pending reboot: %w", err)
+	}
+	return result.ExitCode != 0, nil
+}
+
+// InstallPackage installs a package using apk
+func (a *AlpineOperations) InstallPackage(ctx context.Context, packageName string) error {
+	result, err := a.server.Execute(ctx, fmt.Sprintf("apk add %s", packageName), true)
+	if err != nil {
+		return fmt.Errorf("failed to install package %s: %w", packageName, err)
+	}
+	if result.ExitCode != 0 {
+		return fmt.Errorf("package installation failed: %s", result.Stderr)
+	}
+	return nil
+}
+
+// GetDockerStatus returns the status of Docker service, reported like
+// systemctl is-active
+func (a *AlpineOperations) GetDockerStatus(ctx context.Context) (string, error) {
+	result, err := a.server.Execute(ctx, "rc-service docker status", false)
+	if err != nil {
+		return "unknown", err
+	}
+
+	// rc-service prints " * status: started" and exits non-zero otherwise
+	status := "inactive"
+	if result.ExitCode == 0 && strings.Contains(result.Stdout, "started") {
+		status = "active"
+	}
+
+	return status, nil
+}
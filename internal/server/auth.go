@@ -0,0 +1,175 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+
+	"github.com/jonas-jonas/mah/internal/config"
+)
+
+// passphraseCache remembers passphrases entered interactively, so a key
+// shared by several servers is only prompted for once per invocation
+var passphraseCache = struct {
+	sync.Mutex
+	byPath map[string][]byte
+}{byPath: make(map[string][]byte)}
+
+// authMethods builds the SSH authentication methods for a server.
+//
+// Keys held by ssh-agent (SSH_AUTH_SOCK) are offered first. If ssh_key is set
+// and the agent already holds it (matched through the .pub file next to it),
+// the key file is never decrypted. Otherwise the key file is loaded, using
+// ssh_key_passphrase or an interactive prompt for encrypted keys.
+func authMethods(cfg *config.Server) ([]ssh.AuthMethod, func(), error) {
+	agentClient, agentConn := dialAgent()
+	cleanup := func() {
+		if agentConn != nil {
+			agentConn.Close()
+		}
+	}
+
+	var signers []ssh.Signer
+
+	if agentClient != nil {
+		agentSigners, err := agentClient.Signers()
+		if err == nil {
+			signers = agentSigners
+		}
+	}
+
+	if cfg.SSHKey != "" {
+		keyPath, err := expandHome(cfg.SSHKey)
+		if err != nil {
+			cleanup()
+			return nil, nil, err
+		}
+
+		if agentSigner := matchAgentSigner(keyPath, signers); agentSigner != nil {
+			// Only offer the configured key to avoid MaxAuthTries failures
+			signers = []ssh.Signer{agentSigner}
+		} else {
+			signer, err := loadKeyFile(keyPath, cfg.SSHKeyPassphrase)
+			if err != nil {
+				cleanup()
+				return nil, nil, err
+			}
+			signers = append([]ssh.Signer{signer}, signers...)
+		}
+	}
+
+	if len(signers) == 0 {
+		cleanup()
+		return nil, nil, fmt.Errorf("no SSH credentials available: set ssh_key or load a key into ssh-agent (SSH_AUTH_SOCK)")
+	}
+
+	return []ssh.AuthMethod{ssh.PublicKeys(signers...)}, cleanup, nil
+}
+
+// dialAgent connects to the running ssh-agent, if any
+func dialAgent() (agent.ExtendedAgent, net.Conn) {
+	socket := os.Getenv("SSH_AUTH_SOCK")
+	if socket == "" {
+		return nil, nil
+	}
+
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return nil, nil
+	}
+
+	return agent.NewClient(conn), conn
+}
+
+// matchAgentSigner returns the agent signer for the public key stored next
+// to a private key file
+func matchAgentSigner(keyPath string, signers []ssh.Signer) ssh.Signer {
+	if len(signers) == 0 {
+		return nil
+	}
+
+	data, err := os.ReadFile(keyPath + ".pub")
+	if err != nil {
+		return nil
+	}
+
+	publicKey, _, _, _, err := ssh.ParseAuthorizedKey(data)
+	if err != nil {
+		return nil
+	}
+
+	for _, signer := range signers {
+		if bytes.Equal(signer.PublicKey().Marshal(), publicKey.Marshal()) {
+			return signer
+		}
+	}
+	return nil
+}
+
+// loadKeyFile parses a private key, decrypting it when needed
+func loadKeyFile(keyPath, passphrase string) (ssh.Signer, error) {
+	privateKey, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read SSH private key from %s: %w", keyPath, err)
+	}
+
+	signer, err := ssh.ParsePrivateKey(privateKey)
+	if err == nil {
+		return signer, nil
+	}
+
+	var missing *ssh.PassphraseMissingError
+	if !errors.As(err, &missing) {
+		return nil, fmt.Errorf("failed to parse SSH private key at %s: %w", keyPath, err)
+	}
+
+	// Passphrase from mah.yaml, usually resolved from the secrets store
+	if passphrase != "" && !strings.HasPrefix(passphrase, "${") {
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(privateKey, []byte(passphrase))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt SSH private key at %s with ssh_key_passphrase: %w", keyPath, err)
+		}
+		return signer, nil
+	}
+
+	passphraseCache.Lock()
+	defer passphraseCache.Unlock()
+
+	if cached, ok := passphraseCache.byPath[keyPath]; ok {
+		return ssh.ParsePrivateKeyWithPassphrase(privateKey, cached)
+	}
+
+	entered, err := config.ReadPassword(fmt.Sprintf("Enter passphrase for key '%s': ", keyPath))
+	if err != nil {
+		return nil, fmt.Errorf("SSH private key %s is encrypted; set ssh_key_passphrase or use ssh-agent: %w", keyPath, err)
+	}
+
+	signer, err = ssh.ParsePrivateKeyWithPassphrase(privateKey, entered)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt SSH private key at %s: %w", keyPath, err)
+	}
+
+	passphraseCache.byPath[keyPath] = entered
+	return signer, nil
+}
+
+// expandHome expands a leading ~/ in a path
+func expandHome(path string) (string, error) {
+	if !strings.HasPrefix(path, "~/") {
+		return path, nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get home directory: %w", err)
+	}
+	return filepath.Join(home, path[2:]), nil
+}
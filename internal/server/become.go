@@ -0,0 +1,255 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/jonas-jonas/mah/internal/config"
+	"github.com/jonas-jonas/mah/pkg"
+)
+
+// runFunc runs a command without privilege escalation
+type runFunc func(ctx context.Context, cmd string, opts pkg.StreamOptions) (*pkg.Result, error)
+
+// becomeStream runs cmd with the server's privilege escalation method.
+//
+// Without a password, sudo and doas run non-interactively (-n). With a
+// password, sudo reads it from stdin (-S) behind a unique prompt, so the
+// password never appears in the process list. The command first prints a
+// marker; stdin is only forwarded once the marker shows that sudo is done.
+func becomeStream(ctx context.Context, cfg *config.Server, cmd string, opts pkg.StreamOptions, run runFunc) (*pkg.Result, error) {
+	password := cfg.BecomePassword()
+	if password == "" || cfg.BecomeMethod() != config.BecomeSudo {
+		return run(ctx, becomeCommand(cfg, cmd), opts)
+	}
+
+	token, err := becomeToken()
+	if err != nil {
+		return nil, err
+	}
+	marker := "mah-become-" + token
+	prompt := fmt.Sprintf("[%s] password:", marker)
+
+	wrapped := fmt.Sprintf("sudo -S -p %s%s sh -c %s",
+		ShellQuote(prompt), becomeUserFlag(cfg), ShellQuote(fmt.Sprintf("echo %s; %s", marker, cmd)))
+
+	stdinReader, stdinWriter := io.Pipe()
+	defer stdinWriter.Close()
+
+	b := &becomeSession{
+		password: password,
+		stdin:    stdinWriter,
+		input:    opts.Stdin,
+		started:  make(chan struct{}),
+	}
+
+	stdout := &markerWriter{marker: []byte(marker + "\n"), out: opts.Stdout, onMarker: b.start}
+	stderr := &promptWriter{prompt: []byte(prompt), out: opts.Stderr, onPrompt: b.sendPassword, done: b.started}
+
+	runOpts := opts
+	runOpts.Stdin = stdinReader
+	runOpts.Stdout = stdout
+	runOpts.Stderr = stderr
+
+	result, err := run(ctx, wrapped, runOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	// Output held back while waiting for a prompt or the marker, like the
+	// error sudo prints when it fails
+	stdout.Flush()
+	stderr.Flush()
+	return result, nil
+}
+
+// becomeCommand wraps cmd for non-interactive privilege escalation
+func becomeCommand(cfg *config.Server, cmd string) string {
+	switch cfg.BecomeMethod() {
+	case config.BecomeDoas:
+		return fmt.Sprintf("doas -n%s %s", becomeUserFlag(cfg), cmd)
+	case config.BecomeNone:
+		return cmd
+	default:
+		return fmt.Sprintf("sudo -n%s %s", becomeUserFlag(cfg), cmd)
+	}
+}
+
+// interactiveBecomeCommand wraps cmd for privilege escalation on a terminal,
+// where the user answers any password prompt. An empty cmd starts a login
+// shell as the target user.
+func interactiveBecomeCommand(cfg *config.Server, cmd string) string {
+	method := cfg.BecomeMethod()
+	switch {
+	case method == config.BecomeNone:
+		return cmd
+	case method == config.BecomeDoas && cmd == "":
+		return fmt.Sprintf("doas%s -s", becomeUserFlag(cfg))
+	case method == config.BecomeDoas:
+		return fmt.Sprintf("doas%s %s", becomeUserFlag(cfg), cmd)
+	case cmd == "":
+		return fmt.Sprintf("sudo%s -i", becomeUserFlag(cfg))
+	default:
+		return fmt.Sprintf("sudo%s %s", becomeUserFlag(cfg), cmd)
+	}
+}
+
+// becomeUserFlag returns the -u flag for a configured target user
+func becomeUserFlag(cfg *config.Server) string {
+	if cfg.Become == nil || cfg.Become.User == "" {
+		return ""
+	}
+	return " -u " + ShellQuote(cfg.Become.User)
+}
+
+// becomeToken returns a random token that makes prompts and markers unique
+func becomeToken() (string, error) {
+	token := make([]byte, 8)
+	if _, err := rand.Read(token); err != nil {
+		return "", fmt.Errorf("failed to generate become token: %w", err)
+	}
+	return hex.EncodeToString(token), nil
+}
+
+// becomeSession feeds the password and the command's input to sudo's stdin
+type becomeSession struct {
+	password string
+	stdin    *io.PipeWriter
+	input    io.Reader
+
+	mu      sync.Mutex
+	prompts int
+	started chan struct{}
+	once    sync.Once
+}
+
+// sendPassword answers a password prompt. sudo prompts again after a wrong
+// password; stdin is closed then so sudo gives up instead of waiting.
+func (b *becomeSession) sendPassword() {
+	b.mu.Lock()
+	b.prompts++
+	first := b.prompts == 1
+	b.mu.Unlock()
+
+	if !first {
+		b.stdin.CloseWithError(fmt.Errorf("sudo rejected the become password"))
+		return
+	}
+
+	// Write without blocking the output stream the prompt arrived on
+	go io.WriteString(b.stdin, b.password+"\n")
+}
+
+// start forwards the command's input once sudo has run the command
+func (b *becomeSession) start() {
+	b.once.Do(func() {
+		close(b.started)
+		go func() {
+			if b.input != nil {
+				io.Copy(b.stdin, b.input)
+			}
+			b.stdin.Close()
+		}()
+	})
+}
+
+// markerWriter drops the line printed by the command before it runs and
+// passes everything after it through
+type markerWriter struct {
+	marker   []byte
+	out      io.Writer
+	onMarker func()
+
+	buf  []byte
+	seen bool
+}
+
+func (w *markerWriter) Write(p []byte) (int, error) {
+	if w.seen {
+		return writeTo(w.out, p)
+	}
+
+	w.buf = append(w.buf, p...)
+	i := bytes.Index(w.buf, w.marker)
+	if i < 0 {
+		return len(p), nil
+	}
+
+	w.seen = true
+	w.onMarker()
+	rest := append(w.buf[:i:i], w.buf[i+len(w.marker):]...)
+	w.buf = nil
+	if _, err := writeTo(w.out, rest); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Flush writes output held back because the marker never arrived
+func (w *markerWriter) Flush() {
+	if !w.seen {
+		writeTo(w.out, w.buf)
+		w.buf = nil
+	}
+}
+
+// promptWriter answers sudo's password prompts and removes them from the
+// output. Once the command runs its stderr passes through unchanged.
+type promptWriter struct {
+	prompt   []byte
+	out      io.Writer
+	onPrompt func()
+	done     <-chan struct{}
+
+	buf []byte
+}
+
+func (w *promptWriter) Write(p []byte) (int, error) {
+	select {
+	case <-w.done:
+		if len(w.buf) > 0 {
+			writeTo(w.out, w.buf)
+			w.buf = nil
+		}
+		return writeTo(w.out, p)
+	default:
+	}
+
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.Index(w.buf, w.prompt)
+		if i < 0 {
+			break
+		}
+		writeTo(w.out, w.buf[:i])
+		w.buf = w.buf[i+len(w.prompt):]
+		w.onPrompt()
+	}
+
+	// Keep a possible partial prompt for the next write
+	keep := len(w.prompt) - 1
+	if len(w.buf) > keep {
+		writeTo(w.out, w.buf[:len(w.buf)-keep])
+		w.buf = append([]byte(nil), w.buf[len(w.buf)-keep:]...)
+	}
+	return len(p), nil
+}
+
+// Flush writes output held back as a possible partial prompt
+func (w *promptWriter) Flush() {
+	writeTo(w.out, w.buf)
+	w.buf = nil
+}
+
+// writeTo writes p to out, discarding it when out is nil
+func writeTo(out io.Writer, p []byte) (int, error) {
+	if out == nil || len(p) == 0 {
+		return len(p), nil
+	}
+	return out.Write(p)
+}
@@ -0,0 +1,174 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/jonas-jonas/mah/internal/config"
+	"github.com/jonas-jonas/mah/internal/server/sshtest"
+	"github.com/jonas-jonas/mah/pkg"
+)
+
+var sudoPattern = regexp.MustCompile(`^sudo -S -p '([^']*)'(?: -u '([^']*)')? sh -c '(echo (\S+); (.*))'$`)
+
+// fakeSudo emulates "sudo -S -p prompt sh -c 'echo marker; cmd'". It asks
+// for password unless it is empty, then runs cmd, which may be "cat" or
+// "whoami".
+func fakeSudo(t *testing.T, password string) sshtest.Handler {
+	return func(ctx context.Context, cmd string, stdin io.Reader, stdout, stderr io.Writer) int {
+		m := sudoPattern.FindStringSubmatch(cmd)
+		if m == nil {
+			t.Errorf("unexpected sudo command %q", cmd)
+			return 1
+		}
+		prompt, user, marker, inner := m[1], m[2], m[4], m[5]
+		in := bufio.NewReader(stdin)
+
+		if password != "" {
+			for attempt := 0; ; attempt++ {
+				io.WriteString(stderr, prompt)
+				line, err := in.ReadString('\n')
+				if err != nil {
+					fmt.Fprintf(stderr, "sudo: %d incorrect password attempt\n", attempt)
+					return 1
+				}
+				if strings.TrimSuffix(line, "\n") == password {
+					break
+				}
+				io.WriteString(stderr, "Sorry, try again.\n")
+			}
+		}
+
+		fmt.Fprintln(stdout, marker)
+		switch inner {
+		case "cat":
+			io.Copy(stdout, in)
+		case "whoami":
+			if user == "" {
+				user = "root"
+			}
+			fmt.Fprintln(stdout, user)
+		}
+		return 0
+	}
+}
+
+func TestBecomePassword(t *testing.T) {
+	tests := []struct {
+		name         string
+		password     string
+		sudoPassword string
+		user         string
+		cmd          string
+		stdin        string
+		wantStdout   string
+		wantStderr   string
+		wantCode     int
+	}{
+		{
+			name:         "password then stdin",
+			password:     "s3cret",
+			sudoPassword: "s3cret",
+			cmd:          "cat",
+			stdin:        "first line\nsecond line\n",
+			wantStdout:   "first line\nsecond line\n",
+		},
+		{
+			name:         "target user",
+			password:     "s3cret",
+			sudoPassword: "s3cret",
+			user:         "postgres",
+			cmd:          "whoami",
+			wantStdout:   "postgres\n",
+		},
+		{
+			name:       "no prompt",
+			password:   "s3cret",
+			cmd:        "cat",
+			stdin:      "input\n",
+			wantStdout: "input\n",
+		},
+		{
+			name:         "wrong password",
+			password:     "wrong",
+			sudoPassword: "s3cret",
+			cmd:          "whoami",
+			wantStderr:   "Sorry, try again.\nsudo: 1 incorrect password attempt\n",
+			wantCode:     1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, cfg := newTestServer(t)
+			cfg.Become = &config.BecomeConfig{Password: tt.password, User: tt.user}
+			srv.HandlePrefix("sudo -S ", fakeSudo(t, tt.sudoPassword))
+
+			var stdout, stderr strings.Builder
+			opts := pkg.StreamOptions{Sudo: true, Stdout: &stdout, Stderr: &stderr}
+			if tt.stdin != "" {
+				opts.Stdin = strings.NewReader(tt.stdin)
+			}
+
+			result, err := connect(t, cfg).Stream(context.Background(), tt.cmd, opts)
+			if err != nil {
+				t.Fatalf("Stream() error = %v", err)
+			}
+
+			if result.ExitCode != tt.wantCode {
+				t.Errorf("ExitCode = %d, want %d", result.ExitCode, tt.wantCode)
+			}
+			if stdout.String() != tt.wantStdout {
+				t.Errorf("stdout = %q, want %q", stdout.String(), tt.wantStdout)
+			}
+			if stderr.String() != tt.wantStderr {
+				t.Errorf("stderr = %q, want %q", stderr.String(), tt.wantStderr)
+			}
+			for _, cmd := range srv.Commands() {
+				if strings.Contains(cmd, tt.password) {
+					t.Errorf("password visible in command line %q", cmd)
+				}
+			}
+		})
+	}
+}
+
+func TestBecomeCommand(t *testing.T) {
+	tests := []struct {
+		name   string
+		sudo   bool
+		become *config.BecomeConfig
+		want   string
+	}{
+		{name: "sudo flag", sudo: true, want: "sudo -n whoami"},
+		{name: "no escalation", want: "whoami"},
+		{name: "become defaults to sudo", become: &config.BecomeConfig{}, want: "sudo -n whoami"},
+		{name: "become overrides sudo flag", sudo: true, become: &config.BecomeConfig{Method: "none"}, want: "whoami"},
+		{name: "doas", become: &config.BecomeConfig{Method: "doas"}, want: "doas -n whoami"},
+		{name: "target user", become: &config.BecomeConfig{User: "postgres"}, want: "sudo -n -u 'postgres' whoami"},
+		{name: "doas target user", become: &config.BecomeConfig{Method: "doas", User: "www"}, want: "doas -n -u 'www' whoami"},
+		{name: "unresolved password", become: &config.BecomeConfig{Password: "${SUDO_PASSWORD}"}, want: "sudo -n whoami"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, cfg := newTestServer(t)
+			cfg.Sudo = tt.sudo
+			cfg.Become = tt.become
+			srv.HandleDefault(sshtest.Reply("", 0))
+
+			if _, err := connect(t, cfg).Execute(context.Background(), "whoami", true); err != nil {
+				t.Fatalf("Execute() error = %v", err)
+			}
+
+			if got := srv.Commands(); len(got) != 1 || got[0] != tt.want {
+				t.Errorf("commands = %q, want [%q]", got, tt.want)
+			}
+		})
+	}
+}
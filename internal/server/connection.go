@@ -0,0 +1,257 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// defaultMaxSessions stays below OpenSSH's default MaxSessions of 10
+	defaultMaxSessions = 8
+
+	keepaliveInterval = 15 * time.Second
+	keepaliveTimeout  = 10 * time.Second
+
+	// terminateGrace is how long a cancelled command gets to exit after
+	// SIGTERM before it is killed
+	terminateGrace = 5 * time.Second
+)
+
+// newSession opens a session on the shared connection, waiting for a free
+// session slot and reconnecting once if the connection has dropped. The
+// returned release func must be called after the session is closed.
+func (s *SSHServer) newSession(ctx context.Context) (*ssh.Session, func(), error) {
+	release, err := s.acquireSlot(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	client, err := s.liveClient(ctx)
+	if err != nil {
+		release()
+		return nil, nil, err
+	}
+
+	session, err := client.NewSession()
+	if err != nil {
+		// The connection is most likely gone; reconnect and try once more
+		s.markBroken(client)
+
+		client, err = s.liveClient(ctx)
+		if err != nil {
+			release()
+			return nil, nil, err
+		}
+
+		session, err = client.NewSession()
+		if err != nil {
+			release()
+			return nil, nil, fmt.Errorf("failed to create SSH session: %w", err)
+		}
+	}
+
+	return session, release, nil
+}
+
+// newSFTPClient opens an SFTP subsystem on the shared connection
+func (s *SSHServer) newSFTPClient(ctx context.Context) (*sftp.Client, func(), error) {
+	release, err := s.acquireSlot(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	client, err := s.liveClient(ctx)
+	if err != nil {
+		release()
+		return nil, nil, err
+	}
+
+	sftpClient, err := sftp.NewClient(client)
+	if err != nil {
+		s.markBroken(client)
+
+		client, err = s.liveClient(ctx)
+		if err != nil {
+			release()
+			return nil, nil, err
+		}
+
+		sftpClient, err = sftp.NewClient(client)
+		if err != nil {
+			release()
+			return nil, nil, fmt.Errorf("failed to create SFTP client: %w", err)
+		}
+	}
+
+	return sftpClient, release, nil
+}
+
+// terminateSession ends a running command, escalating from SIGTERM to
+// SIGKILL. Closing the session afterwards closes the command's pipes, so
+// commands on servers that ignore signal requests die on their next write.
+func terminateSession(session *ssh.Session, done <-chan error) {
+	session.Signal(ssh.SIGTERM)
+
+	select {
+	case <-done:
+		return
+	case <-time.After(terminateGrace):
+	}
+
+	session.Signal(ssh.SIGKILL)
+	session.Close()
+}
+
+// acquireSlot waits for a free session slot
+func (s *SSHServer) acquireSlot(ctx context.Context) (func(), error) {
+	select {
+	case s.sessions <- struct{}{}:
+		return func() { <-s.sessions }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// liveClient returns the current SSH client, reconnecting if the connection
+// was lost since Connect
+func (s *SSHServer) liveClient(ctx context.Context) (*ssh.Client, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.connected {
+		return nil, fmt.Errorf("not connected to server")
+	}
+
+	if s.client == nil {
+		if err := s.dial(ctx); err != nil {
+			return nil, fmt.Errorf("failed to reconnect to %s: %w", s.config.Host, err)
+		}
+	}
+
+	return s.client, nil
+}
+
+// Dial opens a connection from the server to addr, tunnelled over SSH. It
+// backs jump hosts and local port forwards.
+func (s *SSHServer) Dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	client, err := s.liveClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := client.DialContext(ctx, network, addr)
+	if err != nil {
+		// A refused or unreachable target says nothing about the connection,
+		// which other forwards and sessions may still be using
+		var openErr *ssh.OpenChannelError
+		if errors.As(err, &openErr) || ctx.Err() != nil {
+			return nil, err
+		}
+		s.markBroken(client)
+
+		client, err = s.liveClient(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return client.DialContext(ctx, network, addr)
+	}
+	return conn, nil
+}
+
+// Listen opens a listener on the server whose connections are tunnelled
+// back over SSH, used for reverse port forwards
+func (s *SSHServer) Listen(ctx context.Context, network, addr string) (net.Listener, error) {
+	client, err := s.liveClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	listener, err := client.Listen(network, addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s on the server: %w", addr, err)
+	}
+	return listener, nil
+}
+
+// isConnected reports whether the server currently holds a connection
+func (s *SSHServer) isConnected() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.connected && s.client != nil
+}
+
+// markBroken drops a connection that stopped responding, so the next
+// session reconnects
+func (s *SSHServer) markBroken(client *ssh.Client) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.client == client {
+		s.closeConnection()
+	}
+}
+
+// closeConnection tears down the current connection; the caller must hold s.mu
+func (s *SSHServer) closeConnection() error {
+	var err error
+
+	if s.stop != nil {
+		close(s.stop)
+		s.stop = nil
+	}
+	if s.client != nil {
+		err = s.client.Close()
+		s.client = nil
+	}
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+	}
+	if s.jump != nil {
+		releaseJump(s.jump)
+		s.jump = nil
+	}
+
+	return err
+}
+
+// keepalive periodically checks that the connection is still responsive and
+// drops it when it is not
+func (s *SSHServer) keepalive(client *ssh.Client, stop chan struct{}) {
+	ticker := time.NewTicker(keepaliveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		reply := make(chan error, 1)
+		go func() {
+			_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+			reply <- err
+		}()
+
+		select {
+		case <-stop:
+			return
+		case err := <-reply:
+			if err == nil {
+				continue
+			}
+		case <-time.After(keepaliveTimeout):
+		}
+
+		s.markBroken(client)
+		return
+	}
+}
@@ -0,0 +1,163 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/jonas-jonas/mah/pkg"
+)
+
+const (
+	dockerDaemonJSON = "/etc/docker/daemon.json"
+
+	// dockerDaemonStaged is where a new daemon.json is validated before it
+	// replaces the current one
+	dockerDaemonStaged = dockerDaemonJSON + ".mah-new"
+)
+
+// daemonJSONKeys are the daemon.json keys managed by mah; any other key in
+// the file is left as it is
+var daemonJSONKeys = []string{"log-driver", "log-opts", "registry-mirrors", "default-address-pools", "live-restore", "userns-remap"}
+
+// daemonJSON holds the keys of daemon.json managed by mah
+type daemonJSON struct {
+	LogDriver           string            `json:"log-driver"`
+	LogOpts             map[string]string `json:"log-opts,omitempty"`
+	RegistryMirrors     []string          `json:"registry-mirrors,omitempty"`
+	DefaultAddressPools []pkg.AddressPool `json:"default-address-pools,omitempty"`
+	LiveRestore         bool              `json:"live-restore,omitempty"`
+	UsernsRemap         string            `json:"userns-remap,omitempty"`
+}
+
+// configureDockerDaemon sets the keys of /etc/docker/daemon.json managed by
+// mah from daemon, keeping the rest, and restarts Docker with restartCmd.
+// Nothing is restarted when the file is already current. A file dockerd rejects is never put in place, and the
+// previous file is restored when Docker fails to restart with the new one.
+func configureDockerDaemon(ctx context.Context, server pkg.Server, daemon pkg.DockerDaemon, restartCmd string) error {
+	result, err := server.Execute(ctx, "docker --version", false)
+	if err != nil || result.ExitCode != 0 {
+		return fmt.Errorf("Docker is not installed")
+	}
+
+	// Check what is already in place
+	var previous *string
+	result, err = server.Execute(ctx, "cat "+dockerDaemonJSON, false)
+	if err == nil && result.ExitCode == 0 {
+		current := result.Stdout
+		previous = &current
+	}
+
+	current := ""
+	if previous != nil {
+		current = *previous
+	}
+	content, err := dockerDaemonContent(daemon, current)
+	if err != nil {
+		return err
+	}
+	if content == current {
+		return nil
+	}
+
+	if err := runScript(ctx, server, "mkdir -p /etc/docker"); err != nil {
+		return fmt.Errorf("failed to create /etc/docker: %w", err)
+	}
+	if err := WriteFile(ctx, server, dockerDaemonStaged, content); err != nil {
+		return err
+	}
+
+	result, err = server.Execute(ctx, "dockerd --validate --config-file "+dockerDaemonStaged, true)
+	if err != nil || result.ExitCode != 0 {
+		cause := fmt.Errorf("Docker daemon configuration rejected: %s", errorDetail(result, err))
+		if err := runScript(ctx, server, "rm -f "+dockerDaemonStaged); err != nil {
+			return fmt.Errorf("%w (removing %s also failed: %v)", cause, dockerDaemonStaged, err)
+		}
+		return cause
+	}
+
+	if err := runScript(ctx, server, fmt.Sprintf("mv -f %s %s", dockerDaemonStaged, dockerDaemonJSON)); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", dockerDaemonJSON, err)
+	}
+
+	// Running containers are stopped unless live-restore was already on
+	result, err = server.Execute(ctx, restartCmd, true)
+	if err == nil && result.ExitCode == 0 {
+		return nil
+	}
+	cause := fmt.Errorf("Docker restart failed: %s", errorDetail(result, err))
+
+	// Put the previous configuration back and bring Docker up with it
+	if previous != nil {
+		err = WriteFile(ctx, server, dockerDaemonJSON, *previous)
+	} else {
+		err = runScript(ctx, server, "rm -f "+dockerDaemonJSON)
+	}
+	if err != nil {
+		return fmt.Errorf("%w (restoring %s also failed: %v)", cause, dockerDaemonJSON, err)
+	}
+	result, err = server.Execute(ctx, restartCmd, true)
+	if err != nil || result.ExitCode != 0 {
+		return fmt.Errorf("%w (restored the previous daemon.json, but Docker still failed to restart: %s)", cause, errorDetail(result, err))
+	}
+	return fmt.Errorf("%w (restored the previous daemon.json)", cause)
+}
+
+// dockerDaemonContent renders daemon as daemon.json, merged into the
+// current file
+func dockerDaemonContent(daemon pkg.DockerDaemon, current string) (string, error) {
+	out := daemonJSON{
+		LogDriver:           daemon.LogDriver,
+		RegistryMirrors:     daemon.RegistryMirrors,
+		DefaultAddressPools: daemon.DefaultAddressPools,
+		LiveRestore:         daemon.LiveRestore,
+		UsernsRemap:         daemon.UsernsRemap,
+	}
+	if out.LogDriver == "" {
+		out.LogDriver = "json-file"
+	}
+
+	// Only the file based drivers rotate, and json-file keeps everything
+	// unless told otherwise
+	if out.LogDriver == "json-file" || out.LogDriver == "local" {
+		maxSize := daemon.LogMaxSize
+		if maxSize == "" {
+			maxSize = "10m"
+		}
+		maxFile := daemon.LogMaxFile
+		if maxFile == 0 {
+			maxFile = 3
+		}
+		out.LogOpts = map[string]string{"max-size": maxSize, "max-file": strconv.Itoa(maxFile)}
+	}
+
+	keys := make(map[string]json.RawMessage)
+	if strings.TrimSpace(current) != "" {
+		if err := json.Unmarshal([]byte(current), &keys); err != nil {
+			return "", fmt.Errorf("failed to parse %s: %w", dockerDaemonJSON, err)
+		}
+	}
+
+	data, err := json.Marshal(out)
+	if err != nil {
+		return "", fmt.Errorf("failed to render daemon.json: %w", err)
+	}
+	managed := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(data, &managed); err != nil {
+		return "", fmt.Errorf("failed to render daemon.json: %w", err)
+	}
+	for _, key := range daemonJSONKeys {
+		delete(keys, key)
+		if value, ok := managed[key]; ok {
+			keys[key] = value
+		}
+	}
+
+	data, err = json.MarshalIndent(keys, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to render daemon.json: %w", err)
+	}
+	return string(data) + "\n", nil
+}
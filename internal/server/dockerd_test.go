@@ -0,0 +1,155 @@
+package server
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/jonas-jonas/mah/pkg"
+	"github.com/jonas-jonas/mah/pkg/mahtest"
+)
+
+func TestDockerDaemonContent(t *testing.T) {
+	tests := []struct {
+		name    string
+		daemon  pkg.DockerDaemon
+		current string
+		want    string
+	}{
+		{
+			name: "defaults",
+			want: `{
+  "log-driver": "json-file",
+  "log-opts": {
+    "max-file": "3",
+    "max-size": "10m"
+  }
+}
+`,
+		},
+		{
+			name:   "journald does not rotate",
+			daemon: pkg.DockerDaemon{LogDriver: "journald", UsernsRemap: "default"},
+			want: `{
+  "log-driver": "journald",
+  "userns-remap": "default"
+}
+`,
+		},
+		{
+			name:    "keeps unmanaged keys",
+			daemon:  pkg.DockerDaemon{LogDriver: "journald"},
+			current: `{"data-root": "/srv/docker", "log-opts": {"max-size": "50m"}, "insecure-registries": ["10.0.0.5:5000"]}`,
+			want: `{
+  "data-root": "/srv/docker",
+  "insecure-registries": [
+    "10.0.0.5:5000"
+  ],
+  "log-driver": "journald"
+}
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := dockerDaemonContent(tt.daemon, tt.current)
+			if err != nil {
+				t.Fatalf("dockerDaemonContent() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("dockerDaemonContent() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfigureDockerDaemonSkipsCurrentConfig(t *testing.T) {
+	content, _ := dockerDaemonContent(testDockerDaemon, "")
+	srv := mahtest.NewServer("web-1").On("cat "+dockerDaemonJSON, mahtest.Response{Stdout: content})
+
+	if err := configureDockerDaemon(context.Background(), srv, testDockerDaemon, "systemctl restart docker"); err != nil {
+		t.Fatalf("configureDockerDaemon() error = %v", err)
+	}
+
+	for _, call := range srv.Calls() {
+		if call.Sudo {
+			t.Errorf("ran %q with sudo, want no changes", call.Cmd)
+		}
+	}
+}
+
+func TestConfigureDockerDaemonKeepsUnmanagedKeys(t *testing.T) {
+	srv := mahtest.NewServer("web-1").
+		On("cat "+dockerDaemonJSON, mahtest.Response{Stdout: "{\"data-root\": \"/srv/docker\", \"log-driver\": \"journald\"}\n"})
+
+	if err := configureDockerDaemon(context.Background(), srv, testDockerDaemon, "systemctl restart docker"); err != nil {
+		t.Fatalf("configureDockerDaemon() error = %v", err)
+	}
+
+	staged := ""
+	for _, call := range srv.Calls() {
+		if call.Op == mahtest.OpStream && call.Cmd == "tee '"+dockerDaemonStaged+"' > /dev/null" {
+			staged = string(call.Stdin)
+		}
+	}
+	if !strings.Contains(staged, `"data-root": "/srv/docker"`) {
+		t.Errorf("staged daemon.json dropped data-root:\n%s", staged)
+	}
+	if !strings.Contains(staged, `"log-driver": "json-file"`) {
+		t.Errorf("staged daemon.json kept the old log driver:\n%s", staged)
+	}
+}
+
+func TestConfigureDockerDaemonRejectedConfig(t *testing.T) {
+	srv := mahtest.NewServer("web-1").
+		OnPrefix("dockerd --validate", mahtest.Response{Stderr: "unable to configure the Docker daemon with file /etc/docker/daemon.json.mah-new\n", ExitCode: 1})
+
+	err := configureDockerDaemon(context.Background(), srv, testDockerDaemon, "systemctl restart docker")
+	if err == nil || !strings.Contains(err.Error(), "rejected") {
+		t.Fatalf("configureDockerDaemon() error = %v, want rejected config", err)
+	}
+
+	removed := false
+	for _, cmd := range srv.Commands() {
+		switch {
+		case strings.Contains(cmd, "mv -f"), strings.Contains(cmd, "restart docker"):
+			t.Errorf("ran %q with a rejected config", cmd)
+		case cmd == "sudo sh -c 'rm -f "+dockerDaemonStaged+"'":
+			removed = true
+		}
+	}
+	if !removed {
+		t.Error("staged config not removed")
+	}
+}
+
+func TestConfigureDockerDaemonRestoresOnFailedRestart(t *testing.T) {
+	previous := "{\"log-driver\": \"json-file\"}\n"
+	restarts := 0
+	srv := mahtest.NewServer("web-1").
+		On("cat "+dockerDaemonJSON, mahtest.Response{Stdout: previous}).
+		On("systemctl restart docker", mahtest.Response{Stderr: "Job for docker.service failed\n", ExitCode: 1})
+
+	err := configureDockerDaemon(context.Background(), srv, testDockerDaemon, "systemctl restart docker")
+	if err == nil || !strings.Contains(err.Error(), "restored the previous daemon.json") {
+		t.Fatalf("configureDockerDaemon() error = %v, want restore", err)
+	}
+
+	// The last write to daemon.json is the restore
+	restored := ""
+	for _, call := range srv.Calls() {
+		if call.Op == mahtest.OpStream && call.Cmd == "tee '"+dockerDaemonJSON+"' > /dev/null" {
+			restored = string(call.Stdin)
+		}
+		if call.Cmd == "systemctl restart docker" {
+			restarts++
+		}
+	}
+	if restored != previous {
+		t.Errorf("daemon.json restored as %q, want %q", restored, previous)
+	}
+	if restarts != 2 {
+		t.Errorf("restarted Docker %d times, want 2", restarts)
+	}
+}
@@ -23,6 +23,11 @@ func (f *ServerFactory) CreateServer(id string, config *config.Server) (pkg.Serv
 		return nil, fmt.Errorf("server configuration is nil")
 	}
 
+	// Local servers run commands directly on this machine
+	if config.IsLocal() {
+		return NewLocalServer(id, config), nil
+	}
+
 	if config.Host == "" {
 		return nil, fmt.Errorf("server host is required")
 	}
@@ -31,10 +36,6 @@ func (f *ServerFactory) CreateServer(id string, config *config.Server) (pkg.Serv
 		return nil, fmt.Errorf("SSH user is required")
 	}
 
-	if config.SSHKey == "" {
-		return nil, fmt.Errorf("SSH key is required")
-	}
-
 	// Create base SSH server
 	server := NewSSHServer(id, config)
 	
@@ -73,16 +74,18 @@ func (f *ServerFactory) ValidateServerConfig(config *config.Server) error {
 		return fmt.Errorf("server configuration is nil")
 	}
 
-	if config.Host == "" {
-		return fmt.Errorf("host is required")
+	switch strings.ToLower(config.Transport) {
+	case "", "ssh", "local":
+	default:
+		return fmt.Errorf("invalid transport: %s (supported: ssh, local)", config.Transport)
 	}
 
-	if config.SSHUser == "" {
-		return fmt.Errorf("ssh_user is required")
+	if config.Host == "" && !config.IsLocal() {
+		return fmt.Errorf("host is required")
 	}
 
-	if config.SSHKey == "" {
-		return fmt.Errorf("ssh_key is required")
+	if config.SSHUser == "" && !config.IsLocal() {
+		return fmt.Errorf("ssh_user is required")
 	}
 
 	// Validate distribution if specified
@@ -110,6 +113,14 @@ func (f *ServerFactory) ValidateServerConfig(config *config.Server) error {
 		return fmt.Errorf("invalid SSH port: %d (must be 1-65535 or 0 for default)", config.SSHPort)
 	}
 
+	// Validate host key checking mode
+	switch strings.ToLower(config.HostKeyCheck) {
+	case "", HostKeyCheckTOFU, HostKeyCheckStrict:
+	default:
+		return fmt.Errorf("invalid host_key_check: %s (supported: %s, %s)",
+			config.HostKeyCheck, HostKeyCheckTOFU, HostKeyCheckStrict)
+	}
+
 	return nil
 }
 
@@ -133,6 +144,7 @@ func (f *ServerFactory) GetDistroInfo(distro string) (*DistroInfo, error) {
 	switch distro {
 	case "ubuntu":
 		return &DistroInfo{
+			ID:             distro,
 			Name:           "Ubuntu",
 			Family:         "debian",
 			PackageManager: "apt",
@@ -142,6 +154,7 @@ func (f *ServerFactory) GetDistroInfo(distro string) (*DistroInfo, error) {
 		}, nil
 	case "debian":
 		return &DistroInfo{
+			ID:             distro,
 			Name:           "Debian",
 			Family:         "debian",
 			PackageManager: "apt",
@@ -151,15 +164,17 @@ func (f *ServerFactory) GetDistroInfo(distro string) (*DistroInfo, error) {
 		}, nil
 	case "centos", "rhel":
 		return &DistroInfo{
+			ID:             distro,
 			Name:           "CentOS/RHEL",
 			Family:         "rhel",
-			PackageManager: "yum",
+			PackageManager: "dnf",
 			FirewallTool:   "firewalld",
 			ServiceManager: "systemctl",
 			InitSystem:     "systemd",
 		}, nil
 	case "rocky":
 		return &DistroInfo{
+			ID:             distro,
 			Name:           "Rocky Linux",
 			Family:         "rhel",
 			PackageManager: "dnf",
@@ -169,6 +184,7 @@ func (f *ServerFactory) GetDistroInfo(distro string) (*DistroInfo, error) {
 		}, nil
 	case "fedora":
 		return &DistroInfo{
+			ID:             distro,
 			Name:           "Fedora",
 			Family:         "rhel",
 			PackageManager: "dnf",
@@ -178,6 +194,7 @@ func (f *ServerFactory) GetDistroInfo(distro string) (*DistroInfo, error) {
 		}, nil
 	case "alpine":
 		return &DistroInfo{
+			ID:             distro,
 			Name:           "Alpine Linux",
 			Family:         "alpine",
 			PackageManager: "apk",
@@ -192,6 +209,7 @@ func (f *ServerFactory) GetDistroInfo(distro string) (*DistroInfo, error) {
 
 // DistroInfo contains information about a Linux distribution
 type DistroInfo struct {
+	ID             string // lowercase distribution name, e.g. "ubuntu"
 	Name           string
 	Family         string
 	PackageManager string
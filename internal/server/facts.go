@@ -0,0 +1,483 @@
+package server
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jonas-jonas/mah/pkg"
+)
+
+// factsProbe gathers all facts in one round trip. It reads /proc and sysfs
+// directly and prints each source after a "@@mah:<section>" line. Only df,
+// ip and docker are external commands, all run with the C locale.
+const factsProbe = `export LC_ALL=C
+s() { echo "@@mah:$1"; }
+s hostname; cat /proc/sys/kernel/hostname
+s kernel; cat /proc/sys/kernel/osrelease
+s arch; uname -m
+s uptime; cat /proc/uptime
+s loadavg; cat /proc/loadavg
+s meminfo; cat /proc/meminfo
+s cpuinfo; grep -E '^(processor|model name)' /proc/cpuinfo
+s stat1; head -n1 /proc/stat
+sleep 0.2 2>/dev/null || sleep 1
+s stat2; head -n1 /proc/stat
+s mounts; cat /proc/mounts
+s df; df -P -k 2>/dev/null
+s net
+for i in /sys/class/net/*; do
+  [ -e "$i" ] || continue
+  echo "${i##*/}|$(cat "$i/address" 2>/dev/null)|$(cat "$i/mtu" 2>/dev/null)|$(cat "$i/operstate" 2>/dev/null)"
+done
+s addr; ip -o addr show 2>/dev/null
+s tcp; cat /proc/net/tcp 2>/dev/null
+s tcp6; cat /proc/net/tcp6 2>/dev/null
+s udp; cat /proc/net/udp 2>/dev/null
+s udp6; cat /proc/net/udp6 2>/dev/null
+s cgroup
+if [ -f /sys/fs/cgroup/cgroup.controllers ]; then echo 2; elif [ -d /sys/fs/cgroup ]; then echo 1; fi
+s docker; docker --version 2>/dev/null
+s compose; docker compose version --short 2>/dev/null || docker-compose version --short 2>/dev/null
+exit 0`
+
+// pseudoFilesystems are left out of the mount list
+var pseudoFilesystems = map[string]bool{
+	"autofs": true, "binfmt_misc": true, "bpf": true, "cgroup": true, "cgroup2": true,
+	"configfs": true, "debugfs": true, "devpts": true, "devtmpfs": true, "efivarfs": true,
+	"fusectl": true, "hugetlbfs": true, "mqueue": true, "nsfs": true, "overlay": true,
+	"proc": true, "pstore": true, "ramfs": true, "rpc_pipefs": true, "securityfs": true,
+	"selinuxfs": true, "squashfs": true, "sysfs": true, "tmpfs": true, "tracefs": true,
+	"fuse.lxcfs": true,
+}
+
+// gatherResources collects resource information and system facts with a
+// single command
+func gatherResources(ctx context.Context, srv pkg.Server) (*pkg.ResourceInfo, error) {
+	result, err := srv.Execute(ctx, "sh -c "+ShellQuote(factsProbe), false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to gather facts: %w", err)
+	}
+	if result.ExitCode != 0 {
+		return nil, fmt.Errorf("facts probe failed: %s", strings.TrimSpace(result.Stderr))
+	}
+
+	info, err := parseFacts(result.Stdout)
+	if err != nil {
+		return nil, err
+	}
+	info.GatheredAt = time.Now().UTC()
+	return info, nil
+}
+
+// parseFacts builds ResourceInfo from the output of factsProbe
+func parseFacts(output string) (*pkg.ResourceInfo, error) {
+	sections := make(map[string][]string)
+	var current string
+	for _, line := range strings.Split(output, "\n") {
+		if name, ok := strings.CutPrefix(line, "@@mah:"); ok {
+			current = name
+			sections[current] = nil
+			continue
+		}
+		if current != "" && line != "" {
+			sections[current] = append(sections[current], line)
+		}
+	}
+
+	if len(sections["meminfo"]) == 0 {
+		return nil, fmt.Errorf("unexpected facts probe output: no /proc/meminfo")
+	}
+
+	info := &pkg.ResourceInfo{
+		Hostname: firstLine(sections["hostname"]),
+		Kernel:   firstLine(sections["kernel"]),
+	}
+
+	if fields := strings.Fields(firstLine(sections["uptime"])); len(fields) > 0 {
+		uptime, _ := strconv.ParseFloat(fields[0], 64)
+		info.Uptime = int64(uptime)
+	}
+
+	info.CPU = parseCPU(sections["cpuinfo"], firstLine(sections["stat1"]), firstLine(sections["stat2"]))
+	info.CPU.Arch = firstLine(sections["arch"])
+	info.Memory = parseMeminfo(sections["meminfo"])
+	info.Load = parseLoadavg(firstLine(sections["loadavg"]))
+
+	info.Mounts = parseMounts(sections["mounts"], sections["df"])
+	for _, mount := range info.Mounts {
+		if mount.Path == "/" {
+			info.Disk = pkg.DiskInfo{
+				Total:     mount.Total,
+				Available: mount.Available,
+				Used:      mount.Used,
+				Usage:     mount.Usage,
+			}
+		}
+	}
+
+	info.Interfaces = parseInterfaces(sections["net"], sections["addr"])
+
+	for _, proto := range []string{"tcp", "tcp6", "udp", "udp6"} {
+		info.ListeningPorts = append(info.ListeningPorts, parseSockets(proto, sections[proto])...)
+	}
+
+	info.CgroupVersion, _ = strconv.Atoi(firstLine(sections["cgroup"]))
+
+	if docker := firstLine(sections["docker"]); docker != "" {
+		version := strings.TrimPrefix(docker, "Docker version ")
+		version, _, _ = strings.Cut(version, ",")
+		info.Docker = version
+	}
+	info.Compose = firstLine(sections["compose"])
+
+	return info, nil
+}
+
+// firstLine returns the trimmed first line of a section
+func firstLine(lines []string) string {
+	if len(lines) == 0 {
+		return ""
+	}
+	return strings.TrimSpace(lines[0])
+}
+
+// parseCPU counts processors and computes usage from two /proc/stat samples
+func parseCPU(cpuinfo []string, stat1, stat2 string) pkg.CPUInfo {
+	cpu := pkg.CPUInfo{Model: "Unknown"}
+
+	for _, line := range cpuinfo {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		switch strings.TrimSpace(key) {
+		case "processor":
+			cpu.Cores++
+		case "model name":
+			if cpu.Model == "Unknown" {
+				cpu.Model = strings.TrimSpace(value)
+			}
+		}
+	}
+	if cpu.Cores == 0 {
+		cpu.Cores = 1
+	}
+
+	idle1, total1 := parseStat(stat1)
+	idle2, total2 := parseStat(stat2)
+	if total2 > total1 {
+		busy := (total2 - total1) - (idle2 - idle1)
+		cpu.Usage = float64(busy) / float64(total2-total1) * 100
+	}
+
+	return cpu
+}
+
+// parseStat returns the idle and total jiffies of the "cpu" line of /proc/stat
+func parseStat(line string) (idle, total uint64) {
+	fields := strings.Fields(line)
+	if len(fields) < 5 || fields[0] != "cpu" {
+		return 0, 0
+	}
+
+	// user nice system idle iowait irq softirq steal; guest time is
+	// already included in user
+	for i, field := range fields[1:] {
+		if i >= 8 {
+			break
+		}
+		value, _ := strconv.ParseUint(field, 10, 64)
+		total += value
+		if i == 3 || i == 4 {
+			idle += value
+		}
+	}
+	return idle, total
+}
+
+// parseMeminfo reads memory totals from /proc/meminfo
+func parseMeminfo(lines []string) pkg.MemoryInfo {
+	values := make(map[string]int64)
+	for _, line := range lines {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(value)
+		if len(fields) == 0 {
+			continue
+		}
+		kb, _ := strconv.ParseInt(fields[0], 10, 64)
+		values[key] = kb * 1024
+	}
+
+	mem := pkg.MemoryInfo{Total: values["MemTotal"]}
+
+	available, ok := values["MemAvailable"]
+	if !ok {
+		// Kernels before 3.14
+		available = values["MemFree"] + values["Buffers"] + values["Cached"]
+	}
+	mem.Available = available
+	mem.Used = mem.Total - available
+	if mem.Total > 0 {
+		mem.Usage = float64(mem.Used) / float64(mem.Total) * 100
+	}
+
+	return mem
+}
+
+// parseLoadavg reads load averages from /proc/loadavg
+func parseLoadavg(line string) pkg.LoadInfo {
+	fields := strings.Fields(line)
+	if len(fields) < 3 {
+		return pkg.LoadInfo{}
+	}
+
+	load1, _ := strconv.ParseFloat(fields[0], 64)
+	load5, _ := strconv.ParseFloat(fields[1], 64)
+	load15, _ := strconv.ParseFloat(fields[2], 64)
+
+	return pkg.LoadInfo{Load1: load1, Load5: load5, Load15: load15}
+}
+
+// parseMounts combines /proc/mounts with sizes from df, leaving out pseudo
+// filesystems
+func parseMounts(mounts, df []string) []pkg.MountInfo {
+	type size struct{ total, used, available int64 }
+	sizes := make(map[string]size)
+	for _, line := range df {
+		fields := strings.Fields(line)
+		if len(fields) < 6 || fields[0] == "Filesystem" {
+			continue
+		}
+		total, _ := strconv.ParseInt(fields[1], 10, 64)
+		used, _ := strconv.ParseInt(fields[2], 10, 64)
+		available, _ := strconv.ParseInt(fields[3], 10, 64)
+		sizes[strings.Join(fields[5:], " ")] = size{total * 1024, used * 1024, available * 1024}
+	}
+
+	var result []pkg.MountInfo
+	seen := make(map[string]int)
+	for _, line := range mounts {
+		fields := strings.Fields(line)
+		if len(fields) < 3 || pseudoFilesystems[fields[2]] {
+			continue
+		}
+
+		mount := pkg.MountInfo{
+			Device: unescapeMountField(fields[0]),
+			Path:   unescapeMountField(fields[1]),
+			FSType: fields[2],
+		}
+		sz, ok := sizes[mount.Path]
+		if !ok || sz.total == 0 {
+			continue
+		}
+		mount.Total, mount.Used, mount.Available = sz.total, sz.used, sz.available
+		mount.Usage = float64(sz.used) / float64(sz.total) * 100
+
+		// A path mounted over keeps only the top mount
+		if i, ok := seen[mount.Path]; ok {
+			result[i] = mount
+			continue
+		}
+		seen[mount.Path] = len(result)
+		result = append(result, mount)
+	}
+
+	return result
+}
+
+// unescapeMountField decodes the octal escapes (\040 for space) used in
+// /proc/mounts
+func unescapeMountField(field string) string {
+	if !strings.Contains(field, `\`) {
+		return field
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(field); i++ {
+		if field[i] == '\\' && i+3 < len(field) {
+			if value, err := strconv.ParseUint(field[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(value))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(field[i])
+	}
+	return b.String()
+}
+
+// parseInterfaces combines sysfs interface details with addresses from
+// "ip -o addr"
+func parseInterfaces(netLines, addrLines []string) []pkg.NetworkInterface {
+	var interfaces []pkg.NetworkInterface
+	index := make(map[string]int)
+
+	for _, line := range netLines {
+		parts := strings.Split(line, "|")
+		if len(parts) != 4 {
+			continue
+		}
+		mtu, _ := strconv.Atoi(parts[2])
+		index[parts[0]] = len(interfaces)
+		interfaces = append(interfaces, pkg.NetworkInterface{
+			Name:      parts[0],
+			MAC:       parts[1],
+			MTU:       mtu,
+			State:     parts[3],
+			Addresses: []string{},
+		})
+	}
+
+	for _, line := range addrLines {
+		// 2: eth0    inet 10.0.0.5/24 brd 10.0.0.255 scope global eth0
+		fields := strings.Fields(line)
+		if len(fields) < 4 || (fields[2] != "inet" && fields[2] != "inet6") {
+			continue
+		}
+		name, _, _ := strings.Cut(fields[1], "@")
+		if i, ok := index[name]; ok {
+			interfaces[i].Addresses = append(interfaces[i].Addresses, fields[3])
+		}
+	}
+
+	return interfaces
+}
+
+// parseSockets returns the listening sockets from /proc/net/{tcp,udp}[6].
+// TCP sockets in state LISTEN (0A) and unconnected UDP sockets (07) count.
+func parseSockets(proto string, lines []string) []pkg.ListeningPort {
+	state := "0A"
+	if strings.HasPrefix(proto, "udp") {
+		state = "07"
+	}
+
+	var ports []pkg.ListeningPort
+	seen := make(map[string]bool)
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) < 4 || fields[3] != state {
+			continue
+		}
+
+		hexAddr, hexPort, ok := strings.Cut(fields[1], ":")
+		if !ok {
+			continue
+		}
+		port, err := strconv.ParseUint(hexPort, 16, 16)
+		if err != nil {
+			continue
+		}
+		addr, err := decodeProcAddr(hexAddr)
+		if err != nil {
+			continue
+		}
+
+		key := addr + ":" + hexPort
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		ports = append(ports, pkg.ListeningPort{Protocol: proto, Address: addr, Port: int(port)})
+	}
+
+	sort.Slice(ports, func(i, j int) bool {
+		if ports[i].Port != ports[j].Port {
+			return ports[i].Port < ports[j].Port
+		}
+		return ports[i].Address < ports[j].Address
+	})
+	return ports
+}
+
+// decodeProcAddr decodes an address from /proc/net, stored as 32-bit words
+// in host byte order (little-endian on all platforms MAH supports)
+func decodeProcAddr(s string) (string, error) {
+	raw, err := hex.DecodeString(s)
+	if err != nil || (len(raw) != net.IPv4len && len(raw) != net.IPv6len) {
+		return "", fmt.Errorf("invalid address %q", s)
+	}
+
+	ip := make(net.IP, len(raw))
+	for word := 0; word < len(raw); word += 4 {
+		for i := 0; i < 4; i++ {
+			ip[word+i] = raw[word+3-i]
+		}
+	}
+	return ip.String(), nil
+}
+
+// FactsPath returns where the facts of a server are cached
+func FactsPath(stateDir, serverName string) string {
+	return filepath.Join(stateDir, "facts", serverName+".json")
+}
+
+// LoadFacts reads the cached facts of a server. The error wraps
+// os.ErrNotExist when nothing is cached yet.
+func LoadFacts(stateDir, serverName string) (*pkg.ResourceInfo, error) {
+	data, err := os.ReadFile(FactsPath(stateDir, serverName))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cached facts: %w", err)
+	}
+
+	var info pkg.ResourceInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, fmt.Errorf("failed to parse cached facts: %w", err)
+	}
+	return &info, nil
+}
+
+// SaveFacts caches the facts of a server
+func SaveFacts(stateDir, serverName string, info *pkg.ResourceInfo) error {
+	path := FactsPath(stateDir, serverName)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create facts directory: %w", err)
+	}
+
+	data, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode facts: %w", err)
+	}
+
+	// Write atomically so concurrent commands never read a partial file,
+	// each through its own temporary file
+	if err := writeFileAtomic(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write facts: %w", err)
+	}
+	return nil
+}
+
+// writeFileAtomic replaces path with data through a uniquely named
+// temporary file in the same directory
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,155 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/jonas-jonas/mah/pkg"
+	"github.com/jonas-jonas/mah/pkg/mahtest"
+)
+
+func TestParseFacts(t *testing.T) {
+	output, err := os.ReadFile("testdata/facts_probe.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := parseFacts(string(output))
+	if err != nil {
+		t.Fatalf("parseFacts() error = %v", err)
+	}
+
+	if info.Hostname != "web-1" || info.Kernel != "5.15.0-113-generic" || info.Uptime != 86461 {
+		t.Errorf("hostname, kernel, uptime = %q, %q, %d", info.Hostname, info.Kernel, info.Uptime)
+	}
+
+	wantCPU := pkg.CPUInfo{Cores: 2, Usage: 30, Model: "AMD EPYC 7543 32-Core Processor", Arch: "x86_64"}
+	if info.CPU != wantCPU {
+		t.Errorf("CPU = %+v, want %+v", info.CPU, wantCPU)
+	}
+
+	if info.Memory.Total != 4026532*1024 || info.Memory.Available != 3019899*1024 {
+		t.Errorf("Memory = %+v", info.Memory)
+	}
+	if info.Load != (pkg.LoadInfo{Load1: 0.52, Load5: 0.41, Load15: 0.30}) {
+		t.Errorf("Load = %+v", info.Load)
+	}
+
+	wantMounts := []pkg.MountInfo{
+		{Device: "/dev/sda1", Path: "/", FSType: "ext4", Total: 41152736 * 1024, Used: 10288184 * 1024, Available: 28746952 * 1024},
+		{Device: "/dev/sdb1", Path: "/srv/backup disk", FSType: "xfs", Total: 104857600 * 1024, Used: 52428800 * 1024, Available: 52428800 * 1024, Usage: 50},
+	}
+	wantMounts[0].Usage = float64(wantMounts[0].Used) / float64(wantMounts[0].Total) * 100
+	if !reflect.DeepEqual(info.Mounts, wantMounts) {
+		t.Errorf("Mounts = %+v, want %+v", info.Mounts, wantMounts)
+	}
+	if info.Disk.Total != wantMounts[0].Total {
+		t.Errorf("Disk.Total = %d, want the root filesystem's %d", info.Disk.Total, wantMounts[0].Total)
+	}
+
+	wantInterfaces := []pkg.NetworkInterface{
+		{Name: "docker0", MAC: "02:42:5c:1a:2b:3c", MTU: 1500, State: "down", Addresses: []string{"172.17.0.1/16"}},
+		{Name: "eth0", MAC: "96:00:02:ab:cd:ef", MTU: 1500, State: "up", Addresses: []string{"203.0.113.10/32", "2001:db8::1/64"}},
+		{Name: "lo", MAC: "00:00:00:00:00:00", MTU: 65536, State: "unknown", Addresses: []string{"127.0.0.1/8", "::1/128"}},
+	}
+	if !reflect.DeepEqual(info.Interfaces, wantInterfaces) {
+		t.Errorf("Interfaces = %+v, want %+v", info.Interfaces, wantInterfaces)
+	}
+
+	wantPorts := []pkg.ListeningPort{
+		{Protocol: "tcp", Address: "0.0.0.0", Port: 22},
+		{Protocol: "tcp", Address: "127.0.0.1", Port: 8080},
+		{Protocol: "tcp6", Address: "::", Port: 80},
+		{Protocol: "tcp6", Address: "::1", Port: 443},
+		{Protocol: "udp", Address: "127.0.0.53", Port: 53},
+	}
+	if !reflect.DeepEqual(info.ListeningPorts, wantPorts) {
+		t.Errorf("ListeningPorts = %+v, want %+v", info.ListeningPorts, wantPorts)
+	}
+
+	if info.CgroupVersion != 2 || info.Docker != "27.0.3" || info.Compose != "2.28.1" {
+		t.Errorf("cgroup, docker, compose = %d, %q, %q", info.CgroupVersion, info.Docker, info.Compose)
+	}
+}
+
+func TestParseFactsRejectsUnexpectedOutput(t *testing.T) {
+	if _, err := parseFacts("sh: 1: cat: not found\n"); err == nil {
+		t.Error("parseFacts() succeeded, want error")
+	}
+}
+
+func TestGatherResourcesSingleRoundTrip(t *testing.T) {
+	output, err := os.ReadFile("testdata/facts_probe.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := mahtest.NewServer("web-1").OnPrefix("sh -c ", mahtest.Response{Stdout: string(output)})
+
+	info, err := gatherResources(context.Background(), srv)
+	if err != nil {
+		t.Fatalf("gatherResources() error = %v", err)
+	}
+	if info.GatheredAt.IsZero() {
+		t.Error("GatheredAt not set")
+	}
+	if calls := srv.Calls(); len(calls) != 1 || calls[0].Sudo {
+		t.Errorf("calls = %+v, want one command without sudo", calls)
+	}
+}
+
+func TestFactsCache(t *testing.T) {
+	stateDir := t.TempDir()
+
+	if _, err := LoadFacts(stateDir, "web-1"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("LoadFacts() on empty cache error = %v, want not exist", err)
+	}
+
+	info := &pkg.ResourceInfo{Hostname: "web-1", Kernel: "6.8.0", CgroupVersion: 2}
+	if err := SaveFacts(stateDir, "web-1", info); err != nil {
+		t.Fatalf("SaveFacts() error = %v", err)
+	}
+
+	cached, err := LoadFacts(stateDir, "web-1")
+	if err != nil {
+		t.Fatalf("LoadFacts() error = %v", err)
+	}
+	if cached.Hostname != "web-1" || cached.Kernel != "6.8.0" || cached.CgroupVersion != 2 {
+		t.Errorf("cached facts = %+v", cached)
+	}
+}
+
+func TestSaveFactsConcurrently(t *testing.T) {
+	stateDir := t.TempDir()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs <- SaveFacts(stateDir, "web-1", &pkg.ResourceInfo{Hostname: fmt.Sprintf("web-%d", i)})
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Errorf("SaveFacts() error = %v", err)
+		}
+	}
+	if _, err := LoadFacts(stateDir, "web-1"); err != nil {
+		t.Errorf("LoadFacts() error = %v", err)
+	}
+
+	entries, _ := os.ReadDir(filepath.Dir(FactsPath(stateDir, "web-1")))
+	if len(entries) != 1 {
+		t.Errorf("facts directory holds %d files, want only the facts", len(entries))
+	}
+}
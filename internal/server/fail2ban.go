@@ -0,0 +1,281 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/jonas-jonas/mah/pkg"
+)
+
+const (
+	fail2banJail   = "/etc/fail2ban/jail.d/mah.local"
+	fail2banFilter = "/etc/fail2ban/filter.d/traefik-auth.conf"
+)
+
+// traefikAuthFilter matches requests Traefik rejected with 401, as written
+// to its access log in common log format
+const traefikAuthFilter = `# Managed by mah; changes are overwritten by mah server init
+[Definition]
+failregex = ^<HOST> \S+ \S+ \[[^\]]+\] "[A-Z]+ [^"]*" 401 \d+
+ignoreregex =
+`
+
+// fail2banJailName matches the jail names accepted by Unban
+var fail2banJailName = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// fail2banPlatform describes how fail2ban is installed and run on a
+// distribution family
+type fail2banPlatform struct {
+	install   string // installs fail2ban, run as root
+	backend   string // where the sshd jail reads failed logins from
+	sshLog    string // sshd log file, for file backends
+	banaction string
+	enable    string // enables fail2ban at boot
+	restart   string
+}
+
+var (
+	debianFail2ban = fail2banPlatform{
+		install:   "apt-get install -y fail2ban python3-systemd",
+		backend:   "systemd",
+		banaction: "iptables-multiport",
+		enable:    "systemctl enable fail2ban",
+		restart:   "systemctl restart fail2ban",
+	}
+
+	// RHEL and its clones get fail2ban from EPEL
+	rhelFail2ban = fail2banPlatform{
+		install:   "sh -c 'dnf install -y fail2ban || { dnf install -y epel-release && dnf install -y fail2ban; }'",
+		backend:   "systemd",
+		banaction: "firewallcmd-rich-rules",
+		enable:    "systemctl enable fail2ban",
+		restart:   "systemctl restart fail2ban",
+	}
+
+	// Fedora ships fail2ban itself
+	fedoraFail2ban = fail2banPlatform{
+		install:   "dnf install -y fail2ban",
+		backend:   "systemd",
+		banaction: "firewallcmd-rich-rules",
+		enable:    "systemctl enable fail2ban",
+		restart:   "systemctl restart fail2ban",
+	}
+
+	alpineFail2ban = fail2banPlatform{
+		install:   "apk add fail2ban",
+		backend:   "auto",
+		sshLog:    "/var/log/messages",
+		banaction: "iptables-multiport",
+		enable:    "rc-update add fail2ban default",
+		restart:   "rc-service fail2ban restart",
+	}
+)
+
+// configureFail2ban installs fail2ban and writes its jails as a managed
+// local file. fail2ban is only restarted when the configuration changed; a
+// configuration fail2ban rejects is replaced by the previous one.
+func configureFail2ban(ctx context.Context, server pkg.Server, policy pkg.Fail2banPolicy, platform fail2banPlatform) error {
+	// Install fail2ban if not present
+	result, err := server.Execute(ctx, "which fail2ban-client", false)
+	if err != nil || result.ExitCode != 0 {
+		result, err = server.Execute(ctx, platform.install, true)
+		if err != nil {
+			return fmt.Errorf("failed to install fail2ban: %w", err)
+		}
+		if result.ExitCode != 0 {
+			return fmt.Errorf("fail2ban installation failed: %s", result.Stderr)
+		}
+	}
+
+	// fail2ban refuses to start when a jail's log is missing, which it is
+	// until Traefik is deployed
+	if policy.TraefikLog != "" {
+		touch := fmt.Sprintf("mkdir -p %s && touch %s", path.Dir(policy.TraefikLog), policy.TraefikLog)
+		if err := runScript(ctx, server, touch); err != nil {
+			return fmt.Errorf("failed to create Traefik access log: %w", err)
+		}
+	}
+
+	type managedFile struct {
+		path    string
+		content string
+	}
+	files := []managedFile{{fail2banJail, fail2banJailContent(policy, platform)}}
+	if policy.TraefikLog != "" {
+		files = append(files, managedFile{fail2banFilter, traefikAuthFilter})
+	}
+
+	// Write the files that changed, remembering what they replaced
+	previous := map[string]*string{}
+	for _, file := range files {
+		result, err := server.Execute(ctx, "cat "+file.path, false)
+		if err == nil && result.ExitCode == 0 {
+			if result.Stdout == file.content {
+				continue
+			}
+			current := result.Stdout
+			previous[file.path] = &current
+		} else {
+			previous[file.path] = nil
+		}
+
+		if err := WriteFile(ctx, server, file.path, file.content); err != nil {
+			return err
+		}
+	}
+
+	if len(previous) > 0 {
+		result, err = server.Execute(ctx, "fail2ban-client -t", true)
+		if err != nil || result.ExitCode != 0 {
+			cause := fmt.Errorf("fail2ban configuration test failed: %s", errorDetail(result, err))
+			for file, content := range previous {
+				if content != nil {
+					err = WriteFile(ctx, server, file, *content)
+				} else {
+					err = runScript(ctx, server, "rm -f "+file)
+				}
+				if err != nil {
+					return fmt.Errorf("%w (restoring %s also failed: %v)", cause, file, err)
+				}
+			}
+			return cause
+		}
+	}
+
+	// Enable fail2ban at boot
+	result, err = server.Execute(ctx, platform.enable, true)
+	if err != nil {
+		return fmt.Errorf("failed to enable fail2ban: %w", err)
+	}
+	if result.ExitCode != 0 {
+		return fmt.Errorf("fail2ban enable failed: %s", result.Stderr)
+	}
+
+	if len(previous) > 0 {
+		result, err = server.Execute(ctx, platform.restart, true)
+		if err != nil {
+			return fmt.Errorf("failed to restart fail2ban: %w", err)
+		}
+		if result.ExitCode != 0 {
+			return fmt.Errorf("fail2ban restart failed: %s", result.Stderr)
+		}
+	}
+
+	return nil
+}
+
+// fail2banJailContent renders the jails for policy
+func fail2banJailContent(policy pkg.Fail2banPolicy, platform fail2banPlatform) string {
+	banTime := policy.BanTime
+	if banTime == "" {
+		banTime = "1h"
+	}
+	findTime := policy.FindTime
+	if findTime == "" {
+		findTime = "10m"
+	}
+	maxRetry := policy.MaxRetry
+	if maxRetry == 0 {
+		maxRetry = 5
+	}
+	sshPort := policy.SSHPort
+	if sshPort == 0 {
+		sshPort = 22
+	}
+
+	var b strings.Builder
+	b.WriteString("# Managed by mah; changes are overwritten by mah server init\n")
+	b.WriteString("[DEFAULT]\n")
+	fmt.Fprintf(&b, "bantime = %s\n", banTime)
+	fmt.Fprintf(&b, "findtime = %s\n", findTime)
+	fmt.Fprintf(&b, "maxretry = %d\n", maxRetry)
+	fmt.Fprintf(&b, "ignoreip = %s\n", strings.Join(append([]string{"127.0.0.1/8", "::1"}, policy.IgnoreIPs...), " "))
+	fmt.Fprintf(&b, "banaction = %s\n", platform.banaction)
+
+	b.WriteString("\n[sshd]\nenabled = true\n")
+	fmt.Fprintf(&b, "port = %d\n", sshPort)
+	fmt.Fprintf(&b, "backend = %s\n", platform.backend)
+	if platform.sshLog != "" {
+		fmt.Fprintf(&b, "logpath = %s\n", platform.sshLog)
+	}
+
+	// Traffic to containers is forwarded, not delivered to INPUT, so the
+	// ban goes into the chain Docker leaves to the user
+	if policy.TraefikLog != "" {
+		b.WriteString("\n[traefik-auth]\nenabled = true\nfilter = traefik-auth\n")
+		b.WriteString("port = http,https\n")
+		fmt.Fprintf(&b, "logpath = %s\n", policy.TraefikLog)
+		b.WriteString("backend = auto\n")
+		b.WriteString("action = iptables-multiport[name=traefik-auth, port=\"http,https\", chain=DOCKER-USER]\n")
+	}
+
+	return b.String()
+}
+
+// ListBans returns the addresses currently banned by fail2ban, by jail
+func ListBans(ctx context.Context, server pkg.Server) ([]pkg.Ban, error) {
+	script := `status=$(fail2ban-client status) || exit $?
+for jail in $(echo "$status" | sed -n 's/.*Jail list:[[:space:]]*//p' | tr ',' ' '); do
+	echo "@@jail $jail"
+	fail2ban-client status "$jail"
+done`
+
+	result, err := server.Execute(ctx, "sh -c "+ShellQuote(script), true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list bans: %w", err)
+	}
+	if result.ExitCode != 0 {
+		return nil, fmt.Errorf("fail2ban status failed: %s", errorDetail(result, nil))
+	}
+
+	return parseBans(result.Stdout), nil
+}
+
+// parseBans parses the status of each jail, as printed by ListBans
+func parseBans(output string) []pkg.Ban {
+	var bans []pkg.Ban
+	jail := ""
+	for _, line := range strings.Split(output, "\n") {
+		if name, ok := strings.CutPrefix(line, "@@jail "); ok {
+			jail = strings.TrimSpace(name)
+			continue
+		}
+
+		_, list, ok := strings.Cut(line, "Banned IP list:")
+		if !ok || jail == "" {
+			continue
+		}
+		for _, ip := range strings.Fields(list) {
+			bans = append(bans, pkg.Ban{Jail: jail, IP: ip})
+		}
+	}
+	return bans
+}
+
+// Unban lifts a ban on ip in jail, or in every jail when jail is empty
+func Unban(ctx context.Context, server pkg.Server, ip, jail string) error {
+	if net.ParseIP(ip) == nil {
+		return fmt.Errorf("invalid IP address: %s", ip)
+	}
+
+	cmd := fmt.Sprintf("fail2ban-client unban %s", ip)
+	if jail != "" {
+		if !fail2banJailName.MatchString(jail) {
+			return fmt.Errorf("invalid jail name: %s", jail)
+		}
+		cmd = fmt.Sprintf("fail2ban-client set %s unbanip %s", jail, ip)
+	}
+
+	result, err := server.Execute(ctx, cmd, true)
+	if err != nil {
+		return fmt.Errorf("failed to unban %s: %w", ip, err)
+	}
+	if result.ExitCode != 0 {
+		return fmt.Errorf("unban failed: %s", errorDetail(result, nil))
+	}
+	return nil
+}
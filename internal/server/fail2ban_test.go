@@ -0,0 +1,151 @@
+package server
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jonas-jonas/mah/pkg"
+	"github.com/jonas-jonas/mah/pkg/mahtest"
+)
+
+const testBansOutput = `@@jail sshd
+Status for the jail: sshd
+|- Filter
+|  |- Currently failed:	1
+|  |- Total failed:	42
+|  ` + "`" + `- Journal matches:	_SYSTEMD_UNIT=sshd.service + _COMM=sshd
+` + "`" + `- Actions
+   |- Currently banned:	2
+   |- Total banned:	9
+   ` + "`" + `- Banned IP list:	203.0.113.7 2001:db8::7
+@@jail traefik-auth
+Status for the jail: traefik-auth
+|- Filter
+|  |- Currently failed:	0
+|  |- Total failed:	0
+|  ` + "`" + `- File list:	/var/log/traefik/access.log
+` + "`" + `- Actions
+   |- Currently banned:	0
+   |- Total banned:	0
+   ` + "`" + `- Banned IP list:
+`
+
+func TestListBans(t *testing.T) {
+	srv := mahtest.NewServer("web-1").OnPrefix("sh -c ", mahtest.Response{Stdout: testBansOutput})
+
+	bans, err := ListBans(context.Background(), srv)
+	if err != nil {
+		t.Fatalf("ListBans() error = %v", err)
+	}
+
+	want := []pkg.Ban{
+		{Jail: "sshd", IP: "203.0.113.7"},
+		{Jail: "sshd", IP: "2001:db8::7"},
+	}
+	if !reflect.DeepEqual(bans, want) {
+		t.Errorf("bans = %+v, want %+v", bans, want)
+	}
+	if calls := srv.Calls(); len(calls) != 1 || !calls[0].Sudo {
+		t.Errorf("calls = %+v, want one command with sudo", calls)
+	}
+}
+
+func TestListBansWithoutFail2ban(t *testing.T) {
+	srv := mahtest.NewServer("web-1").OnPrefix("sh -c ", mahtest.Response{Stderr: "sh: fail2ban-client: not found\n", ExitCode: 127})
+
+	if _, err := ListBans(context.Background(), srv); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("ListBans() error = %v, want fail2ban-client not found", err)
+	}
+}
+
+func TestUnban(t *testing.T) {
+	tests := []struct {
+		name    string
+		ip      string
+		jail    string
+		want    string
+		wantErr bool
+	}{
+		{name: "all jails", ip: "203.0.113.7", want: "sudo fail2ban-client unban 203.0.113.7"},
+		{name: "one jail", ip: "2001:db8::7", jail: "sshd", want: "sudo fail2ban-client set sshd unbanip 2001:db8::7"},
+		{name: "invalid ip", ip: "203.0.113.7; reboot", wantErr: true},
+		{name: "invalid jail", ip: "203.0.113.7", jail: "sshd reboot", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := mahtest.NewServer("web-1")
+
+			err := Unban(context.Background(), srv, tt.ip, tt.jail)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Unban() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			var want []string
+			if tt.want != "" {
+				want = []string{tt.want}
+			}
+			if got := srv.Commands(); !reflect.DeepEqual(got, want) {
+				t.Errorf("commands = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestConfigureFail2banSkipsCurrentConfig(t *testing.T) {
+	policy := pkg.Fail2banPolicy{SSHPort: 22}
+	srv := mahtest.NewServer("web-1").
+		On("which fail2ban-client", mahtest.Response{Stdout: "/usr/bin/fail2ban-client\n"}).
+		On("cat "+fail2banJail, mahtest.Response{Stdout: fail2banJailContent(policy, debianFail2ban)})
+
+	if err := configureFail2ban(context.Background(), srv, policy, debianFail2ban); err != nil {
+		t.Fatalf("configureFail2ban() error = %v", err)
+	}
+
+	want := []string{"which fail2ban-client", "cat " + fail2banJail, "sudo systemctl enable fail2ban"}
+	if got := srv.Commands(); !reflect.DeepEqual(got, want) {
+		t.Errorf("commands = %q, want %q", got, want)
+	}
+}
+
+func TestConfigureFail2banRestoresRejectedConfig(t *testing.T) {
+	srv := mahtest.NewServer("web-1").
+		On("which fail2ban-client", mahtest.Response{Stdout: "/usr/bin/fail2ban-client\n"}).
+		On("cat "+fail2banJail, mahtest.Response{Stdout: "[sshd]\nenabled = true\n"}).
+		On("cat "+fail2banFilter, mahtest.Response{ExitCode: 1}).
+		On("fail2ban-client -t", mahtest.Response{Stderr: "ERROR: Found no accessible config files for 'filter.d/traefik-auth'\n", ExitCode: 255})
+
+	policy := pkg.Fail2banPolicy{TraefikLog: "/var/log/traefik/access.log"}
+	if err := configureFail2ban(context.Background(), srv, policy, debianFail2ban); err == nil {
+		t.Fatal("configureFail2ban() succeeded, want error")
+	}
+
+	// The last write to each file is the restore
+	written := map[string]string{}
+	for _, call := range srv.Calls() {
+		if call.Op == mahtest.OpStream {
+			written[call.Cmd] = string(call.Stdin)
+		}
+	}
+	if got := written["tee '"+fail2banJail+"' > /dev/null"]; got != "[sshd]\nenabled = true\n" {
+		t.Errorf("jail restored as %q, want the previous content", got)
+	}
+
+	removed := false
+	for _, cmd := range srv.Commands() {
+		if cmd == "sudo sh -c 'rm -f "+fail2banFilter+"'" {
+			removed = true
+		}
+	}
+	if !removed {
+		t.Error("new filter not removed")
+	}
+
+	for _, cmd := range srv.Commands() {
+		if strings.Contains(cmd, "restart fail2ban") {
+			t.Error("restarted fail2ban with a rejected config")
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/jonas-jonas/mah/pkg"
+)
+
+// Forward describes a port forward over a server connection
+type Forward struct {
+	Listen  string // address to accept connections on
+	Target  string // address connections are forwarded to
+	Reverse bool   // listen on the server and connect to Target locally
+}
+
+// String returns the forward in a human-readable form
+func (f Forward) String() string {
+	if f.Reverse {
+		return fmt.Sprintf("server %s -> local %s", f.Listen, f.Target)
+	}
+	return fmt.Sprintf("local %s -> server %s", f.Listen, f.Target)
+}
+
+// ParseForward parses an ssh-style "[bind:]port:host:hostport" forward
+// spec. The bind address defaults to loopback.
+func ParseForward(spec string, reverse bool) (Forward, error) {
+	parts := strings.Split(spec, ":")
+
+	bind := "127.0.0.1"
+	switch len(parts) {
+	case 3:
+	case 4:
+		bind = parts[0]
+		parts = parts[1:]
+	default:
+		return Forward{}, fmt.Errorf("invalid forward '%s': expected [bind:]port:host:hostport", spec)
+	}
+
+	for _, port := range []string{parts[0], parts[2]} {
+		if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+			return Forward{}, fmt.Errorf("invalid port '%s' in forward '%s'", port, spec)
+		}
+	}
+
+	return Forward{
+		Listen:  net.JoinHostPort(bind, parts[0]),
+		Target:  net.JoinHostPort(parts[1], parts[2]),
+		Reverse: reverse,
+	}, nil
+}
+
+// ParsePortPair parses a "[local-port:]port" spec. Without a local port,
+// the same port is used on both ends.
+func ParsePortPair(spec string) (localPort, port int, err error) {
+	local, remote, found := strings.Cut(spec, ":")
+	if !found {
+		remote = local
+	}
+
+	p, err := strconv.ParseUint(remote, 10, 16)
+	if err != nil || p == 0 {
+		return 0, 0, fmt.Errorf("invalid port '%s'", remote)
+	}
+	l, err := strconv.ParseUint(local, 10, 16)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid local port '%s'", local)
+	}
+	return int(l), int(p), nil
+}
+
+// Forwarder accepts connections for a single forward and relays them over
+// the server connection
+type Forwarder struct {
+	server   pkg.Server
+	forward  Forward
+	listener net.Listener
+}
+
+// NewForwarder starts listening for a forward
+func NewForwarder(ctx context.Context, srv pkg.Server, forward Forward) (*Forwarder, error) {
+	var listener net.Listener
+	var err error
+
+	if forward.Reverse {
+		listener, err = srv.Listen(ctx, "tcp", forward.Listen)
+	} else {
+		listener, err = net.Listen("tcp", forward.Listen)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", forward.Listen, err)
+	}
+
+	return &Forwarder{
+		server:   srv,
+		forward:  forward,
+		listener: listener,
+	}, nil
+}
+
+// Close stops listening without serving
+func (f *Forwarder) Close() error {
+	return f.listener.Close()
+}
+
+// Serve relays connections until ctx is cancelled, then closes the listener
+// and all open connections
+func (f *Forwarder) Serve(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	go func() {
+		<-ctx.Done()
+		f.listener.Close()
+	}()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for {
+		conn, err := f.listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("failed to accept connection on %s: %w", f.forward.Listen, err)
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			f.handle(ctx, conn)
+		}()
+	}
+}
+
+// handle connects an accepted connection to the forward's target
+func (f *Forwarder) handle(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+
+	var target net.Conn
+	var err error
+	if f.forward.Reverse {
+		var dialer net.Dialer
+		target, err = dialer.DialContext(ctx, "tcp", f.forward.Target)
+	} else {
+		target, err = f.server.Dial(ctx, "tcp", f.forward.Target)
+	}
+	if err != nil {
+		fmt.Printf("⚠️  Forward %s: failed to connect to %s: %v\n", f.forward, f.forward.Target, err)
+		return
+	}
+	defer target.Close()
+
+	// Relay until either side closes or the forward is stopped
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(target, conn)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(conn, target)
+		done <- struct{}{}
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+}
@@ -0,0 +1,33 @@
+package server
+
+import "testing"
+
+func TestParsePortPair(t *testing.T) {
+	tests := []struct {
+		spec      string
+		wantLocal int
+		wantPort  int
+		wantErr   bool
+	}{
+		{spec: "3306", wantLocal: 3306, wantPort: 3306},
+		{spec: "13306:3306", wantLocal: 13306, wantPort: 3306},
+		{spec: "0:3306", wantLocal: 0, wantPort: 3306}, // any free local port
+		{spec: "", wantErr: true},
+		{spec: "mysql", wantErr: true},
+		{spec: "13306:", wantErr: true},
+		{spec: "70000:3306", wantErr: true},
+		{spec: "3306:0", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			local, port, err := ParsePortPair(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParsePortPair(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+			}
+			if !tt.wantErr && (local != tt.wantLocal || port != tt.wantPort) {
+				t.Errorf("ParsePortPair(%q) = %d, %d, want %d, %d", tt.spec, local, port, tt.wantLocal, tt.wantPort)
+			}
+		})
+	}
+}
@@ -0,0 +1,336 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"github.com/jonas-jonas/mah/internal/config"
+)
+
+// Host key checking modes for config.Server.HostKeyCheck
+const (
+	HostKeyCheckTOFU   = "tofu"   // record unknown keys on first connect
+	HostKeyCheckStrict = "strict" // reject unknown keys until trusted
+)
+
+// HostKeyMismatchError is returned when a server presents a key that differs
+// from the one recorded for it
+type HostKeyMismatchError struct {
+	Host  string
+	Known []string // fingerprints of the recorded keys
+	Got   string   // fingerprint of the presented key
+	Files []string // where the recorded keys came from
+}
+
+func (e *HostKeyMismatchError) Error() string {
+	return fmt.Sprintf("host key for %s has changed: expected %s, got %s (recorded in %s). "+
+		"If the change is expected, run 'mah server trust' to record the new key",
+		e.Host, strings.Join(e.Known, ", "), e.Got, strings.Join(e.Files, ", "))
+}
+
+// HostKeyUnknownError is returned in strict mode when no key is recorded for a server
+type HostKeyUnknownError struct {
+	Host string
+	Got  string
+}
+
+func (e *HostKeyUnknownError) Error() string {
+	return fmt.Sprintf("host key for %s is not trusted (%s); run 'mah server trust' to verify and record it",
+		e.Host, e.Got)
+}
+
+// HostKeyVerifier verifies server host keys against a pinned fingerprint,
+// the MAH-managed known_hosts file and the user's ~/.ssh/known_hosts
+type HostKeyVerifier struct {
+	pin            string
+	mode           string
+	userKnownHosts []string
+	mahKnownHosts  string
+}
+
+// NewHostKeyVerifier creates a verifier for a server configuration
+func NewHostKeyVerifier(cfg *config.Server) (*HostKeyVerifier, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get home directory: %w", err)
+	}
+
+	mode := strings.ToLower(cfg.HostKeyCheck)
+	if mode == "" {
+		mode = HostKeyCheckTOFU
+	}
+
+	return &HostKeyVerifier{
+		pin:            cfg.HostKey,
+		mode:           mode,
+		userKnownHosts: []string{filepath.Join(home, ".ssh", "known_hosts")},
+		mahKnownHosts:  MAHKnownHostsPath(home),
+	}, nil
+}
+
+// MAHKnownHostsPath returns the location of the MAH-managed known_hosts file
+func MAHKnownHostsPath(home string) string {
+	return filepath.Join(home, ".mah", "state", "known_hosts")
+}
+
+// Callback returns an ssh.HostKeyCallback implementing the verification policy
+func (v *HostKeyVerifier) Callback() ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		fingerprint := ssh.FingerprintSHA256(key)
+
+		// A pinned fingerprint overrides any known_hosts lookup
+		if v.pin != "" {
+			if normalizeFingerprint(v.pin) != fingerprint {
+				return &HostKeyMismatchError{
+					Host:  hostname,
+					Known: []string{normalizeFingerprint(v.pin)},
+					Got:   fingerprint,
+					Files: []string{"host_key in mah.yaml"},
+				}
+			}
+			return nil
+		}
+
+		files := v.existingFiles()
+		if len(files) > 0 {
+			callback, err := knownhosts.New(files...)
+			if err != nil {
+				return fmt.Errorf("failed to load known_hosts: %w", err)
+			}
+
+			err = callback(hostname, remote, key)
+			if err == nil {
+				return nil
+			}
+
+			var keyErr *knownhosts.KeyError
+			if !errors.As(err, &keyErr) {
+				return err
+			}
+			if len(keyErr.Want) > 0 {
+				mismatch := &HostKeyMismatchError{Host: hostname, Got: fingerprint}
+				for _, want := range keyErr.Want {
+					mismatch.Known = append(mismatch.Known, ssh.FingerprintSHA256(want.Key))
+					mismatch.Files = append(mismatch.Files, fmt.Sprintf("%s:%d", want.Filename, want.Line))
+				}
+				return mismatch
+			}
+		}
+
+		// Key is unknown everywhere
+		if v.mode != HostKeyCheckTOFU {
+			return &HostKeyUnknownError{Host: hostname, Got: fingerprint}
+		}
+
+		if err := RecordHostKey(v.mahKnownHosts, hostname, key); err != nil {
+			return fmt.Errorf("failed to record host key for %s: %w", hostname, err)
+		}
+		return nil
+	}
+}
+
+// HostKeyAlgorithms returns the key algorithms already recorded for an
+// address, so the handshake negotiates a key type we can actually verify
+func (v *HostKeyVerifier) HostKeyAlgorithms(addr string) []string {
+	if v.pin != "" {
+		return nil
+	}
+
+	files := v.existingFiles()
+	if len(files) == 0 {
+		return nil
+	}
+
+	callback, err := knownhosts.New(files...)
+	if err != nil {
+		return nil
+	}
+
+	// Probing with a placeholder key makes knownhosts list every recorded key
+	var keyErr *knownhosts.KeyError
+	err = callback(addr, &net.TCPAddr{IP: net.IPv4zero}, placeholderKey{})
+	if !errors.As(err, &keyErr) {
+		return nil
+	}
+
+	var algorithms []string
+	seen := make(map[string]bool)
+	for _, known := range keyErr.Want {
+		for _, algo := range algorithmsForKeyType(known.Key.Type()) {
+			if !seen[algo] {
+				seen[algo] = true
+				algorithms = append(algorithms, algo)
+			}
+		}
+	}
+	return algorithms
+}
+
+// existingFiles returns the known_hosts files that exist on disk. MAH's own
+// file comes first, so the key types recorded by mah server trust are
+// negotiated before those of stale entries in the user's file.
+func (v *HostKeyVerifier) existingFiles() []string {
+	var files []string
+	for _, file := range append([]string{v.mahKnownHosts}, v.userKnownHosts...) {
+		if _, err := os.Stat(file); err == nil {
+			files = append(files, file)
+		}
+	}
+	return files
+}
+
+// FetchHostKey connects to a server and returns the host key it presents,
+// without authenticating
+func FetchHostKey(ctx context.Context, cfg *config.Server) (ssh.PublicKey, string, error) {
+	addr := net.JoinHostPort(cfg.Host, strconv.Itoa(sshPort(cfg)))
+
+	var hostKey ssh.PublicKey
+	errCaptured := errors.New("host key captured")
+
+	sshConfig := &ssh.ClientConfig{
+		User: cfg.SSHUser,
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			hostKey = key
+			return errCaptured
+		},
+		Timeout: 30 * time.Second,
+	}
+
+	conn, jump, err := dialServer(ctx, cfg)
+	if err != nil {
+		return nil, addr, err
+	}
+	defer conn.Close()
+	if jump != nil {
+		defer releaseJump(jump)
+	}
+
+	_, _, _, err = ssh.NewClientConn(conn, addr, sshConfig)
+	if hostKey == nil {
+		return nil, addr, fmt.Errorf("failed to read host key from %s: %w", addr, err)
+	}
+
+	return hostKey, addr, nil
+}
+
+// TrustHostKey records key as the only trusted key for a server in the
+// MAH-managed known_hosts file
+func TrustHostKey(cfg *config.Server, key ssh.PublicKey) error {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return fmt.Errorf("failed to get home directory: %w", err)
+	}
+
+	addr := net.JoinHostPort(cfg.Host, strconv.Itoa(sshPort(cfg)))
+	path := MAHKnownHostsPath(home)
+
+	if err := removeHostKeys(path, addr); err != nil {
+		return err
+	}
+	return RecordHostKey(path, addr, key)
+}
+
+// RecordHostKey appends a host key entry to a known_hosts file
+func RecordHostKey(path, addr string, key ssh.PublicKey) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create directory for %s: %w", path, err)
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer f.Close()
+
+	line := knownhosts.Line([]string{knownhosts.Normalize(addr)}, key)
+	if _, err := fmt.Fprintln(f, line); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
+
+// removeHostKeys drops all entries for addr from a known_hosts file
+func removeHostKeys(path, addr string) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	normalized := knownhosts.Normalize(addr)
+	var kept []string
+
+	scanner := bufio.NewScanner(strings.NewReader(string(data)))
+	for scanner.Scan() {
+		line := scanner.Text()
+		fields := strings.Fields(line)
+		if len(fields) > 0 && !strings.HasPrefix(fields[0], "#") {
+			matches := false
+			for _, host := range strings.Split(fields[0], ",") {
+				if host == normalized {
+					matches = true
+					break
+				}
+			}
+			if matches {
+				continue
+			}
+		}
+		kept = append(kept, line)
+	}
+
+	content := strings.Join(kept, "\n")
+	if content != "" {
+		content += "\n"
+	}
+	return os.WriteFile(path, []byte(content), 0600)
+}
+
+// normalizeFingerprint accepts pins with or without the SHA256: prefix
+func normalizeFingerprint(pin string) string {
+	pin = strings.TrimSpace(pin)
+	if !strings.HasPrefix(pin, "SHA256:") {
+		pin = "SHA256:" + pin
+	}
+	return strings.TrimRight(pin, "=")
+}
+
+// algorithmsForKeyType maps a key type to the signature algorithms that use it
+func algorithmsForKeyType(keyType string) []string {
+	switch keyType {
+	case ssh.KeyAlgoRSA:
+		return []string{ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA}
+	default:
+		return []string{keyType}
+	}
+}
+
+// sshPort returns the configured SSH port or the default
+func sshPort(cfg *config.Server) int {
+	if cfg.SSHPort == 0 {
+		return 22
+	}
+	return cfg.SSHPort
+}
+
+// placeholderKey is a key that never matches a known_hosts entry
+type placeholderKey struct{}
+
+func (placeholderKey) Type() string    { return "mah-placeholder" }
+func (placeholderKey) Marshal() []byte { return []byte("mah-placeholder") }
+func (placeholderKey) Verify(data []byte, sig *ssh.Signature) error {
+	return errors.New("placeholder key")
+}
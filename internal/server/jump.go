@@ -0,0 +1,138 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jonas-jonas/mah/internal/config"
+)
+
+// jumpHosts shares bastion connections between all servers reached through
+// them, keyed by user@host:port
+var jumpHosts = struct {
+	sync.Mutex
+	byKey map[string]*jumpHost
+}{byKey: make(map[string]*jumpHost)}
+
+type jumpHost struct {
+	server *SSHServer
+	refs   int
+}
+
+// jumpKey identifies a jump host connection
+func jumpKey(cfg *config.Server) string {
+	return fmt.Sprintf("%s@%s", cfg.SSHUser, net.JoinHostPort(cfg.Host, strconv.Itoa(sshPort(cfg))))
+}
+
+// acquireJump returns a connected jump host, reusing an existing connection
+func acquireJump(ctx context.Context, jump *config.JumpConfig) (*SSHServer, error) {
+	target := jump.Target()
+	if target == nil {
+		return nil, fmt.Errorf("jump host '%s' is not defined", jump.Name())
+	}
+
+	key := jumpKey(target)
+	if existing := reuseJump(key); existing != nil {
+		return existing, nil
+	}
+
+	// Connect without holding the lock, since chained jumps acquire it too
+	srv := NewSSHServer("jump:"+jump.Name(), target)
+	if err := srv.Connect(ctx); err != nil {
+		return nil, fmt.Errorf("failed to connect to jump host '%s': %w", jump.Name(), err)
+	}
+
+	if existing := registerJump(key, srv); existing != srv {
+		// Another server connected to the same jump host in the meantime
+		srv.Disconnect()
+		return existing, nil
+	}
+	return srv, nil
+}
+
+// registerJump records a newly connected jump host, or takes a reference to
+// the one registered concurrently and returns it instead
+func registerJump(key string, srv *SSHServer) *SSHServer {
+	jumpHosts.Lock()
+	defer jumpHosts.Unlock()
+
+	if existing, ok := jumpHosts.byKey[key]; ok && existing.server.isConnected() {
+		existing.refs++
+		return existing.server
+	}
+
+	jumpHosts.byKey[key] = &jumpHost{server: srv, refs: 1}
+	return srv
+}
+
+// reuseJump takes a reference to an already connected jump host
+func reuseJump(key string) *SSHServer {
+	jumpHosts.Lock()
+	defer jumpHosts.Unlock()
+
+	existing, ok := jumpHosts.byKey[key]
+	if !ok || !existing.server.isConnected() {
+		return nil
+	}
+	existing.refs++
+	return existing.server
+}
+
+// releaseJump drops a reference to a jump host and closes it when unused
+func releaseJump(srv *SSHServer) {
+	if dropJumpRef(srv) {
+		srv.Disconnect()
+	}
+}
+
+// dropJumpRef decrements a jump host's references and reports whether the
+// connection should be closed
+func dropJumpRef(srv *SSHServer) bool {
+	jumpHosts.Lock()
+	defer jumpHosts.Unlock()
+
+	key := jumpKey(srv.config)
+	existing, ok := jumpHosts.byKey[key]
+	if !ok || existing.server != srv {
+		return true
+	}
+
+	existing.refs--
+	if existing.refs > 0 {
+		return false
+	}
+	delete(jumpHosts.byKey, key)
+	return true
+}
+
+// dialServer opens a TCP connection to a server's SSH port, tunnelling
+// through its jump host when one is configured. The returned jump host, if
+// any, must be released once the connection is no longer needed.
+func dialServer(ctx context.Context, cfg *config.Server) (net.Conn, *SSHServer, error) {
+	addr := net.JoinHostPort(cfg.Host, strconv.Itoa(sshPort(cfg)))
+
+	if cfg.Jump == nil {
+		dialer := &net.Dialer{Timeout: 30 * time.Second}
+		conn, err := dialer.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to connect to %s: %w", addr, err)
+		}
+		return conn, nil, nil
+	}
+
+	jump, err := acquireJump(ctx, cfg.Jump)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	conn, err := jump.Dial(ctx, "tcp", addr)
+	if err != nil {
+		releaseJump(jump)
+		return nil, nil, fmt.Errorf("failed to connect to %s via jump host '%s': %w", addr, cfg.Jump.Name(), err)
+	}
+	return conn, jump, nil
+}
@@ -0,0 +1,398 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/jonas-jonas/mah/internal/config"
+	"github.com/jonas-jonas/mah/pkg"
+)
+
+// LocalServer implements the pkg.Server interface for the local machine,
+// running commands through os/exec instead of SSH
+type LocalServer struct {
+	config *config.Server
+	id     string
+}
+
+// NewLocalServer creates a new local server instance
+func NewLocalServer(id string, config *config.Server) *LocalServer {
+	return &LocalServer{
+		config: config,
+		id:     id,
+	}
+}
+
+// Connect is a no-op for the local machine
+func (l *LocalServer) Connect(ctx context.Context) error {
+	return nil
+}
+
+// Execute runs a command on the local machine
+func (l *LocalServer) Execute(ctx context.Context, cmd string, sudo bool) (*pkg.Result, error) {
+	var stdout, stderr strings.Builder
+
+	result, err := l.Stream(ctx, cmd, pkg.StreamOptions{
+		Sudo:   sudo,
+		Stdout: &stdout,
+		Stderr: &stderr,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result.Stdout = stdout.String()
+	if stderr.Len() > 0 {
+		result.Stderr = stderr.String()
+	}
+	return result, nil
+}
+
+// Stream runs a command on the local machine, writing its output to the
+// writers in opts as it arrives. Cancelling ctx terminates the command.
+func (l *LocalServer) Stream(ctx context.Context, cmd string, opts pkg.StreamOptions) (*pkg.Result, error) {
+	if opts.Sudo && l.config.CanBecome() {
+		return becomeStream(ctx, l.config, cmd, opts, l.run)
+	}
+	return l.run(ctx, cmd, opts)
+}
+
+// Interactive runs a command attached to the local terminal, or a login
+// shell when cmd is empty
+func (l *LocalServer) Interactive(ctx context.Context, cmd string, opts pkg.StreamOptions) (*pkg.Result, error) {
+	switch {
+	case opts.Sudo && l.config.CanBecome():
+		// Interactive sudo may prompt for a password on the terminal
+		cmd = interactiveBecomeCommand(l.config, cmd)
+	case cmd == "":
+		shell := os.Getenv("SHELL")
+		if shell == "" {
+			shell = "/bin/sh"
+		}
+		cmd = fmt.Sprintf("exec %s -l", ShellQuote(shell))
+	}
+
+	// The terminal delivers Ctrl-C to the command itself; keep it from
+	// terminating mah while the command runs
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	defer signal.Stop(interrupts)
+
+	return l.run(ctx, cmd, opts)
+}
+
+// run executes cmd through the shell
+func (l *LocalServer) run(ctx context.Context, cmd string, opts pkg.StreamOptions) (*pkg.Result, error) {
+	c := exec.CommandContext(ctx, "sh", "-c", cmd)
+	c.Stdin = opts.Stdin
+	c.Stdout = opts.Stdout
+	c.Stderr = opts.Stderr
+
+	// Give cancelled commands a chance to exit before they are killed
+	c.Cancel = func() error {
+		return c.Process.Signal(syscall.SIGTERM)
+	}
+	c.WaitDelay = terminateGrace
+
+	start := time.Now()
+	err := c.Run()
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	result := &pkg.Result{
+		Duration: time.Since(start).Milliseconds(),
+	}
+
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			result.ExitCode = exitErr.ExitCode()
+		} else {
+			result.ExitCode = 1
+			result.Stderr = err.Error()
+		}
+	}
+
+	return result, nil
+}
+
+// TransferFile copies a file to a local path, falling back to sudo for
+// paths the current user cannot write
+func (l *LocalServer) TransferFile(ctx context.Context, local, remote string) error {
+	info, err := os.Stat(local)
+	if err != nil {
+		return fmt.Errorf("failed to stat local file: %w", err)
+	}
+
+	return l.copyIn(ctx, local, remote, info)
+}
+
+// SyncDir copies the files under local that are missing or differ in the
+// target directory
+func (l *LocalServer) SyncDir(ctx context.Context, local, remote string, opts pkg.SyncOptions) (*pkg.SyncResult, error) {
+	localFiles, err := listLocal(local)
+	if err != nil {
+		return nil, err
+	}
+
+	targetEntries, err := listLocalTree(remote)
+	if err != nil {
+		return nil, err
+	}
+
+	upload, candidates := planSync(localFiles, targetEntries, opts.Checksum)
+	for _, rel := range candidates {
+		sum, err := fileChecksum(filepath.Join(local, filepath.FromSlash(rel)))
+		if err != nil {
+			return nil, err
+		}
+
+		// Unreadable targets are simply copied again
+		targetSum, err := fileChecksum(filepath.Join(remote, filepath.FromSlash(rel)))
+		if err != nil || targetSum != sum {
+			upload = append(upload, rel)
+		}
+	}
+	sort.Strings(upload)
+
+	result := &pkg.SyncResult{
+		Unchanged: len(localFiles) - len(upload),
+	}
+
+	for _, rel := range upload {
+		src := filepath.Join(local, filepath.FromSlash(rel))
+		dst := filepath.Join(remote, filepath.FromSlash(rel))
+
+		if err := l.copyIn(ctx, src, dst, localFiles[rel]); err != nil {
+			return result, err
+		}
+		result.Uploaded = append(result.Uploaded, rel)
+	}
+
+	if opts.Delete {
+		for _, rel := range extraEntries(localFiles, targetEntries) {
+			target := filepath.Join(remote, filepath.FromSlash(rel))
+
+			err := os.Remove(target)
+			if err != nil && errors.Is(err, os.ErrPermission) && l.config.CanBecome() {
+				err = removeWithSudo(ctx, l, target)
+			}
+			if err != nil {
+				return result, fmt.Errorf("failed to delete %s: %w", target, err)
+			}
+			result.Deleted = append(result.Deleted, rel)
+		}
+		sort.Strings(result.Deleted)
+	}
+
+	return result, nil
+}
+
+// FetchFile copies a file from a local path, falling back to sudo for files
+// the current user cannot read
+func (l *LocalServer) FetchFile(ctx context.Context, remote, local string) error {
+	info, err := os.Stat(remote)
+	if err != nil {
+		return fmt.Errorf("failed to stat file %s: %w", remote, err)
+	}
+	if info.IsDir() {
+		return fmt.Errorf("path %s is a directory", remote)
+	}
+
+	return l.copyOut(ctx, remote, local, info)
+}
+
+// FetchDir copies a directory tree from a local path
+func (l *LocalServer) FetchDir(ctx context.Context, remote, local string) error {
+	return filepath.WalkDir(remote, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return fmt.Errorf("failed to read directory %s: %w", p, err)
+		}
+
+		rel, err := filepath.Rel(remote, p)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(local, rel)
+
+		switch {
+		case d.IsDir():
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return fmt.Errorf("failed to create directory %s: %w", target, err)
+			}
+		case d.Type().IsRegular():
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			return l.copyOut(ctx, p, target, info)
+		}
+		return nil
+	})
+}
+
+// Disconnect is a no-op for the local machine
+func (l *LocalServer) Disconnect() error {
+	return nil
+}
+
+// Dial opens a connection from the local machine
+func (l *LocalServer) Dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	var dialer net.Dialer
+	return dialer.DialContext(ctx, network, addr)
+}
+
+// Listen opens a listener on the local machine
+func (l *LocalServer) Listen(ctx context.Context, network, addr string) (net.Listener, error) {
+	var lc net.ListenConfig
+	return lc.Listen(ctx, network, addr)
+}
+
+// GetDistro detects the Linux distribution
+func (l *LocalServer) GetDistro(ctx context.Context) (string, error) {
+	return detectDistro(ctx, l)
+}
+
+// GetResources gets resource information for the local machine
+func (l *LocalServer) GetResources(ctx context.Context) (*pkg.ResourceInfo, error) {
+	return gatherResources(ctx, l)
+}
+
+// HealthCheck performs a basic health check
+func (l *LocalServer) HealthCheck(ctx context.Context) error {
+	return checkHealth(ctx, l)
+}
+
+// ID returns the server identifier
+func (l *LocalServer) ID() string {
+	return l.id
+}
+
+// Host returns the configured hostname
+func (l *LocalServer) Host() string {
+	return l.config.Host
+}
+
+// copyIn copies src to dst, preserving mode and modification time, and
+// installs it through sudo when dst is not writable
+func (l *LocalServer) copyIn(ctx context.Context, src, dst string, info os.FileInfo) error {
+	err := copyLocalFile(src, dst, info)
+	if err == nil || !errors.Is(err, os.ErrPermission) || !l.config.CanBecome() {
+		return err
+	}
+
+	return installWithSudo(ctx, l, src, dst, info.Mode())
+}
+
+// copyOut copies src to dst, reading src through sudo when it is not readable
+func (l *LocalServer) copyOut(ctx context.Context, src, dst string, info os.FileInfo) error {
+	err := copyLocalFile(src, dst, info)
+	if err == nil || !errors.Is(err, os.ErrPermission) || !l.config.CanBecome() {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return fmt.Errorf("failed to create file %s: %w", dst, err)
+	}
+	defer out.Close()
+
+	var stderr strings.Builder
+	result, err := l.Stream(ctx, fmt.Sprintf("cat %s", ShellQuote(src)), pkg.StreamOptions{
+		Sudo:   true,
+		Stdout: out,
+		Stderr: &stderr,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read %s with sudo: %w", src, err)
+	}
+	if result.ExitCode != 0 {
+		return fmt.Errorf("failed to read %s with sudo: %s", src, strings.TrimSpace(stderr.String()))
+	}
+
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("failed to write file %s: %w", dst, err)
+	}
+	return os.Chtimes(dst, info.ModTime(), info.ModTime())
+}
+
+// copyLocalFile copies src to dst, preserving mode and modification time
+func copyLocalFile(src, dst string, info os.FileInfo) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to open file %s: %w", src, err)
+	}
+	defer in.Close()
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return fmt.Errorf("failed to create directory %s: %w", filepath.Dir(dst), err)
+	}
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return fmt.Errorf("failed to create file %s: %w", dst, err)
+	}
+	defer out.Close()
+
+	if _, err := io.Copy(out, in); err != nil {
+		return fmt.Errorf("failed to copy file data: %w", err)
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("failed to write file %s: %w", dst, err)
+	}
+
+	// The mode of an existing file is not changed by OpenFile
+	if err := os.Chmod(dst, info.Mode().Perm()); err != nil {
+		return fmt.Errorf("failed to set file permissions: %w", err)
+	}
+	return os.Chtimes(dst, info.ModTime(), info.ModTime())
+}
+
+// listLocalTree returns the files and directories under dir, keyed by
+// slash-separated relative path. A missing directory yields an empty listing.
+func listLocalTree(dir string) (map[string]os.FileInfo, error) {
+	entries := make(map[string]os.FileInfo)
+
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if p == dir && errors.Is(err, os.ErrNotExist) {
+				return filepath.SkipDir
+			}
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, p)
+		if err != nil || rel == "." {
+			return err
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		entries[filepath.ToSlash(rel)] = info
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read directory %s: %w", dir, err)
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/jonas-jonas/mah/pkg"
+)
+
+// DistroOperations provides distribution-specific server operations
+type DistroOperations interface {
+	InstallDocker(ctx context.Context) error
+	ConfigureFirewall(ctx context.Context, rules []pkg.FirewallRule) error
+	UpdateSystem(ctx context.Context) error
+	ConfigureAutomaticUpdates(ctx context.Context) error
+	HardenSSH(ctx context.Context, policy pkg.SSHPolicy) error
+	ConfigureFail2ban(ctx context.Context, policy pkg.Fail2banPolicy) error
+	ConfigureDocker(ctx context.Context, daemon pkg.DockerDaemon) error
+	RebootRequired(ctx context.Context) (bool, error)
+	InstallPackage(ctx context.Context, packageName string) error
+	GetDockerStatus(ctx context.Context) (string, error)
+}
+
+// OperationsConstructor creates operations for a server of a distribution family
+type OperationsConstructor func(server pkg.Server, info *DistroInfo) DistroOperations
+
+var (
+	operationsMu       sync.RWMutex
+	operationsRegistry = map[string]OperationsConstructor{}
+)
+
+func init() {
+	RegisterOperations("debian", newDebianFamilyOperations)
+	RegisterOperations("rhel", func(server pkg.Server, info *DistroInfo) DistroOperations {
+		return NewRockyOperations(server, info)
+	})
+	RegisterOperations("alpine", func(server pkg.Server, info *DistroInfo) DistroOperations {
+		return NewAlpineOperations(server)
+	})
+}
+
+// RegisterOperations registers the operations for a distribution family,
+// replacing any earlier registration
+func RegisterOperations(family string, constructor OperationsConstructor) {
+	operationsMu.Lock()
+	defer operationsMu.Unlock()
+	operationsRegistry[family] = constructor
+}
+
+// RegisteredFamilies returns the distribution families with operations
+func RegisteredFamilies() []string {
+	operationsMu.RLock()
+	defer operationsMu.RUnlock()
+
+	families := make([]string, 0, len(operationsRegistry))
+	for family := range operationsRegistry {
+		families = append(families, family)
+	}
+	sort.Strings(families)
+	return families
+}
+
+// Operations returns the operations for a server running distro
+func (f *ServerFactory) Operations(server pkg.Server, distro string) (DistroOperations, error) {
+	info, err := f.GetDistroInfo(distro)
+	if err != nil {
+		return nil, err
+	}
+
+	operationsMu.RLock()
+	constructor, exists := operationsRegistry[info.Family]
+	operationsMu.RUnlock()
+	if !exists {
+		return nil, fmt.Errorf("no operations for %s (%s family)", info.Name, info.Family)
+	}
+
+	return constructor(server, info), nil
+}
+
+// newDebianFamilyOperations returns Ubuntu or Debian operations, which
+// differ in the Docker repository they use
+func newDebianFamilyOperations(server pkg.Server, info *DistroInfo) DistroOperations {
+	if info.ID == "ubuntu" {
+		return NewUbuntuOperations(server)
+	}
+	return NewDebianOperations(server)
+}
+
+// runWithInput runs cmd as root with input on its stdin. Unlike piping
+// through "sudo tee" this works with every become method, including a sudo
+// password.
+func runWithInput(ctx context.Context, server pkg.Server, cmd, input string) (*pkg.Result, error) {
+	var stdout, stderr strings.Builder
+	result, err := server.Stream(ctx, cmd, pkg.StreamOptions{
+		Sudo:   true,
+		Stdin:  strings.NewReader(input),
+		Stdout: &stdout,
+		Stderr: &stderr,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result.Stdout = stdout.String()
+	result.Stderr = stderr.String()
+	return result, nil
+}
+
+// WriteFile writes content to path on the server as root
+func WriteFile(ctx context.Context, server pkg.Server, path, content string) error {
+	result, err := runWithInput(ctx, server, fmt.Sprintf("tee %s > /dev/null", ShellQuote(path)), content)
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if result.ExitCode != 0 {
+		return fmt.Errorf("writing %s failed: %s", path, result.Stderr)
+	}
+	return nil
+}
@@ -0,0 +1,184 @@
+package server
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jonas-jonas/mah/pkg"
+	"github.com/jonas-jonas/mah/pkg/mahtest"
+)
+
+var testDistros = []string{"ubuntu", "debian", "rocky", "fedora", "alpine"}
+
+// operationsFor returns the registered operations for distro
+func operationsFor(t *testing.T, srv pkg.Server, distro string) DistroOperations {
+	t.Helper()
+	ops, err := NewFactory().Operations(srv, distro)
+	if err != nil {
+		t.Fatalf("Operations(%q) error = %v", distro, err)
+	}
+	return ops
+}
+
+var testSSHPolicy = pkg.SSHPolicy{
+	Port:         2222,
+	AllowUsers:   []string{"deploy", "alice"},
+	MaxAuthTries: 4,
+	TestLogin:    func(ctx context.Context, port int) error { return nil },
+}
+
+var testFail2banPolicy = pkg.Fail2banPolicy{
+	SSHPort:    2222,
+	MaxRetry:   3,
+	IgnoreIPs:  []string{"10.0.0.0/8"},
+	TraefikLog: "/var/log/traefik/access.log",
+}
+
+var testDockerDaemon = pkg.DockerDaemon{
+	LogMaxSize:          "50m",
+	RegistryMirrors:     []string{"https://mirror.gcr.io"},
+	DefaultAddressPools: []pkg.AddressPool{{Base: "172.80.0.0/16", Size: 24}},
+	LiveRestore:         true,
+}
+
+var testFirewallRules = []pkg.FirewallRule{
+	{Port: 22, Protocol: "tcp", Source: "any", Action: "allow", Comment: "SSH"},
+	{Port: 443},
+	{Port: 5432, Protocol: "tcp", Source: "10.0.0.0/8", Action: "allow", Comment: "Postgres"},
+	{Port: 53, Protocol: "udp", Source: "192.168.1.0/24", Action: "deny"},
+	{Port: 51820, Protocol: "tcp/udp"},
+}
+
+// newFakeServer returns a fake server on which nothing is installed yet
+func newFakeServer() *mahtest.Server {
+	return mahtest.NewServer("web-1").
+		OnPrefix("which ", mahtest.Response{ExitCode: 1}).
+		On("which sshd", mahtest.Response{Stdout: "/usr/sbin/sshd\n"}).
+		OnPrefix("cat /etc/", mahtest.Response{Stderr: "No such file or directory\n", ExitCode: 1}).
+		OnPrefix("grep -qE '^[[:space:]]*Include", mahtest.Response{ExitCode: 1}).
+		On("whoami", mahtest.Response{Stdout: "deploy\n"}).
+		On("lsb_release -cs", mahtest.Response{Stdout: "noble\n"}).
+		On("systemctl is-active --quiet ssh.socket", mahtest.Response{ExitCode: 3}).
+		On("docker --version", mahtest.Response{Stdout: "Docker version 27.0.3, build 7d4bcd8\n"})
+}
+
+func TestDistroOperationsGolden(t *testing.T) {
+	operations := []struct {
+		name string
+		run  func(ctx context.Context, ops DistroOperations) error
+	}{
+		{"install_docker", func(ctx context.Context, ops DistroOperations) error {
+			return ops.InstallDocker(ctx)
+		}},
+		{"configure_firewall", func(ctx context.Context, ops DistroOperations) error {
+			return ops.ConfigureFirewall(ctx, testFirewallRules)
+		}},
+		{"harden_ssh", func(ctx context.Context, ops DistroOperations) error {
+			return ops.HardenSSH(ctx, testSSHPolicy)
+		}},
+		{"fail2ban", func(ctx context.Context, ops DistroOperations) error {
+			return ops.ConfigureFail2ban(ctx, testFail2banPolicy)
+		}},
+		{"docker_daemon", func(ctx context.Context, ops DistroOperations) error {
+			return ops.ConfigureDocker(ctx, testDockerDaemon)
+		}},
+		{"automatic_updates", func(ctx context.Context, ops DistroOperations) error {
+			return ops.ConfigureAutomaticUpdates(ctx)
+		}},
+	}
+
+	for _, distro := range testDistros {
+		for _, op := range operations {
+			name := distro + "_" + op.name
+			t.Run(name, func(t *testing.T) {
+				srv := newFakeServer()
+
+				if err := op.run(context.Background(), operationsFor(t, srv, distro)); err != nil {
+					t.Fatalf("%s() error = %v", op.name, err)
+				}
+
+				mahtest.Golden(t, name, srv.Transcript())
+			})
+		}
+	}
+}
+
+func TestInstallDockerSkipsInstalledDocker(t *testing.T) {
+	for _, distro := range testDistros {
+		t.Run(distro, func(t *testing.T) {
+			srv := newFakeServer().On("which docker", mahtest.Response{Stdout: "/usr/bin/docker\n"})
+
+			if err := operationsFor(t, srv, distro).InstallDocker(context.Background()); err != nil {
+				t.Fatalf("InstallDocker() error = %v", err)
+			}
+
+			for _, call := range srv.Calls() {
+				if call.Sudo {
+					t.Errorf("ran %q with sudo, want no changes", call.Cmd)
+				}
+			}
+		})
+	}
+}
+
+func TestInstallDockerReportsFailedInstall(t *testing.T) {
+	for _, distro := range testDistros {
+		t.Run(distro, func(t *testing.T) {
+			srv := newFakeServer().
+				OnPrefix("apt-get install -y docker-ce", mahtest.Response{Stderr: "E: Unable to locate package docker-ce", ExitCode: 100}).
+				OnPrefix("dnf install -y docker-ce", mahtest.Response{Stderr: "Error: Unable to find a match: docker-ce", ExitCode: 1}).
+				OnPrefix("apk add docker", mahtest.Response{Stderr: "ERROR: unable to select packages", ExitCode: 1})
+
+			if err := operationsFor(t, srv, distro).InstallDocker(context.Background()); err == nil {
+				t.Fatal("InstallDocker() succeeded, want error")
+			}
+
+			for _, cmd := range srv.Commands() {
+				if cmd == "sudo systemctl start docker" || cmd == "sudo rc-service docker start" {
+					t.Error("started docker after the install failed")
+				}
+			}
+		})
+	}
+}
+
+func TestAlpineAutomaticUpdatesReportsCrondFailure(t *testing.T) {
+	srv := newFakeServer().On("rc-service crond start", mahtest.Response{Stderr: " * ERROR: crond failed to start\n", ExitCode: 1})
+
+	err := operationsFor(t, srv, "alpine").ConfigureAutomaticUpdates(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "crond failed to start") {
+		t.Fatalf("ConfigureAutomaticUpdates() error = %v, want crond failure", err)
+	}
+}
+
+func TestFactoryOperations(t *testing.T) {
+	tests := []struct {
+		distro string
+		want   DistroOperations
+	}{
+		{"ubuntu", &UbuntuOperations{}},
+		{"Debian", &DebianOperations{}},
+		{"rocky", &RockyOperations{}},
+		{"centos", &RockyOperations{}},
+		{"rhel", &RockyOperations{}},
+		{"fedora", &RockyOperations{}},
+		{"alpine", &AlpineOperations{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.distro, func(t *testing.T) {
+			ops := operationsFor(t, newFakeServer(), tt.distro)
+			if reflect.TypeOf(ops) != reflect.TypeOf(tt.want) {
+				t.Errorf("Operations(%q) = %T, want %T", tt.distro, ops, tt.want)
+			}
+		})
+	}
+
+	for _, distro := range []string{"arch", ""} {
+		if _, err := NewFactory().Operations(newFakeServer(), distro); err == nil {
+			t.Errorf("Operations(%q) succeeded, want error", distro)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jonas-jonas/mah/pkg"
+)
+
+// bootIDFile changes on every boot, which tells a finished reboot apart from
+// a server that has not gone down yet
+const bootIDFile = "/proc/sys/kernel/random/boot_id"
+
+// BootID returns the ID of the server's current boot
+func BootID(ctx context.Context, server pkg.Server) (string, error) {
+	result, err := server.Execute(ctx, "cat "+bootIDFile, false)
+	if err != nil {
+		return "", fmt.Errorf("failed to read boot ID: %w", err)
+	}
+	if result.ExitCode != 0 {
+		return "", fmt.Errorf("reading boot ID failed: %s", errorDetail(result, nil))
+	}
+	return strings.TrimSpace(result.Stdout), nil
+}
+
+// Reboot reboots the server. The reboot starts a moment after the command
+// returns, so the SSH session ends cleanly instead of being cut off.
+func Reboot(ctx context.Context, server pkg.Server) error {
+	if err := runScript(ctx, server, "nohup sh -c 'sleep 2 && reboot' </dev/null >/dev/null 2>&1 &"); err != nil {
+		return fmt.Errorf("failed to reboot: %w", err)
+	}
+	return nil
+}
+
+// WaitForBoot polls the server every interval until it answers over SSH
+// with a boot ID other than previous. Connection errors while the server is
+// down are expected; the last one is returned when ctx ends first.
+func WaitForBoot(ctx context.Context, server pkg.Server, previous string, interval time.Duration) error {
+	var lastErr error
+	for {
+		bootID, err := BootID(ctx, server)
+		switch {
+		case err == nil && bootID != previous:
+			return nil
+		case err == nil:
+			lastErr = fmt.Errorf("server has not gone down yet")
+		default:
+			lastErr = err
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("server did not come back: %w", lastErr)
+		case <-time.After(interval):
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jonas-jonas/mah/pkg/mahtest"
+)
+
+func TestRebootRequired(t *testing.T) {
+	tests := []struct {
+		distro         string
+		cmd            string
+		exitCode       int
+		pluginExitCode int
+		want           bool
+		wantErr        bool
+	}{
+		{distro: "ubuntu", cmd: "test -f /var/run/reboot-required", exitCode: 0, want: true},
+		{distro: "debian", cmd: "test -f /var/run/reboot-required", exitCode: 1, want: false},
+		{distro: "rocky", cmd: "dnf needs-restarting -r", exitCode: 1, want: true},
+		{distro: "rocky", cmd: "dnf needs-restarting -r", exitCode: 0, want: false},
+		{distro: "rocky", cmd: "dnf needs-restarting -r", exitCode: 127, wantErr: true},
+		{distro: "rocky", cmd: "dnf needs-restarting -r", exitCode: 1, pluginExitCode: 1, wantErr: true},
+		{distro: "alpine", cmd: `sh -c 'test -d "/lib/modules/$(uname -r)"'`, exitCode: 1, want: true},
+		{distro: "alpine", cmd: `sh -c 'test -d "/lib/modules/$(uname -r)"'`, exitCode: 0, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.distro, func(t *testing.T) {
+			srv := mahtest.NewServer("web-1").
+				OnPrefix("", mahtest.Response{ExitCode: 99}).
+				On("dnf needs-restarting --help", mahtest.Response{ExitCode: tt.pluginExitCode}).
+				On(tt.cmd, mahtest.Response{ExitCode: tt.exitCode})
+
+			got, err := operationsFor(t, srv, tt.distro).RebootRequired(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("RebootRequired() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("RebootRequired() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWaitForBoot(t *testing.T) {
+	// The server answers with the old boot ID, drops off, then comes back
+	reads := 0
+	readsBootID := func(n int) func(cmd string) bool {
+		return func(cmd string) bool { return cmd == "cat "+bootIDFile && reads == n }
+	}
+	srv := mahtest.NewServer("web-1").
+		On("cat "+bootIDFile, mahtest.Response{Stdout: "new-boot\n"}).
+		OnMatch(readsBootID(2), mahtest.Response{Err: errors.New("connection refused")}).
+		OnMatch(readsBootID(1), mahtest.Response{Stdout: "old-boot\n"}).
+		OnMatch(func(cmd string) bool {
+			// Responders are tried newest first, so this counts every read
+			if cmd == "cat "+bootIDFile {
+				reads++
+			}
+			return false
+		}, mahtest.Response{})
+
+	if err := WaitForBoot(context.Background(), srv, "old-boot", time.Millisecond); err != nil {
+		t.Fatalf("WaitForBoot() error = %v", err)
+	}
+	if reads != 3 {
+		t.Errorf("read the boot ID %d times, want 3", reads)
+	}
+}
+
+func TestWaitForBootTimesOut(t *testing.T) {
+	srv := mahtest.NewServer("web-1").On("cat "+bootIDFile, mahtest.Response{Stdout: "old-boot\n"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := WaitForBoot(ctx, srv, "old-boot", time.Millisecond); err == nil {
+		t.Fatal("WaitForBoot() succeeded, want timeout")
+	}
+}
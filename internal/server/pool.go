@@ -0,0 +1,210 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/jonas-jonas/mah/internal/config"
+	"github.com/jonas-jonas/mah/pkg"
+)
+
+// Pool keeps one connected server per configured server name, so commands
+// issued during a CLI invocation share their SSH connections
+type Pool struct {
+	factory *ServerFactory
+
+	mu      sync.Mutex
+	entries map[string]*poolEntry
+}
+
+type poolEntry struct {
+	mu     sync.Mutex
+	server pkg.Server
+}
+
+// NewPool creates an empty connection pool
+func NewPool(factory *ServerFactory) *Pool {
+	return &Pool{
+		factory: factory,
+		entries: make(map[string]*poolEntry),
+	}
+}
+
+// Get returns the connected server for name, creating and connecting it on
+// first use
+func (p *Pool) Get(ctx context.Context, name string, cfg *config.Server) (pkg.Server, error) {
+	p.mu.Lock()
+	entry, ok := p.entries[name]
+	if !ok {
+		entry = &poolEntry{}
+		p.entries[name] = entry
+	}
+	p.mu.Unlock()
+
+	// Connecting happens under the entry lock only, so different servers
+	// connect in parallel
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+
+	if entry.server == nil {
+		srv, err := p.factory.CreateServer(name, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create server instance for '%s': %w", name, err)
+		}
+		entry.server = srv
+	}
+
+	if err := entry.server.Connect(ctx); err != nil {
+		return nil, fmt.Errorf("failed to connect to server '%s': %w", name, err)
+	}
+
+	return entry.server, nil
+}
+
+// Close disconnects all pooled servers
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	entries := p.entries
+	p.entries = make(map[string]*poolEntry)
+	p.mu.Unlock()
+
+	var lastErr error
+	for name, entry := range entries {
+		entry.mu.Lock()
+		if entry.server != nil {
+			if err := entry.server.Disconnect(); err != nil {
+				lastErr = fmt.Errorf("failed to disconnect from server '%s': %w", name, err)
+			}
+		}
+		entry.mu.Unlock()
+	}
+
+	return lastErr
+}
+
+// Lazy returns a server that uses the pooled connection for name and only
+// connects on first use. Disconnecting it leaves the shared connection open;
+// the pool closes it.
+func (p *Pool) Lazy(name string, cfg *config.Server) pkg.Server {
+	return &lazyServer{pool: p, name: name, cfg: cfg}
+}
+
+// lazyServer forwards every operation to the pooled server
+type lazyServer struct {
+	pool *Pool
+	name string
+	cfg  *config.Server
+}
+
+func (s *lazyServer) get(ctx context.Context) (pkg.Server, error) {
+	return s.pool.Get(ctx, s.name, s.cfg)
+}
+
+func (s *lazyServer) Connect(ctx context.Context) error {
+	_, err := s.get(ctx)
+	return err
+}
+
+func (s *lazyServer) Execute(ctx context.Context, cmd string, sudo bool) (*pkg.Result, error) {
+	srv, err := s.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return srv.Execute(ctx, cmd, sudo)
+}
+
+func (s *lazyServer) Stream(ctx context.Context, cmd string, opts pkg.StreamOptions) (*pkg.Result, error) {
+	srv, err := s.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return srv.Stream(ctx, cmd, opts)
+}
+
+func (s *lazyServer) Interactive(ctx context.Context, cmd string, opts pkg.StreamOptions) (*pkg.Result, error) {
+	srv, err := s.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return srv.Interactive(ctx, cmd, opts)
+}
+
+func (s *lazyServer) TransferFile(ctx context.Context, local, remote string) error {
+	srv, err := s.get(ctx)
+	if err != nil {
+		return err
+	}
+	return srv.TransferFile(ctx, local, remote)
+}
+
+func (s *lazyServer) SyncDir(ctx context.Context, local, remote string, opts pkg.SyncOptions) (*pkg.SyncResult, error) {
+	srv, err := s.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return srv.SyncDir(ctx, local, remote, opts)
+}
+
+func (s *lazyServer) FetchFile(ctx context.Context, remote, local string) error {
+	srv, err := s.get(ctx)
+	if err != nil {
+		return err
+	}
+	return srv.FetchFile(ctx, remote, local)
+}
+
+func (s *lazyServer) FetchDir(ctx context.Context, remote, local string) error {
+	srv, err := s.get(ctx)
+	if err != nil {
+		return err
+	}
+	return srv.FetchDir(ctx, remote, local)
+}
+
+func (s *lazyServer) Dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	srv, err := s.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return srv.Dial(ctx, network, addr)
+}
+
+func (s *lazyServer) Listen(ctx context.Context, network, addr string) (net.Listener, error) {
+	srv, err := s.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return srv.Listen(ctx, network, addr)
+}
+
+func (s *lazyServer) GetDistro(ctx context.Context) (string, error) {
+	srv, err := s.get(ctx)
+	if err != nil {
+		return "", err
+	}
+	return srv.GetDistro(ctx)
+}
+
+func (s *lazyServer) GetResources(ctx context.Context) (*pkg.ResourceInfo, error) {
+	srv, err := s.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return srv.GetResources(ctx)
+}
+
+func (s *lazyServer) HealthCheck(ctx context.Context) error {
+	srv, err := s.get(ctx)
+	if err != nil {
+		return err
+	}
+	return srv.HealthCheck(ctx)
+}
+
+// Disconnect does nothing; the shared connection belongs to the pool
+func (s *lazyServer) Disconnect() error { return nil }
+
+func (s *lazyServer) ID() string   { return s.name }
+func (s *lazyServer) Host() string { return s.cfg.Host }
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/jonas-jonas/mah/internal/config"
+)
+
+func TestPoolLazy(t *testing.T) {
+	pool := NewPool(NewFactory())
+	defer pool.Close()
+
+	cfg := &config.Server{Host: "localhost", Transport: config.TransportLocal}
+	lazy := pool.Lazy("web-1", cfg)
+
+	if got := lazy.ID(); got != "web-1" {
+		t.Errorf("ID() = %q, want the configuration name", got)
+	}
+	if len(pool.entries) != 0 {
+		t.Fatalf("pool has %d entries before first use, want none", len(pool.entries))
+	}
+
+	result, err := lazy.Execute(context.Background(), "echo hello", false)
+	if err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+	if strings.TrimSpace(result.Stdout) != "hello" {
+		t.Errorf("stdout = %q, want hello", result.Stdout)
+	}
+
+	// Both handles share the pooled server, which survives Disconnect
+	if err := pool.Lazy("web-1", cfg).Disconnect(); err != nil {
+		t.Fatalf("Disconnect() error = %v", err)
+	}
+	pooled, err := pool.Get(context.Background(), "web-1", cfg)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if len(pool.entries) != 1 || pool.entries["web-1"].server != pooled {
+		t.Error("lazy server does not share the pooled instance")
+	}
+	if err := lazy.HealthCheck(context.Background()); err != nil {
+		t.Errorf("HealthCheck() after Disconnect error = %v", err)
+	}
+}
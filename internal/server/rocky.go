@@ -3,22 +3,37 @@ package server
 import (
 	"context"
 	"fmt"
+	"os"
 	"strings"
 
 	"github.com/jonas-jonas/mah/pkg"
 )
 
-// RockyOperations provides Rocky Linux-specific server operations
+// RockyOperations provides server operations for the RHEL family: Rocky
+// Linux, CentOS, RHEL and Fedora
 type RockyOperations struct {
 	server pkg.Server
+	id     string // distribution ID, e.g. "fedora"
 }
 
-// NewRockyOperations creates Rocky Linux operations for a server
-func NewRockyOperations(server pkg.Server) *RockyOperations {
-	return &RockyOperations{server: server}
+// NewRockyOperations creates RHEL family operations for a server running
+// the distribution described by info
+func NewRockyOperations(server pkg.Server, info *DistroInfo) *RockyOperations {
+	return &RockyOperations{server: server, id: info.ID}
 }
 
-// InstallDocker installs Docker CE on Rocky Linux
+// dockerRepo returns the Docker CE repository for the distribution. RHEL
+// and Fedora have their own; Rocky and other clones use the CentOS one.
+func (r *RockyOperations) dockerRepo() string {
+	switch r.id {
+	case "rhel", "fedora":
+		return "https://download.docker.com/linux/" + r.id + "/docker-ce.repo"
+	default:
+		return "https://download.docker.com/linux/centos/docker-ce.repo"
+	}
+}
+
+// InstallDocker installs Docker CE on a RHEL family distribution
 func (r *RockyOperations) InstallDocker(ctx context.Context) error {
 	// Check if Docker is already installed
 	result, err := r.server.Execute(ctx, "which docker", false)
@@ -32,6 +47,9 @@ func (r *RockyOperations) InstallDocker(ctx context.Context) error {
 
 	// Install required packages
 	packages := "yum-utils device-mapper-persistent-data lvm2"
+	if r.id == "fedora" {
+		packages = "dnf-plugins-core"
+	}
 	result, err = r.server.Execute(ctx, fmt.Sprintf("dnf install -y %s", packages), true)
 	if err != nil {
 		return fmt.Errorf("failed to install prerequisites: %w", err)
@@ -40,8 +58,13 @@ func (r *RockyOperations) InstallDocker(ctx context.Context) error {
 		return fmt.Errorf("prerequisite installation failed: %s", result.Stderr)
 	}
 
-	// Add Docker CE repository
-	result, err = r.server.Execute(ctx, "dnf config-manager --add-repo https://download.docker.com/linux/centos/docker-ce.repo", true)
+	// Add Docker CE repository; Fedora 41 and later ship dnf5, which
+	// changed the config-manager syntax
+	addRepo := "dnf config-manager --add-repo " + r.dockerRepo()
+	if r.id == "fedora" {
+		addRepo = fmt.Sprintf("sh -c 'dnf config-manager addrepo --from-repofile=%[1]s || dnf config-manager --add-repo %[1]s'", r.dockerRepo())
+	}
+	result, err = r.server.Execute(ctx, addRepo, true)
 	if err != nil {
 		return fmt.Errorf("failed to add Docker repository: %w", err)
 	}
@@ -210,7 +233,7 @@ func (r *RockyOperations) addFirewallRule(ctx context.Context, rule pkg.Firewall
 // UpdateSystem updates Rocky Linux system packages
 func (r *RockyOperations) UpdateSystem(ctx context.Context) error {
 	// Update packages
-	result, err := r.server.Execute(ctx, "dnf upgrade -y", true)
+	result, err := pkg.StreamPrefixed(ctx, r.server, "dnf upgrade -y", true, os.Stdout)
 	if err != nil {
 		return fmt.Errorf("failed to update packages: %w", err)
 	}
@@ -247,14 +270,9 @@ email_to = root
 [base]
 debuglevel = 1`
 
-	// Write configuration using sudo tee to handle permissions properly
-	result, err = r.server.Execute(ctx, fmt.Sprintf("cat << 'EOF' | sudo tee /etc/dnf/automatic.conf > /dev/null\n%s\nEOF", config), false)
-	if err != nil {
+	if err := WriteFile(ctx, r.server, "/etc/dnf/automatic.conf", config+"\n"); err != nil {
 		return fmt.Errorf("failed to configure dnf-automatic: %w", err)
 	}
-	if result.ExitCode != 0 {
-		return fmt.Errorf("dnf-automatic configuration failed: %s", result.Stderr)
-	}
 
 	// Enable and start the timer
 	result, err = r.server.Execute(ctx, "systemctl enable dnf-automatic.timer", true)
@@ -270,51 +288,54 @@ debuglevel = 1`
 	return nil
 }
 
-// HardenSSH applies SSH security hardening
-func (r *RockyOperations) HardenSSH(ctx context.Context) error {
-	// Create backup of SSH config
-	result, err := r.server.Execute(ctx, "cp /etc/ssh/sshd_config /etc/ssh/sshd_config.backup", true)
-	if err != nil {
-		return fmt.Errorf("failed to backup SSH config: %w", err)
-	}
+// HardenSSH applies the SSH policy as a managed sshd drop-in
+func (r *RockyOperations) HardenSSH(ctx context.Context, policy pkg.SSHPolicy) error {
+	return hardenSSH(ctx, r.server, policy, "systemctl restart sshd")
+}
 
-	hardening := []string{
-		"sed -i 's/#PermitRootLogin yes/PermitRootLogin no/' /etc/ssh/sshd_config",
-		"sed -i 's/#PasswordAuthentication yes/PasswordAuthentication no/' /etc/ssh/sshd_config",
-		"sed -i 's/#PubkeyAuthentication yes/PubkeyAuthentication yes/' /etc/ssh/sshd_config",
-		"sed -i 's/#Protocol 2/Protocol 2/' /etc/ssh/sshd_config",
-		"sed -i 's/#X11Forwarding yes/X11Forwarding no/' /etc/ssh/sshd_config",
-	}
+// ConfigureFail2ban installs fail2ban and sets up its jails
+func (r *RockyOperations) ConfigureFail2ban(ctx context.Context, policy pkg.Fail2banPolicy) error {
+	platform := rhelFail2ban
+	switch r.id {
+	case "fedora":
+		platform = fedoraFail2ban
+	case "rhel":
+		// RHEL's own repositories have no epel-release
+		platform.install = "sh -c 'dnf install -y fail2ban || { dnf install -y https://dl.fedoraproject.org/pub/epel/epel-release-latest-$(rpm -E %rhel).noarch.rpm && dnf install -y fail2ban; }'"
+	}
+	return configureFail2ban(ctx, r.server, policy, platform)
+}
 
-	for _, cmd := range hardening {
-		result, err = r.server.Execute(ctx, cmd, true)
-		if err != nil {
-			return fmt.Errorf("failed to apply SSH hardening: %w", err)
-		}
-		if result.ExitCode != 0 {
-			return fmt.Errorf("SSH hardening command failed: %s", result.Stderr)
-		}
-	}
+// ConfigureDocker writes the Docker daemon configuration and restarts Docker
+// when it changed
+func (r *RockyOperations) ConfigureDocker(ctx context.Context, daemon pkg.DockerDaemon) error {
+	return configureDockerDaemon(ctx, r.server, daemon, "systemctl restart docker")
+}
 
-	// Test SSH configuration
-	result, err = r.server.Execute(ctx, "sshd -t", true)
+// RebootRequired reports whether installed updates need a reboot.
+// needs-restarting exits with 1 when they do, but dnf also exits with 1
+// when the plugin is missing, so its presence is checked first.
+func (r *RockyOperations) RebootRequired(ctx context.Context) (bool, error) {
+	result, err := r.server.Execute(ctx, "dnf needs-restarting --help", false)
 	if err != nil {
-		return fmt.Errorf("failed to test SSH configuration: %w", err)
+		return false, fmt.Errorf("failed to check for a pending reboot: %w", err)
 	}
 	if result.ExitCode != 0 {
-		return fmt.Errorf("SSH configuration test failed: %s", result.Stderr)
+		return false, fmt.Errorf("dnf needs-restarting is not available; install dnf-plugins-core")
 	}
 
-	// Restart SSH service
-	result, err = r.server.Execute(ctx, "systemctl restart sshd", true)
+	result, err = r.server.Execute(ctx, "dnf needs-restarting -r", false)
 	if err != nil {
-		return fmt.Errorf("failed to restart SSH service: %w", err)
+		return false, fmt.Errorf("failed to check for a pending reboot: %w", err)
 	}
-	if result.ExitCode != 0 {
-		return fmt.Errorf("SSH service restart failed: %s", result.Stderr)
+	switch result.ExitCode {
+	case 0:
+		return false, nil
+	case 1:
+		return true, nil
+	default:
+		return false, fmt.Errorf("dnf needs-restarting failed: %s", errorDetail(result, nil))
 	}
-
-	return nil
 }
 
 // InstallPackage installs a package using dnf
@@ -0,0 +1,446 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/jonas-jonas/mah/internal/config"
+	"github.com/jonas-jonas/mah/internal/server/sshtest"
+	"github.com/jonas-jonas/mah/pkg"
+)
+
+// newTestServer starts an in-process SSH server and returns a config that
+// reaches it with a pinned host key. HOME is redirected so known_hosts
+// files of the user running the tests are never read or written.
+func newTestServer(t *testing.T) (*sshtest.Server, *config.Server) {
+	t.Helper()
+
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("SSH_AUTH_SOCK", "")
+
+	srv := sshtest.NewServer(t)
+	cfg := &config.Server{
+		Host:    "127.0.0.1",
+		SSHPort: srv.Port(),
+		SSHUser: srv.User,
+		SSHKey:  srv.KeyFile,
+		HostKey: ssh.FingerprintSHA256(srv.HostKey),
+	}
+	return srv, cfg
+}
+
+// connect connects to a test server and disconnects when the test ends
+func connect(t *testing.T, cfg *config.Server) *SSHServer {
+	t.Helper()
+
+	s := NewSSHServer("test", cfg)
+	if err := s.Connect(context.Background()); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	t.Cleanup(func() { s.Disconnect() })
+	return s
+}
+
+func TestConnectErrors(t *testing.T) {
+	otherKey, _ := sshtest.GenerateKey(t)
+
+	tests := []struct {
+		name   string
+		modify func(cfg *config.Server)
+		check  func(t *testing.T, err error)
+	}{
+		{
+			name: "connection refused",
+			modify: func(cfg *config.Server) {
+				listener, err := net.Listen("tcp", "127.0.0.1:0")
+				if err != nil {
+					t.Fatal(err)
+				}
+				cfg.SSHPort = listener.Addr().(*net.TCPAddr).Port
+				listener.Close()
+			},
+		},
+		{
+			name: "pinned host key mismatch",
+			modify: func(cfg *config.Server) {
+				cfg.HostKey = "SHA256:AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA"
+			},
+			check: func(t *testing.T, err error) {
+				var mismatch *HostKeyMismatchError
+				if !errors.As(err, &mismatch) {
+					t.Errorf("error = %v, want HostKeyMismatchError", err)
+				}
+			},
+		},
+		{
+			name: "unknown host key in strict mode",
+			modify: func(cfg *config.Server) {
+				cfg.HostKey = ""
+				cfg.HostKeyCheck = HostKeyCheckStrict
+			},
+			check: func(t *testing.T, err error) {
+				var unknown *HostKeyUnknownError
+				if !errors.As(err, &unknown) {
+					t.Errorf("error = %v, want HostKeyUnknownError", err)
+				}
+			},
+		},
+		{
+			name: "unauthorized key",
+			modify: func(cfg *config.Server) {
+				cfg.SSHKey = otherKey
+			},
+		},
+		{
+			name: "unknown user",
+			modify: func(cfg *config.Server) {
+				cfg.SSHUser = "nobody"
+			},
+		},
+		{
+			name: "missing key file",
+			modify: func(cfg *config.Server) {
+				cfg.SSHKey = filepath.Join(t.TempDir(), "missing")
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, cfg := newTestServer(t)
+			tt.modify(cfg)
+
+			s := NewSSHServer("test", cfg)
+			err := s.Connect(context.Background())
+			if err == nil {
+				s.Disconnect()
+				t.Fatal("Connect() succeeded, want error")
+			}
+			if tt.check != nil {
+				tt.check(t, err)
+			}
+			if s.isConnected() {
+				t.Error("server reports connected after failed Connect()")
+			}
+		})
+	}
+}
+
+func TestConnectRecordsHostKeyOnFirstUse(t *testing.T) {
+	srv, cfg := newTestServer(t)
+	cfg.HostKey = ""
+
+	connect(t, cfg).Disconnect()
+
+	home, _ := os.UserHomeDir()
+	data, err := os.ReadFile(MAHKnownHostsPath(home))
+	if err != nil {
+		t.Fatalf("known_hosts not written: %v", err)
+	}
+	if !strings.Contains(string(data), strings.TrimSpace(string(ssh.MarshalAuthorizedKey(srv.HostKey)))) {
+		t.Errorf("known_hosts = %q, want the server's host key", data)
+	}
+
+	// The recorded key is trusted in strict mode from now on
+	cfg.HostKeyCheck = HostKeyCheckStrict
+	connect(t, cfg)
+}
+
+func TestTrustOverridesStaleUserKnownHosts(t *testing.T) {
+	srv, cfg := newTestServer(t)
+	cfg.HostKey = ""
+	cfg.HostKeyCheck = HostKeyCheckStrict
+
+	// The user's known_hosts holds an old key of the same type
+	_, staleKey := sshtest.GenerateKey(t)
+	home, _ := os.UserHomeDir()
+	userKnownHosts := filepath.Join(home, ".ssh", "known_hosts")
+	addr := net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.SSHPort))
+	if err := RecordHostKey(userKnownHosts, addr, staleKey); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewSSHServer("test", cfg)
+	var mismatch *HostKeyMismatchError
+	if err := s.Connect(context.Background()); !errors.As(err, &mismatch) {
+		s.Disconnect()
+		t.Fatalf("Connect() error = %v, want HostKeyMismatchError", err)
+	}
+
+	if err := TrustHostKey(cfg, srv.HostKey); err != nil {
+		t.Fatalf("TrustHostKey() error = %v", err)
+	}
+	connect(t, cfg)
+}
+
+func TestDialRefusedKeepsConnection(t *testing.T) {
+	_, cfg := newTestServer(t)
+	s := connect(t, cfg)
+	client := s.client
+
+	// The test server refuses every forward, like a closed target port
+	_, err := s.Dial(context.Background(), "tcp", "127.0.0.1:1")
+	var openErr *ssh.OpenChannelError
+	if !errors.As(err, &openErr) {
+		t.Fatalf("Dial() error = %v, want OpenChannelError", err)
+	}
+	if s.client != client {
+		t.Error("refused Dial() replaced the shared connection")
+	}
+
+	if _, err := s.Execute(context.Background(), "true", false); err != nil {
+		t.Errorf("Execute() after refused Dial() error = %v", err)
+	}
+}
+
+func TestExecuteExitCodes(t *testing.T) {
+	tests := []struct {
+		name       string
+		configSudo bool
+		sudo       bool
+		cmd        string
+		handler    sshtest.Handler
+		wantCmd    string
+		wantStdout string
+		wantStderr string
+		wantCode   int
+	}{
+		{
+			name:       "success",
+			cmd:        "echo hello",
+			handler:    sshtest.Reply("hello\n", 0),
+			wantCmd:    "echo hello",
+			wantStdout: "hello\n",
+		},
+		{
+			name:       "failure with stderr",
+			cmd:        "false",
+			handler:    sshtest.Fail("boom\n", 3),
+			wantCmd:    "false",
+			wantStderr: "boom\n",
+			wantCode:   3,
+		},
+		{
+			name:       "unknown command",
+			cmd:        "does-not-exist",
+			wantCmd:    "does-not-exist",
+			wantStderr: "command not found\n",
+			wantCode:   127,
+		},
+		{
+			name:       "sudo",
+			configSudo: true,
+			sudo:       true,
+			cmd:        "whoami",
+			handler:    sshtest.Reply("root\n", 0),
+			wantCmd:    "sudo -n whoami",
+			wantStdout: "root\n",
+		},
+		{
+			name:       "sudo disabled in config",
+			sudo:       true,
+			cmd:        "whoami",
+			handler:    sshtest.Reply("mah\n", 0),
+			wantCmd:    "whoami",
+			wantStdout: "mah\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, cfg := newTestServer(t)
+			cfg.Sudo = tt.configSudo
+			if tt.handler != nil {
+				srv.Handle(tt.wantCmd, tt.handler)
+			}
+
+			result, err := connect(t, cfg).Execute(context.Background(), tt.cmd, tt.sudo)
+			if err != nil {
+				t.Fatalf("Execute() error = %v", err)
+			}
+
+			if got := srv.Commands(); !slices.Equal(got, []string{tt.wantCmd}) {
+				t.Errorf("commands = %q, want %q", got, tt.wantCmd)
+			}
+			if result.ExitCode != tt.wantCode {
+				t.Errorf("ExitCode = %d, want %d", result.ExitCode, tt.wantCode)
+			}
+			if result.Stdout != tt.wantStdout {
+				t.Errorf("Stdout = %q, want %q", result.Stdout, tt.wantStdout)
+			}
+			if result.Stderr != tt.wantStderr {
+				t.Errorf("Stderr = %q, want %q", result.Stderr, tt.wantStderr)
+			}
+		})
+	}
+}
+
+func TestStreamStdin(t *testing.T) {
+	srv, cfg := newTestServer(t)
+	srv.Handle("cat", func(ctx context.Context, cmd string, stdin io.Reader, stdout, stderr io.Writer) int {
+		io.Copy(stdout, stdin)
+		return 0
+	})
+
+	var stdout strings.Builder
+	result, err := connect(t, cfg).Stream(context.Background(), "cat", pkg.StreamOptions{
+		Stdin:  strings.NewReader("line one\nline two\n"),
+		Stdout: &stdout,
+	})
+	if err != nil {
+		t.Fatalf("Stream() error = %v", err)
+	}
+	if result.ExitCode != 0 {
+		t.Errorf("ExitCode = %d, want 0", result.ExitCode)
+	}
+	if stdout.String() != "line one\nline two\n" {
+		t.Errorf("stdout = %q, want stdin echoed back", stdout.String())
+	}
+}
+
+func TestExecuteContextCancellation(t *testing.T) {
+	srv, cfg := newTestServer(t)
+	srv.Handle("sleep 600", sshtest.Block())
+	srv.Handle("true", sshtest.Reply("", 0))
+
+	s := connect(t, cfg)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := s.Execute(ctx, "sleep 600", false)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Execute() error = %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed >= terminateGrace {
+		t.Errorf("Execute() took %v, want the command to stop on SIGTERM", elapsed)
+	}
+	if got := srv.Signals(); !slices.Equal(got, []ssh.Signal{ssh.SIGTERM}) {
+		t.Errorf("signals = %v, want [TERM]", got)
+	}
+
+	// The shared connection survives the cancelled session
+	result, err := s.Execute(context.Background(), "true", false)
+	if err != nil {
+		t.Fatalf("Execute() after cancellation error = %v", err)
+	}
+	if result.ExitCode != 0 {
+		t.Errorf("ExitCode = %d, want 0", result.ExitCode)
+	}
+}
+
+func TestTransferFile(t *testing.T) {
+	_, cfg := newTestServer(t)
+	s := connect(t, cfg)
+
+	local := filepath.Join(t.TempDir(), "app.conf")
+	if err := os.WriteFile(local, []byte("listen = 8080\n"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := os.Chtimes(local, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+
+	// The sftp subsystem serves the local filesystem
+	remote := filepath.Join(t.TempDir(), "opt", "mah", "app.conf")
+	if err := s.TransferFile(context.Background(), local, remote); err != nil {
+		t.Fatalf("TransferFile() error = %v", err)
+	}
+
+	data, err := os.ReadFile(remote)
+	if err != nil {
+		t.Fatalf("remote file not written: %v", err)
+	}
+	if string(data) != "listen = 8080\n" {
+		t.Errorf("remote content = %q", data)
+	}
+
+	info, err := os.Stat(remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0640 {
+		t.Errorf("remote mode = %v, want 0640", info.Mode().Perm())
+	}
+	if !info.ModTime().Equal(modTime) {
+		t.Errorf("remote mtime = %v, want %v", info.ModTime(), modTime)
+	}
+
+	if err := s.TransferFile(context.Background(), filepath.Join(t.TempDir(), "missing"), remote); err == nil {
+		t.Error("TransferFile() of a missing file succeeded, want error")
+	}
+}
+
+func TestGetDistro(t *testing.T) {
+	tests := []struct {
+		name      string
+		osRelease sshtest.Handler
+		files     []string
+		want      string
+	}{
+		{
+			name:      "ubuntu",
+			osRelease: sshtest.Reply("NAME=\"Ubuntu\"\nVERSION_ID=\"22.04\"\nID=ubuntu\nID_LIKE=debian\n", 0),
+			want:      "ubuntu",
+		},
+		{
+			name:      "quoted rocky",
+			osRelease: sshtest.Reply("NAME=\"Rocky Linux\"\nID=\"rocky\"\nID_LIKE=\"rhel centos fedora\"\n", 0),
+			want:      "rocky",
+		},
+		{
+			name:      "uppercase id",
+			osRelease: sshtest.Reply("ID=Debian\n", 0),
+			want:      "debian",
+		},
+		{
+			name:      "release file fallback",
+			osRelease: sshtest.Fail("cat: /etc/os-release: No such file or directory\n", 1),
+			files:     []string{"/etc/alpine-release"},
+			want:      "alpine",
+		},
+		{
+			name:      "os-release without id",
+			osRelease: sshtest.Reply("NAME=\"Mystery\"\n", 0),
+			files:     []string{"/etc/rocky-release"},
+			want:      "rocky",
+		},
+		{
+			name:      "unknown",
+			osRelease: sshtest.Fail("", 1),
+			want:      "unknown",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, cfg := newTestServer(t)
+			srv.Handle("cat /etc/os-release", tt.osRelease)
+			srv.HandlePrefix("test -f ", sshtest.Fail("", 1))
+			for _, file := range tt.files {
+				srv.Handle("test -f "+file, sshtest.Reply("", 0))
+			}
+
+			got, err := connect(t, cfg).GetDistro(context.Background())
+			if err != nil {
+				t.Fatalf("GetDistro() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("GetDistro() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,238 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jonas-jonas/mah/pkg"
+)
+
+const (
+	sshdConfig     = "/etc/ssh/sshd_config"
+	sshdDropInName = "00-mah-hardening.conf"
+	sshdDropIn     = "/etc/ssh/sshd_config.d/" + sshdDropInName
+	sshdBackupDir  = "/etc/ssh/mah-backup"
+
+	// sshdInclude loads drop-ins; sshd keeps the first value it reads for
+	// most options, so it has to come before anything else in sshd_config
+	sshdInclude = "Include /etc/ssh/sshd_config.d/*.conf"
+
+	// loginTestTimeout bounds the fresh login after sshd restarts, so a
+	// port that is silently dropped fails instead of hanging
+	loginTestTimeout = 30 * time.Second
+)
+
+// defaultSSHCiphers are the ciphers allowed when a policy names none
+var defaultSSHCiphers = []string{
+	"chacha20-poly1305@openssh.com",
+	"aes256-gcm@openssh.com",
+	"aes128-gcm@openssh.com",
+	"aes256-ctr",
+	"aes192-ctr",
+	"aes128-ctr",
+}
+
+// hardenSSH applies policy as a drop-in managed by mah and restarts sshd
+// with restartCmd. The previous configuration is backed up first and
+// restored when sshd rejects the new one or the fresh login test fails.
+// Nothing is changed or restarted when the drop-in is already in place.
+func hardenSSH(ctx context.Context, server pkg.Server, policy pkg.SSHPolicy, restartCmd string) error {
+	dropIn := sshdDropInContent(policy)
+
+	// Check what is already in place
+	result, err := server.Execute(ctx, "cat "+sshdDropIn, false)
+	current := err == nil && result.ExitCode == 0 && result.Stdout == dropIn
+
+	result, err = server.Execute(ctx, fmt.Sprintf(`grep -qE '^[[:space:]]*Include[[:space:]]+/etc/ssh/sshd_config\.d/\*\.conf' %s`, sshdConfig), false)
+	hasInclude := err == nil && result.ExitCode == 0
+
+	// Port is cumulative in sshd_config, so a port set there would stay open
+	// next to the one from the drop-in
+	hasPort := false
+	if policy.Port != 0 {
+		result, err = server.Execute(ctx, fmt.Sprintf("grep -qE '^[[:space:]]*Port[[:space:]]' %s", sshdConfig), false)
+		hasPort = err == nil && result.ExitCode == 0
+	}
+
+	if current && hasInclude && !hasPort {
+		return nil
+	}
+
+	// Back up the current configuration
+	backup := fmt.Sprintf("rm -rf %[1]s && mkdir -p %[1]s && cp -p %[2]s %[1]s/ && { [ ! -f %[3]s ] || cp -p %[3]s %[1]s/; }",
+		sshdBackupDir, sshdConfig, sshdDropIn)
+	if err := runScript(ctx, server, backup); err != nil {
+		return fmt.Errorf("failed to backup SSH config: %w", err)
+	}
+
+	if !hasInclude {
+		include := fmt.Sprintf("mkdir -p /etc/ssh/sshd_config.d && sed -i '1i %s' %s", sshdInclude, sshdConfig)
+		if err := runScript(ctx, server, include); err != nil {
+			return fmt.Errorf("failed to include SSH drop-ins: %w", err)
+		}
+	}
+
+	if hasPort {
+		result, err = server.Execute(ctx, fmt.Sprintf("sed -i -E 's/^[[:space:]]*Port[[:space:]]/#&/' %s", sshdConfig), true)
+		if err != nil || result.ExitCode != 0 {
+			return rollbackSSH(ctx, server, "", fmt.Errorf("failed to disable Port in %s: %s", sshdConfig, errorDetail(result, err)))
+		}
+	}
+
+	if err := WriteFile(ctx, server, sshdDropIn, dropIn); err != nil {
+		return rollbackSSH(ctx, server, "", err)
+	}
+
+	// Test SSH configuration
+	result, err = server.Execute(ctx, "sshd -t", true)
+	if err != nil || result.ExitCode != 0 {
+		return rollbackSSH(ctx, server, "", fmt.Errorf("SSH configuration test failed: %s", errorDetail(result, err)))
+	}
+
+	// Restart SSH service; sessions that are already open stay up
+	result, err = server.Execute(ctx, restartCmd, true)
+	if err != nil || result.ExitCode != 0 {
+		return rollbackSSH(ctx, server, restartCmd, fmt.Errorf("SSH service restart failed: %s", errorDetail(result, err)))
+	}
+
+	// Make sure a new login still works before giving up this session
+	if policy.TestLogin != nil {
+		if err := policy.TestLogin(ctx, policy.Port); err != nil {
+			return rollbackSSH(ctx, server, restartCmd, fmt.Errorf("fresh SSH login failed: %w", err))
+		}
+	}
+
+	return nil
+}
+
+// systemdSSHRestart returns the command that makes sshd pick up a new
+// configuration on systemd. Where ssh.socket is active (Ubuntu 22.10 and
+// later) the socket owns the listening port, so its units are regenerated
+// from sshd_config and the socket is restarted instead of sshd.
+func systemdSSHRestart(ctx context.Context, server pkg.Server) string {
+	result, err := server.Execute(ctx, "systemctl is-active --quiet ssh.socket", false)
+	if err == nil && result.ExitCode == 0 {
+		return "sh -c 'systemctl daemon-reload && systemctl restart ssh.socket'"
+	}
+	return "systemctl restart sshd"
+}
+
+// rollbackSSH restores the backed up configuration and restarts sshd when
+// restartCmd is set. It returns cause, noting whether the rollback worked.
+func rollbackSSH(ctx context.Context, server pkg.Server, restartCmd string, cause error) error {
+	restore := fmt.Sprintf("cp -p %[1]s/sshd_config %[2]s && if [ -f %[1]s/%[4]s ]; then cp -p %[1]s/%[4]s %[3]s; else rm -f %[3]s; fi",
+		sshdBackupDir, sshdConfig, sshdDropIn, sshdDropInName)
+	if err := runScript(ctx, server, restore); err != nil {
+		return fmt.Errorf("%w (restoring the previous SSH config also failed: %v)", cause, err)
+	}
+
+	if restartCmd != "" {
+		result, err := server.Execute(ctx, restartCmd, true)
+		if err != nil || result.ExitCode != 0 {
+			return fmt.Errorf("%w (restored the previous SSH config, but restarting sshd failed: %s)", cause, errorDetail(result, err))
+		}
+	}
+
+	return fmt.Errorf("%w (restored the previous SSH config)", cause)
+}
+
+// sshdDropInContent renders policy as sshd configuration
+func sshdDropInContent(policy pkg.SSHPolicy) string {
+	permitRootLogin := policy.PermitRootLogin
+	if permitRootLogin == "" {
+		permitRootLogin = "no"
+	}
+	passwordAuthentication := "no"
+	if policy.PasswordAuthentication {
+		passwordAuthentication = "yes"
+	}
+	maxAuthTries := policy.MaxAuthTries
+	if maxAuthTries == 0 {
+		maxAuthTries = 3
+	}
+	ciphers := policy.Ciphers
+	if len(ciphers) == 0 {
+		ciphers = defaultSSHCiphers
+	}
+
+	var b strings.Builder
+	b.WriteString("# Managed by mah; changes are overwritten by mah server init\n")
+	if policy.Port != 0 {
+		fmt.Fprintf(&b, "Port %d\n", policy.Port)
+	}
+	fmt.Fprintf(&b, "PermitRootLogin %s\n", permitRootLogin)
+	fmt.Fprintf(&b, "PasswordAuthentication %s\n", passwordAuthentication)
+	b.WriteString("PubkeyAuthentication yes\n")
+	b.WriteString("X11Forwarding no\n")
+	fmt.Fprintf(&b, "MaxAuthTries %d\n", maxAuthTries)
+	fmt.Fprintf(&b, "Ciphers %s\n", strings.Join(ciphers, ","))
+	if len(policy.AllowUsers) > 0 {
+		fmt.Fprintf(&b, "AllowUsers %s\n", strings.Join(policy.AllowUsers, " "))
+	}
+	return b.String()
+}
+
+// runScript runs a shell snippet as root
+func runScript(ctx context.Context, server pkg.Server, script string) error {
+	result, err := server.Execute(ctx, "sh -c "+ShellQuote(script), true)
+	if err != nil {
+		return err
+	}
+	if result.ExitCode != 0 {
+		return errors.New(errorDetail(result, nil))
+	}
+	return nil
+}
+
+// errorDetail describes why a command failed
+func errorDetail(result *pkg.Result, err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	if stderr := strings.TrimSpace(result.Stderr); stderr != "" {
+		return stderr
+	}
+	return fmt.Sprintf("exit code %d", result.ExitCode)
+}
+
+// CheckLogin opens a new SSH connection to srv, separate from its own, and
+// runs a command. A non-zero port overrides the configured one. The new
+// connection only accepts the host key srv already verified, so a port that
+// known_hosts has no entry for tests the login rather than known_hosts.
+func CheckLogin(ctx context.Context, srv pkg.Server, port int) error {
+	live, ok := srv.(*SSHServer)
+	if !ok {
+		return fmt.Errorf("server '%s' is not reached over SSH", srv.ID())
+	}
+	hostKey := live.HostKey()
+	if hostKey == nil {
+		return fmt.Errorf("server '%s' has no verified host key", srv.ID())
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, loginTestTimeout)
+	defer cancel()
+
+	login := *live.config
+	if port != 0 {
+		login.SSHPort = port
+	}
+
+	fresh := NewSSHServer(live.id, &login)
+	fresh.fixedKey = hostKey
+	if err := fresh.Connect(ctx); err != nil {
+		return err
+	}
+	defer fresh.Disconnect()
+
+	result, err := fresh.Execute(ctx, "true", false)
+	if err != nil {
+		return err
+	}
+	if result.ExitCode != 0 {
+		return fmt.Errorf("login shell failed: %s", errorDetail(result, nil))
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/jonas-jonas/mah/internal/server/sshtest"
+	"github.com/jonas-jonas/mah/pkg"
+	"github.com/jonas-jonas/mah/pkg/mahtest"
+)
+
+func TestHardenSSHSkipsCurrentConfig(t *testing.T) {
+	logins := 0
+	policy := pkg.SSHPolicy{
+		Port: 2222,
+		TestLogin: func(ctx context.Context, port int) error {
+			logins++
+			return nil
+		},
+	}
+
+	srv := mahtest.NewServer("web-1").
+		On("cat "+sshdDropIn, mahtest.Response{Stdout: sshdDropInContent(policy)}).
+		OnPrefix("grep -qE '^[[:space:]]*Port", mahtest.Response{ExitCode: 1})
+
+	if err := hardenSSH(context.Background(), srv, policy, "systemctl restart sshd"); err != nil {
+		t.Fatalf("hardenSSH() error = %v", err)
+	}
+
+	for _, call := range srv.Calls() {
+		if call.Sudo {
+			t.Errorf("ran %q with sudo, want no changes", call.Cmd)
+		}
+	}
+	if logins != 0 {
+		t.Errorf("tested %d logins, want none without a restart", logins)
+	}
+}
+
+func TestHardenSSHRollsBack(t *testing.T) {
+	tests := []struct {
+		name        string
+		sshdTest    mahtest.Response
+		loginErr    error
+		wantRestart int
+	}{
+		{
+			name:     "invalid config",
+			sshdTest: mahtest.Response{Stderr: "Bad configuration option: Ciphers\n", ExitCode: 255},
+		},
+		{
+			name:        "failed login",
+			loginErr:    errors.New("connection refused"),
+			wantRestart: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newFakeServer().On("sshd -t", tt.sshdTest)
+			policy := pkg.SSHPolicy{
+				Port:      2222,
+				TestLogin: func(ctx context.Context, port int) error { return tt.loginErr },
+			}
+
+			err := hardenSSH(context.Background(), srv, policy, "systemctl restart sshd")
+			if err == nil || !strings.Contains(err.Error(), "restored the previous SSH config") {
+				t.Fatalf("hardenSSH() error = %v, want rollback", err)
+			}
+
+			restarts, restored := 0, false
+			for _, cmd := range srv.Commands() {
+				switch {
+				case cmd == "sudo systemctl restart sshd":
+					restarts++
+				case strings.HasPrefix(cmd, "sudo sh -c 'cp -p "+sshdBackupDir+"/sshd_config "):
+					restored = true
+				}
+			}
+			if !restored {
+				t.Error("previous config not restored")
+			}
+			if restarts != tt.wantRestart {
+				t.Errorf("restarted sshd %d times, want %d", restarts, tt.wantRestart)
+			}
+		})
+	}
+}
+
+func TestHardenSSHRestartsSocket(t *testing.T) {
+	tests := []struct {
+		name        string
+		socket      mahtest.Response
+		wantRestart string
+	}{
+		{
+			name:        "socket activated",
+			wantRestart: "sudo sh -c 'systemctl daemon-reload && systemctl restart ssh.socket'",
+		},
+		{
+			name:        "sshd service",
+			socket:      mahtest.Response{ExitCode: 3},
+			wantRestart: "sudo systemctl restart sshd",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newFakeServer().On("systemctl is-active --quiet ssh.socket", tt.socket)
+
+			if err := NewUbuntuOperations(srv).HardenSSH(context.Background(), testSSHPolicy); err != nil {
+				t.Fatalf("HardenSSH() error = %v", err)
+			}
+
+			commands := srv.Commands()
+			if got := commands[len(commands)-1]; got != tt.wantRestart {
+				t.Errorf("restarted with %q, want %q", got, tt.wantRestart)
+			}
+		})
+	}
+}
+
+func TestCheckLogin(t *testing.T) {
+	srv, cfg := newTestServer(t)
+	srv.Handle("true", sshtest.Reply("", 0))
+	live := connect(t, cfg)
+
+	// The configured port is wrong; the policy's port is used instead
+	port := srv.Port()
+	cfg.SSHPort = closedPort(t)
+	if err := CheckLogin(context.Background(), live, port); err != nil {
+		t.Fatalf("CheckLogin() error = %v", err)
+	}
+	if got := srv.Commands(); len(got) != 1 || got[0] != "true" {
+		t.Errorf("commands = %q, want [\"true\"]", got)
+	}
+
+	if err := CheckLogin(context.Background(), live, 0); err == nil {
+		t.Error("CheckLogin() on a closed port succeeded, want error")
+	}
+}
+
+func TestCheckLoginPinsVerifiedHostKey(t *testing.T) {
+	srv, cfg := newTestServer(t)
+	srv.Handle("true", sshtest.Reply("", 0))
+	live := connect(t, cfg)
+
+	// Nothing is recorded for the new port, which strict mode would reject
+	cfg.HostKey = ""
+	cfg.HostKeyCheck = HostKeyCheckStrict
+	if err := CheckLogin(context.Background(), live, srv.Port()); err != nil {
+		t.Fatalf("CheckLogin() error = %v", err)
+	}
+
+	// A different key on the new port is never accepted
+	_, otherKey := sshtest.GenerateKey(t)
+	live.hostKey = otherKey
+	if err := CheckLogin(context.Background(), live, srv.Port()); err == nil {
+		t.Error("CheckLogin() accepted a different host key, want error")
+	}
+}
+
+// closedPort returns a local port nothing listens on
+func closedPort(t *testing.T) int {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+	return port
+}
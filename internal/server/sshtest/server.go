@@ -0,0 +1,402 @@
+// Package sshtest provides an in-process SSH server for tests. Commands are
+// answered by scriptable handlers, and the sftp subsystem serves the local
+// filesystem.
+package sshtest
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// Handler runs a command. It reads stdin, writes to stdout and stderr and
+// returns the exit status. ctx is cancelled when the client sends a signal
+// or closes the session.
+type Handler func(ctx context.Context, cmd string, stdin io.Reader, stdout, stderr io.Writer) int
+
+// Reply returns a handler that prints stdout and exits with code
+func Reply(stdout string, code int) Handler {
+	return func(ctx context.Context, cmd string, stdin io.Reader, out, errOut io.Writer) int {
+		io.WriteString(out, stdout)
+		return code
+	}
+}
+
+// Fail returns a handler that prints stderr and exits with code
+func Fail(stderr string, code int) Handler {
+	return func(ctx context.Context, cmd string, stdin io.Reader, out, errOut io.Writer) int {
+		io.WriteString(errOut, stderr)
+		return code
+	}
+}
+
+// Block returns a handler that runs until the session is cancelled
+func Block() Handler {
+	return func(ctx context.Context, cmd string, stdin io.Reader, out, errOut io.Writer) int {
+		<-ctx.Done()
+		return 130
+	}
+}
+
+// Server is an in-process SSH server listening on the loopback interface
+type Server struct {
+	// Addr is the host:port the server listens on
+	Addr string
+
+	// HostKey is the server's host key
+	HostKey ssh.PublicKey
+
+	// User is the user name clients must authenticate as
+	User string
+
+	// KeyFile is the path of a private key file accepted by the server
+	KeyFile string
+
+	listener   net.Listener
+	hostSigner ssh.Signer
+
+	mu         sync.Mutex
+	authorized [][]byte
+	exact      map[string]Handler
+	prefixes   []prefixHandler
+	fallback   Handler
+	commands   []string
+	signals    []ssh.Signal
+	conns      []net.Conn
+	wg         sync.WaitGroup
+}
+
+type prefixHandler struct {
+	prefix  string
+	handler Handler
+}
+
+// NewServer starts a server that is closed when the test ends. It accepts
+// the key in KeyFile for User; unknown commands exit with status 127.
+func NewServer(t testing.TB) *Server {
+	t.Helper()
+
+	_, hostKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate host key: %v", err)
+	}
+	hostSigner, err := ssh.NewSignerFromKey(hostKey)
+	if err != nil {
+		t.Fatalf("failed to create host signer: %v", err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	s := &Server{
+		Addr:       listener.Addr().String(),
+		HostKey:    hostSigner.PublicKey(),
+		User:       "mah",
+		listener:   listener,
+		hostSigner: hostSigner,
+		exact:      make(map[string]Handler),
+		fallback:   Fail("command not found\n", 127),
+	}
+
+	// Generate the client key the server accepts
+	keyFile, key := GenerateKey(t)
+	s.KeyFile = keyFile
+	s.Authorize(key)
+
+	s.wg.Add(1)
+	go s.serve()
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+// Port returns the port the server listens on
+func (s *Server) Port() int {
+	_, port, _ := net.SplitHostPort(s.Addr)
+	n, _ := strconv.Atoi(port)
+	return n
+}
+
+// Authorize adds a public key the server accepts
+func (s *Server) Authorize(key ssh.PublicKey) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.authorized = append(s.authorized, key.Marshal())
+}
+
+// Handle registers a handler for an exact command line
+func (s *Server) Handle(cmd string, handler Handler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.exact[cmd] = handler
+}
+
+// HandlePrefix registers a handler for commands starting with prefix. The
+// longest matching prefix wins.
+func (s *Server) HandlePrefix(prefix string, handler Handler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prefixes = append(s.prefixes, prefixHandler{prefix: prefix, handler: handler})
+}
+
+// HandleDefault sets the handler for commands without a registered handler
+func (s *Server) HandleDefault(handler Handler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fallback = handler
+}
+
+// Commands returns the command lines executed so far
+func (s *Server) Commands() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.commands...)
+}
+
+// Signals returns the signals received so far
+func (s *Server) Signals() []ssh.Signal {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]ssh.Signal(nil), s.signals...)
+}
+
+// Close stops the server and drops all client connections
+func (s *Server) Close() {
+	s.listener.Close()
+
+	s.mu.Lock()
+	for _, conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+}
+
+// handler finds the handler for a command
+func (s *Server) handler(cmd string) Handler {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.commands = append(s.commands, cmd)
+
+	if h, ok := s.exact[cmd]; ok {
+		return h
+	}
+
+	var best *prefixHandler
+	for i, p := range s.prefixes {
+		if strings.HasPrefix(cmd, p.prefix) && (best == nil || len(p.prefix) > len(best.prefix)) {
+			best = &s.prefixes[i]
+		}
+	}
+	if best != nil {
+		return best.handler
+	}
+	return s.fallback
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(meta ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+
+			if meta.User() != s.User {
+				return nil, fmt.Errorf("unknown user %s", meta.User())
+			}
+			for _, authorized := range s.authorized {
+				if bytes.Equal(authorized, key.Marshal()) {
+					return nil, nil
+				}
+			}
+			return nil, fmt.Errorf("unknown key for %s", meta.User())
+		},
+	}
+	config.AddHostKey(s.hostSigner)
+
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		s.conns = append(s.conns, conn)
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.handleConn(conn, config)
+		}()
+	}
+}
+
+func (s *Server) handleConn(conn net.Conn, config *ssh.ServerConfig) {
+	defer conn.Close()
+
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "only sessions are supported")
+			continue
+		}
+
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.handleSession(channel, requests)
+		}()
+	}
+}
+
+func (s *Server) handleSession(channel ssh.Channel, requests <-chan *ssh.Request) {
+	defer channel.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := make(chan struct{})
+	started := false
+
+	for {
+		var req *ssh.Request
+		var ok bool
+
+		select {
+		case req, ok = <-requests:
+		case <-done:
+			return
+		}
+		if !ok {
+			return
+		}
+
+		switch req.Type {
+		case "exec", "shell":
+			var cmd string
+			if req.Type == "exec" {
+				cmd = parseString(req.Payload)
+			}
+			if started {
+				req.Reply(false, nil)
+				continue
+			}
+			started = true
+			req.Reply(true, nil)
+
+			handler := s.handler(cmd)
+			go func() {
+				defer close(done)
+				code := handler(ctx, cmd, channel, channel, channel.Stderr())
+				status := make([]byte, 4)
+				binary.BigEndian.PutUint32(status, uint32(code))
+				channel.SendRequest("exit-status", false, status)
+			}()
+
+		case "subsystem":
+			if parseString(req.Payload) != "sftp" || started {
+				req.Reply(false, nil)
+				continue
+			}
+			started = true
+			req.Reply(true, nil)
+
+			go func() {
+				defer close(done)
+				server, err := sftp.NewServer(channel)
+				if err != nil {
+					return
+				}
+				server.Serve()
+				server.Close()
+			}()
+
+		case "signal":
+			s.mu.Lock()
+			s.signals = append(s.signals, ssh.Signal(parseString(req.Payload)))
+			s.mu.Unlock()
+			cancel()
+			if req.WantReply {
+				req.Reply(true, nil)
+			}
+
+		default:
+			// pty-req, env, window-change and similar are accepted as no-ops
+			if req.WantReply {
+				req.Reply(true, nil)
+			}
+		}
+	}
+}
+
+// parseString decodes an SSH string from the start of a request payload
+func parseString(payload []byte) string {
+	if len(payload) < 4 {
+		return ""
+	}
+	n := binary.BigEndian.Uint32(payload)
+	if int(n) > len(payload)-4 {
+		return ""
+	}
+	return string(payload[4 : 4+n])
+}
+
+// GenerateKey writes a new ed25519 private key in OpenSSH format to a temp
+// file and returns its path and public key
+func GenerateKey(t testing.TB) (string, ssh.PublicKey) {
+	t.Helper()
+
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate client key: %v", err)
+	}
+	sshPub, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatalf("failed to convert client key: %v", err)
+	}
+
+	block, err := ssh.MarshalPrivateKey(key, "")
+	if err != nil {
+		t.Fatalf("failed to marshal client key: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "id_ed25519")
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatalf("failed to write client key: %v", err)
+	}
+	return path, sshPub
+}
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/term"
+
+	"github.com/jonas-jonas/mah/pkg"
+)
+
+// Interactive runs a command on the remote server attached to a
+// pseudo-terminal, or a login shell when cmd is empty. When opts.Stdin is a
+// terminal it is switched to raw mode and window size changes are forwarded.
+func (s *SSHServer) Interactive(ctx context.Context, cmd string, opts pkg.StreamOptions) (*pkg.Result, error) {
+	session, release, err := s.newSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	defer session.Close()
+
+	// Interactive sudo may prompt for a password on the terminal
+	if opts.Sudo && s.config.CanBecome() {
+		cmd = interactiveBecomeCommand(s.config, cmd)
+	}
+
+	width, height := 80, 24
+	fd, isTerminal := terminalFd(opts.Stdin)
+	if isTerminal {
+		if w, h, err := term.GetSize(fd); err == nil {
+			width, height = w, h
+		}
+	}
+
+	termType := os.Getenv("TERM")
+	if termType == "" {
+		termType = "xterm-256color"
+	}
+
+	modes := ssh.TerminalModes{
+		ssh.ECHO:          1,
+		ssh.TTY_OP_ISPEED: 14400,
+		ssh.TTY_OP_OSPEED: 14400,
+	}
+	if err := session.RequestPty(termType, height, width, modes); err != nil {
+		return nil, fmt.Errorf("failed to request pseudo-terminal: %w", err)
+	}
+
+	session.Stdout = opts.Stdout
+	session.Stderr = opts.Stderr
+
+	if opts.Stdin != nil {
+		stdin, err := session.StdinPipe()
+		if err != nil {
+			return nil, fmt.Errorf("failed to open stdin: %w", err)
+		}
+		go func() {
+			io.Copy(stdin, opts.Stdin)
+			stdin.Close()
+		}()
+	}
+
+	if isTerminal {
+		oldState, err := term.MakeRaw(fd)
+		if err != nil {
+			return nil, fmt.Errorf("failed to set terminal to raw mode: %w", err)
+		}
+		defer term.Restore(fd, oldState)
+
+		stopResize := watchWindowSize(fd, session)
+		defer stopResize()
+	}
+
+	start := time.Now()
+
+	if cmd == "" {
+		err = session.Shell()
+	} else {
+		err = session.Start(cmd)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to start command: %w", err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- session.Wait()
+	}()
+
+	select {
+	case <-ctx.Done():
+		terminateSession(session, done)
+		return nil, ctx.Err()
+	case err := <-done:
+		return sessionResult(err, start), nil
+	}
+}
+
+// terminalFd returns the file descriptor of r if it is a terminal
+func terminalFd(r io.Reader) (int, bool) {
+	f, ok := r.(*os.File)
+	if !ok || !term.IsTerminal(int(f.Fd())) {
+		return 0, false
+	}
+	return int(f.Fd()), true
+}
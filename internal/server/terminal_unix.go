@@ -0,0 +1,38 @@
+//go:build !windows
+
+package server
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/term"
+)
+
+// watchWindowSize forwards terminal size changes to the session until the
+// returned stop func is called
+func watchWindowSize(fd int, session *ssh.Session) func() {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGWINCH)
+	done := make(chan struct{})
+
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-sigs:
+				if w, h, err := term.GetSize(fd); err == nil {
+					session.WindowChange(h, w)
+				}
+			}
+		}
+	}()
+
+	return func() {
+		signal.Stop(sigs)
+		close(done)
+	}
+}
@@ -0,0 +1,40 @@
+//go:build windows
+
+package server
+
+import (
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/term"
+)
+
+// watchWindowSize forwards terminal size changes to the session until the
+// returned stop func is called. Windows has no SIGWINCH, so the size is polled.
+func watchWindowSize(fd int, session *ssh.Session) func() {
+	done := make(chan struct{})
+
+	go func() {
+		ticker := time.NewTicker(500 * time.Millisecond)
+		defer ticker.Stop()
+
+		width, height, _ := term.GetSize(fd)
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				w, h, err := term.GetSize(fd)
+				if err != nil || (w == width && h == height) {
+					continue
+				}
+				width, height = w, h
+				session.WindowChange(h, w)
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+	}
+}
@@ -0,0 +1,498 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/sftp"
+
+	"github.com/jonas-jonas/mah/pkg"
+)
+
+// checksumBatchSize limits the number of files hashed per remote command
+const checksumBatchSize = 100
+
+// SyncDir uploads the files under local that are missing or differ on the
+// remote side. Files are compared by size and modification time, or by
+// content when opts.Checksum is set.
+func (s *SSHServer) SyncDir(ctx context.Context, local, remote string, opts pkg.SyncOptions) (*pkg.SyncResult, error) {
+	sftpClient, release, err := s.newSFTPClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	defer sftpClient.Close()
+
+	remote = path.Clean(remote)
+	localFiles, err := listLocal(local)
+	if err != nil {
+		return nil, err
+	}
+
+	remoteEntries, err := listRemote(sftpClient, remote)
+	if err != nil {
+		return nil, err
+	}
+
+	upload, candidates := planSync(localFiles, remoteEntries, opts.Checksum)
+	if len(candidates) > 0 {
+		changed, err := s.changedByChecksum(ctx, local, remote, candidates)
+		if err != nil {
+			return nil, err
+		}
+		upload = append(upload, changed...)
+	}
+	sort.Strings(upload)
+
+	result := &pkg.SyncResult{
+		Unchanged: len(localFiles) - len(upload),
+	}
+
+	for _, rel := range upload {
+		localPath := filepath.Join(local, filepath.FromSlash(rel))
+		remotePath := path.Join(remote, rel)
+
+		if err := s.uploadFile(ctx, sftpClient, localPath, remotePath, localFiles[rel]); err != nil {
+			return result, err
+		}
+		result.Uploaded = append(result.Uploaded, rel)
+	}
+
+	if opts.Delete {
+		deleted, err := s.deleteExtra(ctx, sftpClient, remote, localFiles, remoteEntries)
+		result.Deleted = deleted
+		if err != nil {
+			return result, err
+		}
+	}
+
+	return result, nil
+}
+
+// FetchFile downloads a file from the remote server, falling back to sudo
+// for files the login user cannot read
+func (s *SSHServer) FetchFile(ctx context.Context, remote, local string) error {
+	sftpClient, release, err := s.newSFTPClient(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+	defer sftpClient.Close()
+
+	info, err := sftpClient.Stat(remote)
+	if err != nil {
+		return fmt.Errorf("failed to stat remote file %s: %w", remote, err)
+	}
+	if info.IsDir() {
+		return fmt.Errorf("remote path %s is a directory", remote)
+	}
+
+	return s.downloadFile(ctx, sftpClient, remote, local, info)
+}
+
+// FetchDir downloads a directory tree from the remote server
+func (s *SSHServer) FetchDir(ctx context.Context, remote, local string) error {
+	sftpClient, release, err := s.newSFTPClient(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+	defer sftpClient.Close()
+
+	remote = path.Clean(remote)
+	walker := sftpClient.Walk(remote)
+	for walker.Step() {
+		if err := walker.Err(); err != nil {
+			return fmt.Errorf("failed to read remote directory %s: %w", walker.Path(), err)
+		}
+
+		rel := strings.TrimPrefix(strings.TrimPrefix(walker.Path(), remote), "/")
+		localPath := filepath.Join(local, filepath.FromSlash(rel))
+		info := walker.Stat()
+
+		switch {
+		case info.IsDir():
+			if err := os.MkdirAll(localPath, 0755); err != nil {
+				return fmt.Errorf("failed to create local directory %s: %w", localPath, err)
+			}
+		case info.Mode().IsRegular():
+			if err := s.downloadFile(ctx, sftpClient, walker.Path(), localPath, info); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// uploadFile writes a local file to the remote path, preserving its mode and
+// modification time. Paths the login user cannot write are written through
+// sudo when it is enabled for the server.
+func (s *SSHServer) uploadFile(ctx context.Context, client *sftp.Client, local, remote string, info os.FileInfo) error {
+	err := writeRemoteFile(client, local, remote, info)
+	if err == nil || !isPermissionError(err) || !s.config.CanBecome() {
+		return err
+	}
+
+	// Stage the file in /tmp and move it into place as root
+	staging, err := stagingPath()
+	if err != nil {
+		return err
+	}
+	defer client.Remove(staging)
+
+	if err := writeRemoteFile(client, local, staging, info); err != nil {
+		return err
+	}
+
+	return installWithSudo(ctx, s, staging, remote, info.Mode())
+}
+
+// downloadFile copies a remote file to the local path, preserving its mode
+// and modification time
+func (s *SSHServer) downloadFile(ctx context.Context, client *sftp.Client, remote, local string, info os.FileInfo) error {
+	if err := os.MkdirAll(filepath.Dir(local), 0755); err != nil {
+		return fmt.Errorf("failed to create local directory: %w", err)
+	}
+
+	localFile, err := os.OpenFile(local, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return fmt.Errorf("failed to create local file %s: %w", local, err)
+	}
+	defer localFile.Close()
+
+	remoteFile, err := client.Open(remote)
+	if err == nil {
+		defer remoteFile.Close()
+		if _, err := io.Copy(localFile, remoteFile); err != nil {
+			return fmt.Errorf("failed to download %s: %w", remote, err)
+		}
+	} else {
+		if !isPermissionError(err) || !s.config.CanBecome() {
+			return fmt.Errorf("failed to open remote file %s: %w", remote, err)
+		}
+
+		// Read the file through sudo instead
+		var stderr strings.Builder
+		result, err := s.Stream(ctx, fmt.Sprintf("cat %s", ShellQuote(remote)), pkg.StreamOptions{
+			Sudo:   true,
+			Stdout: localFile,
+			Stderr: &stderr,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to download %s with sudo: %w", remote, err)
+		}
+		if result.ExitCode != 0 {
+			return fmt.Errorf("failed to download %s with sudo: %s", remote, strings.TrimSpace(stderr.String()))
+		}
+	}
+
+	if err := localFile.Close(); err != nil {
+		return fmt.Errorf("failed to write local file %s: %w", local, err)
+	}
+	return os.Chtimes(local, info.ModTime(), info.ModTime())
+}
+
+// changedByChecksum returns the files whose content differs between the
+// local and remote directories
+func (s *SSHServer) changedByChecksum(ctx context.Context, local, remote string, files []string) ([]string, error) {
+	var changed []string
+
+	for start := 0; start < len(files); start += checksumBatchSize {
+		end := start + checksumBatchSize
+		if end > len(files) {
+			end = len(files)
+		}
+		batch := files[start:end]
+
+		remoteSums, err := s.remoteChecksums(ctx, remote, batch)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, rel := range batch {
+			sum, err := fileChecksum(filepath.Join(local, filepath.FromSlash(rel)))
+			if err != nil {
+				return nil, err
+			}
+			if remoteSums[rel] != sum {
+				changed = append(changed, rel)
+			}
+		}
+	}
+
+	return changed, nil
+}
+
+// remoteChecksums returns the SHA-256 sums of files relative to dir
+func (s *SSHServer) remoteChecksums(ctx context.Context, dir string, files []string) (map[string]string, error) {
+	quoted := make([]string, len(files))
+	for i, rel := range files {
+		quoted[i] = ShellQuote(rel)
+	}
+
+	cmd := fmt.Sprintf("sh -c %s", ShellQuote(fmt.Sprintf("cd %s && sha256sum -- %s",
+		ShellQuote(dir), strings.Join(quoted, " "))))
+
+	result, err := s.Execute(ctx, cmd, s.config.CanBecome())
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute remote checksums: %w", err)
+	}
+
+	// Unreadable files are simply missing from the output and get uploaded
+	sums := make(map[string]string)
+	scanner := bufio.NewScanner(strings.NewReader(result.Stdout))
+	for scanner.Scan() {
+		sum, name, ok := strings.Cut(scanner.Text(), "  ")
+		if ok {
+			sums[name] = sum
+		}
+	}
+
+	return sums, nil
+}
+
+// deleteExtra removes remote files and directories that do not exist
+// locally, returning the removed paths
+func (s *SSHServer) deleteExtra(ctx context.Context, client *sftp.Client, remote string, localFiles map[string]os.FileInfo, remoteEntries map[string]os.FileInfo) ([]string, error) {
+	var deleted []string
+	for _, rel := range extraEntries(localFiles, remoteEntries) {
+		remotePath := path.Join(remote, rel)
+
+		err := client.Remove(remotePath)
+		if err != nil && isPermissionError(err) && s.config.CanBecome() {
+			err = removeWithSudo(ctx, s, remotePath)
+		}
+		if err != nil {
+			return deleted, fmt.Errorf("failed to delete remote path %s: %w", remotePath, err)
+		}
+		deleted = append(deleted, rel)
+	}
+
+	sort.Strings(deleted)
+	return deleted, nil
+}
+
+// planSync decides which files need uploading. Files whose size matches are
+// returned as checksum candidates when comparing by content, otherwise they
+// are compared by modification time truncated to the whole seconds SFTP keeps.
+func planSync(localFiles, remoteEntries map[string]os.FileInfo, checksum bool) (upload, candidates []string) {
+	for rel, info := range localFiles {
+		remoteInfo, ok := remoteEntries[rel]
+		switch {
+		case !ok || remoteInfo.IsDir() || remoteInfo.Size() != info.Size():
+			upload = append(upload, rel)
+		case checksum:
+			candidates = append(candidates, rel)
+		case !remoteInfo.ModTime().Truncate(time.Second).Equal(info.ModTime().Truncate(time.Second)):
+			upload = append(upload, rel)
+		}
+	}
+	return upload, candidates
+}
+
+// extraEntries returns the remote entries that do not exist locally,
+// ordered so that children come before their directories
+func extraEntries(localFiles, remoteEntries map[string]os.FileInfo) []string {
+	// Local directories are implied by the files inside them
+	localDirs := make(map[string]bool)
+	for rel := range localFiles {
+		for dir := path.Dir(rel); dir != "."; dir = path.Dir(dir) {
+			localDirs[dir] = true
+		}
+	}
+
+	var extra []string
+	for rel, info := range remoteEntries {
+		if info.IsDir() && !localDirs[rel] {
+			extra = append(extra, rel)
+		} else if !info.IsDir() && localFiles[rel] == nil {
+			extra = append(extra, rel)
+		}
+	}
+
+	sort.Sort(sort.Reverse(sort.StringSlice(extra)))
+	return extra
+}
+
+// installWithSudo copies src to dst as root, keeping the given mode and the
+// modification time of src
+func installWithSudo(ctx context.Context, srv pkg.Server, src, dst string, mode os.FileMode) error {
+	cmd := fmt.Sprintf("sh -c %s", ShellQuote(fmt.Sprintf("mkdir -p %s && install -m %04o %s %s && touch -r %s %s",
+		ShellQuote(path.Dir(dst)), mode.Perm(),
+		ShellQuote(src), ShellQuote(dst),
+		ShellQuote(src), ShellQuote(dst))))
+
+	result, err := srv.Execute(ctx, cmd, true)
+	if err != nil {
+		return fmt.Errorf("failed to install %s with sudo: %w", dst, err)
+	}
+	if result.ExitCode != 0 {
+		return fmt.Errorf("failed to install %s with sudo: %s", dst, strings.TrimSpace(result.Stderr))
+	}
+
+	return nil
+}
+
+// removeWithSudo removes a file or empty directory as root
+func removeWithSudo(ctx context.Context, srv pkg.Server, target string) error {
+	cmd := fmt.Sprintf("sh -c %s", ShellQuote(fmt.Sprintf("if [ -d %s ]; then rmdir %s; else rm -f %s; fi",
+		ShellQuote(target), ShellQuote(target), ShellQuote(target))))
+
+	result, err := srv.Execute(ctx, cmd, true)
+	if err != nil {
+		return err
+	}
+	if result.ExitCode != 0 {
+		return errors.New(strings.TrimSpace(result.Stderr))
+	}
+	return nil
+}
+
+// writeRemoteFile copies a local file to remote over SFTP
+func writeRemoteFile(client *sftp.Client, local, remote string, info os.FileInfo) error {
+	localFile, err := os.Open(local)
+	if err != nil {
+		return fmt.Errorf("failed to open local file %s: %w", local, err)
+	}
+	defer localFile.Close()
+
+	// Create remote directory if needed
+	remoteDir := path.Dir(remote)
+	if remoteDir != "." {
+		if err := client.MkdirAll(remoteDir); err != nil {
+			return fmt.Errorf("failed to create remote directory %s: %w", remoteDir, err)
+		}
+	}
+
+	remoteFile, err := client.Create(remote)
+	if err != nil {
+		return fmt.Errorf("failed to create remote file %s: %w", remote, err)
+	}
+	defer remoteFile.Close()
+
+	if _, err := io.Copy(remoteFile, localFile); err != nil {
+		return fmt.Errorf("failed to copy file data: %w", err)
+	}
+
+	if err := client.Chmod(remote, info.Mode().Perm()); err != nil {
+		return fmt.Errorf("failed to set file permissions: %w", err)
+	}
+
+	if err := client.Chtimes(remote, info.ModTime(), info.ModTime()); err != nil {
+		return fmt.Errorf("failed to set file modification time: %w", err)
+	}
+
+	return nil
+}
+
+// listLocal returns the regular files under dir, keyed by slash-separated
+// relative path
+func listLocal(dir string) (map[string]os.FileInfo, error) {
+	files := make(map[string]os.FileInfo)
+
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil // directories are created as needed, symlinks are skipped
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(rel)] = info
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read local directory %s: %w", dir, err)
+	}
+
+	return files, nil
+}
+
+// listRemote returns the files and directories under dir, keyed by
+// relative path. A missing directory yields an empty listing.
+func listRemote(client *sftp.Client, dir string) (map[string]os.FileInfo, error) {
+	entries := make(map[string]os.FileInfo)
+
+	if _, err := client.Stat(dir); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return entries, nil
+		}
+		return nil, fmt.Errorf("failed to stat remote directory %s: %w", dir, err)
+	}
+
+	walker := client.Walk(dir)
+	for walker.Step() {
+		if err := walker.Err(); err != nil {
+			return nil, fmt.Errorf("failed to read remote directory %s: %w", walker.Path(), err)
+		}
+
+		rel := strings.TrimPrefix(strings.TrimPrefix(walker.Path(), dir), "/")
+		if rel == "" {
+			continue
+		}
+		entries[rel] = walker.Stat()
+	}
+
+	return entries, nil
+}
+
+// fileChecksum returns the hex SHA-256 sum of a local file
+func fileChecksum(name string) (string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", fmt.Errorf("failed to open local file %s: %w", name, err)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("failed to read local file %s: %w", name, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// stagingPath returns a unique temporary path for sudo-backed uploads
+func stagingPath() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate staging file name: %w", err)
+	}
+	return "/tmp/.mah-upload-" + hex.EncodeToString(b), nil
+}
+
+// isPermissionError reports whether an SFTP error means access was denied
+func isPermissionError(err error) bool {
+	var status *sftp.StatusError
+	if errors.As(err, &status) {
+		return status.FxCode() == sftp.ErrSSHFxPermissionDenied
+	}
+	return errors.Is(err, os.ErrPermission)
+}
+
+// ShellQuote quotes s for use as a single POSIX shell word
+func ShellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -3,6 +3,7 @@ package server
 import (
 	"context"
 	"fmt"
+	"os"
 	"strings"
 
 	"github.com/jonas-jonas/mah/pkg"
@@ -59,26 +60,25 @@ func (u *UbuntuOperations) InstallDocker(ctx context.Context) error {
 		return fmt.Errorf("Docker GPG key download failed: %s", result.Stderr)
 	}
 	
-	// Then, install it with proper sudo permissions
-	result, err = u.server.Execute(ctx, "gpg --dearmor < /tmp/docker.gpg | sudo tee /usr/share/keyrings/docker-archive-keyring.gpg > /dev/null", false)
-	if err != nil {
+	// Then, install it as root
+	if err := runScript(ctx, u.server, "gpg --dearmor --yes -o /usr/share/keyrings/docker-archive-keyring.gpg /tmp/docker.gpg"); err != nil {
 		return fmt.Errorf("failed to install Docker GPG key: %w", err)
 	}
-	if result.ExitCode != 0 {
-		return fmt.Errorf("Docker GPG key installation failed: %s", result.Stderr)
-	}
 	
 	// Clean up temporary file
 	u.server.Execute(ctx, "rm -f /tmp/docker.gpg", false)
 
-	// Add Docker repository
-	cmd := `echo "deb [arch=amd64 signed-by=/usr/share/keyrings/docker-archive-keyring.gpg] https://download.docker.com/linux/ubuntu $(lsb_release -cs) stable" | sudo tee /etc/apt/sources.list.d/docker.list > /dev/null`
-	result, err = u.server.Execute(ctx, cmd, false)
+	// Add Docker repository for this release
+	result, err = u.server.Execute(ctx, "lsb_release -cs", false)
 	if err != nil {
-		return fmt.Errorf("failed to add Docker repository: %w", err)
+		return fmt.Errorf("failed to detect release codename: %w", err)
 	}
 	if result.ExitCode != 0 {
-		return fmt.Errorf("Docker repository setup failed: %s", result.Stderr)
+		return fmt.Errorf("release codename detection failed: %s", result.Stderr)
+	}
+	repo := fmt.Sprintf("deb [arch=amd64 signed-by=/usr/share/keyrings/docker-archive-keyring.gpg] https://download.docker.com/linux/ubuntu %s stable\n", strings.TrimSpace(result.Stdout))
+	if err := WriteFile(ctx, u.server, "/etc/apt/sources.list.d/docker.list", repo); err != nil {
+		return fmt.Errorf("failed to add Docker repository: %w", err)
 	}
 
 	// Update package index again
@@ -226,7 +226,7 @@ func (u *UbuntuOperations) addFirewallRule(ctx context.Context, rule pkg.Firewal
 // UpdateSystem updates Ubuntu system packages
 func (u *UbuntuOperations) UpdateSystem(ctx context.Context) error {
 	// Update package lists
-	result, err := u.server.Execute(ctx, "apt-get update", true)
+	result, err := pkg.StreamPrefixed(ctx, u.server, "apt-get update", true, os.Stdout)
 	if err != nil {
 		return fmt.Errorf("failed to update package lists: %w", err)
 	}
@@ -235,7 +235,7 @@ func (u *UbuntuOperations) UpdateSystem(ctx context.Context) error {
 	}
 
 	// Upgrade packages
-	result, err = u.server.Execute(ctx, "DEBIAN_FRONTEND=noninteractive apt-get upgrade -y", true)
+	result, err = pkg.StreamPrefixed(ctx, u.server, "DEBIAN_FRONTEND=noninteractive apt-get upgrade -y", true, os.Stdout)
 	if err != nil {
 		return fmt.Errorf("failed to upgrade packages: %w", err)
 	}
@@ -277,51 +277,30 @@ func (u *UbuntuOperations) ConfigureAutomaticUpdates(ctx context.Context) error
 	return nil
 }
 
-// HardenSSH applies SSH security hardening
-func (u *UbuntuOperations) HardenSSH(ctx context.Context) error {
-	// Create backup of SSH config
-	result, err := u.server.Execute(ctx, "cp /etc/ssh/sshd_config /etc/ssh/sshd_config.backup", true)
-	if err != nil {
-		return fmt.Errorf("failed to backup SSH config: %w", err)
-	}
-
-	hardening := []string{
-		"sed -i 's/#PermitRootLogin yes/PermitRootLogin no/' /etc/ssh/sshd_config",
-		"sed -i 's/#PasswordAuthentication yes/PasswordAuthentication no/' /etc/ssh/sshd_config",
-		"sed -i 's/#PubkeyAuthentication yes/PubkeyAuthentication yes/' /etc/ssh/sshd_config",
-		"sed -i 's/#Protocol 2/Protocol 2/' /etc/ssh/sshd_config",
-		"sed -i 's/#X11Forwarding yes/X11Forwarding no/' /etc/ssh/sshd_config",
-	}
+// HardenSSH applies the SSH policy as a managed sshd drop-in
+func (u *UbuntuOperations) HardenSSH(ctx context.Context, policy pkg.SSHPolicy) error {
+	return hardenSSH(ctx, u.server, policy, systemdSSHRestart(ctx, u.server))
+}
 
-	for _, cmd := range hardening {
-		result, err = u.server.Execute(ctx, cmd, true)
-		if err != nil {
-			return fmt.Errorf("failed to apply SSH hardening: %w", err)
-		}
-		if result.ExitCode != 0 {
-			return fmt.Errorf("SSH hardening command failed: %s", result.Stderr)
-		}
-	}
+// ConfigureFail2ban installs fail2ban and sets up its jails
+func (u *UbuntuOperations) ConfigureFail2ban(ctx context.Context, policy pkg.Fail2banPolicy) error {
+	return configureFail2ban(ctx, u.server, policy, debianFail2ban)
+}
 
-	// Test SSH configuration
-	result, err = u.server.Execute(ctx, "sshd -t", true)
-	if err != nil {
-		return fmt.Errorf("failed to test SSH configuration: %w", err)
-	}
-	if result.ExitCode != 0 {
-		return fmt.Errorf("SSH configuration test failed: %s", result.Stderr)
-	}
+// ConfigureDocker writes the Docker daemon configuration and restarts Docker
+// when it changed
+func (u *UbuntuOperations) ConfigureDocker(ctx context.Context, daemon pkg.DockerDaemon) error {
+	return configureDockerDaemon(ctx, u.server, daemon, "systemctl restart docker")
+}
 
-	// Restart SSH service
-	result, err = u.server.Execute(ctx, "systemctl restart sshd", true)
+// RebootRequired reports whether installed updates need a reboot, as flagged
+// by /var/run/reboot-required
+func (u *UbuntuOperations) RebootRequired(ctx context.Context) (bool, error) {
+	result, err := u.server.Execute(ctx, "test -f /var/run/reboot-required", false)
 	if err != nil {
-		return fmt.Errorf("failed to restart SSH service: %w", err)
-	}
-	if result.ExitCode != 0 {
-		return fmt.Errorf("SSH service restart failed: %s", result.Stderr)
+		return false, fmt.Errorf("failed to check for a pending reboot: %w", err)
 	}
-
-	return nil
+	return result.ExitCode == 0, nil
 }
 
 // InstallPackage installs a package using apt
@@ -0,0 +1,192 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/jonas-jonas/mah/pkg"
+)
+
+// teamGroup holds the accounts managed by mah; accounts outside it are never
+// removed
+const teamGroup = "mah-users"
+
+// authorizedKeysHeader starts every authorized_keys file written by mah
+const authorizedKeysHeader = "# Managed by mah; changes are overwritten by mah server users sync\n"
+
+// accountName matches the account names accepted by ApplyUserChange
+var accountName = regexp.MustCompile(`^[a-z_][a-z0-9_-]{0,31}$`)
+
+// ListTeamUsers returns the accounts managed by mah on a server, with the
+// keys in their authorized_keys
+func ListTeamUsers(ctx context.Context, server pkg.Server) ([]pkg.UserAccount, error) {
+	script := fmt.Sprintf(`for user in $(getent group %s | cut -d: -f4 | tr ',' ' '); do
+	echo "@@user $user"
+	cat "$(getent passwd "$user" | cut -d: -f6)/.ssh/authorized_keys" 2>/dev/null
+	echo
+done`, teamGroup)
+
+	result, err := server.Execute(ctx, "sh -c "+ShellQuote(script), true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list team users: %w", err)
+	}
+	if result.ExitCode != 0 {
+		return nil, fmt.Errorf("listing team users failed: %s", errorDetail(result, nil))
+	}
+
+	return parseTeamUsers(result.Stdout), nil
+}
+
+// parseTeamUsers parses the accounts and keys printed by ListTeamUsers
+func parseTeamUsers(output string) []pkg.UserAccount {
+	var accounts []pkg.UserAccount
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if name, ok := strings.CutPrefix(line, "@@user "); ok {
+			accounts = append(accounts, pkg.UserAccount{Name: strings.TrimSpace(name)})
+			continue
+		}
+		if line == "" || strings.HasPrefix(line, "#") || len(accounts) == 0 {
+			continue
+		}
+		account := &accounts[len(accounts)-1]
+		account.Keys = append(account.Keys, line)
+	}
+	return accounts
+}
+
+// PlanUsers returns the changes that turn the current team accounts into
+// the desired ones, sorted by account name
+func PlanUsers(desired, current []pkg.UserAccount) []pkg.UserChange {
+	existing := make(map[string]pkg.UserAccount, len(current))
+	for _, account := range current {
+		existing[account.Name] = account
+	}
+
+	var changes []pkg.UserChange
+	for _, account := range desired {
+		have, ok := existing[account.Name]
+		delete(existing, account.Name)
+		if !ok {
+			changes = append(changes, pkg.UserChange{Action: pkg.UserCreate, Account: account, AddKeys: account.Keys})
+			continue
+		}
+
+		add := missingKeys(account.Keys, have.Keys)
+		remove := missingKeys(have.Keys, account.Keys)
+		if len(add) > 0 || len(remove) > 0 {
+			changes = append(changes, pkg.UserChange{Action: pkg.UserUpdate, Account: account, AddKeys: add, RemoveKeys: remove})
+		}
+	}
+
+	for _, account := range existing {
+		changes = append(changes, pkg.UserChange{Action: pkg.UserRemove, Account: account, RemoveKeys: account.Keys})
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Account.Name < changes[j].Account.Name
+	})
+	return changes
+}
+
+// missingKeys returns the keys in keys that are not in other
+func missingKeys(keys, other []string) []string {
+	have := make(map[string]bool, len(other))
+	for _, key := range other {
+		have[strings.TrimSpace(key)] = true
+	}
+
+	var missing []string
+	for _, key := range keys {
+		if !have[strings.TrimSpace(key)] {
+			missing = append(missing, key)
+		}
+	}
+	return missing
+}
+
+// ApplyUserChange creates, updates or removes a team account. Creating an
+// account that already exists adopts it into the mah-users group; removed
+// accounts keep their home directory.
+func ApplyUserChange(ctx context.Context, server pkg.Server, change pkg.UserChange) error {
+	name := change.Account.Name
+	if !accountName.MatchString(name) || name == "root" {
+		return fmt.Errorf("invalid account name: %s", name)
+	}
+
+	switch change.Action {
+	case pkg.UserCreate:
+		if err := createAccount(ctx, server, change.Account); err != nil {
+			return fmt.Errorf("failed to create account %s: %w", name, err)
+		}
+		return writeAuthorizedKeys(ctx, server, change.Account)
+
+	case pkg.UserUpdate:
+		return writeAuthorizedKeys(ctx, server, change.Account)
+
+	case pkg.UserRemove:
+		remove := fmt.Sprintf("if command -v userdel >/dev/null; then userdel %[1]s; else deluser %[1]s; fi", name)
+		if err := runScript(ctx, server, remove); err != nil {
+			return fmt.Errorf("failed to remove account %s: %w", name, err)
+		}
+		return nil
+
+	default:
+		return fmt.Errorf("unknown user change: %s", change.Action)
+	}
+}
+
+// createAccount adds the account to the mah-users group, creating both when
+// they do not exist. New accounts get a password that cannot be used but
+// does not lock them, since sshd refuses locked accounts without PAM.
+func createAccount(ctx context.Context, server pkg.Server, account pkg.UserAccount) error {
+	useradd, adduser := "useradd -m", "adduser -D"
+	if account.Shell != "" {
+		useradd += " -s " + account.Shell
+		adduser += " -s " + account.Shell
+	}
+
+	// useradd and friends come from shadow; Alpine only has busybox
+	script := fmt.Sprintf(`set -e
+if ! getent group %[1]s >/dev/null; then
+	if command -v groupadd >/dev/null; then groupadd %[1]s; else addgroup %[1]s; fi
+fi
+if id %[2]s >/dev/null 2>&1; then
+	if command -v usermod >/dev/null; then usermod -aG %[1]s %[2]s; else addgroup %[2]s %[1]s; fi
+else
+	if command -v useradd >/dev/null; then %[3]s -G %[1]s %[2]s; else %[4]s %[2]s && addgroup %[2]s %[1]s; fi
+	echo '%[2]s:*' | chpasswd -e
+fi`, teamGroup, account.Name, useradd, adduser)
+
+	return runScript(ctx, server, script)
+}
+
+// writeAuthorizedKeys replaces the authorized_keys of an account with its
+// configured keys
+func writeAuthorizedKeys(ctx context.Context, server pkg.Server, account pkg.UserAccount) error {
+	script := fmt.Sprintf(`set -e
+home=$(getent passwd %[1]s | cut -d: -f6)
+mkdir -p "$home/.ssh"
+cat > "$home/.ssh/authorized_keys"
+chown -R %[1]s:"$(id -gn %[1]s)" "$home/.ssh"
+chmod 700 "$home/.ssh"
+chmod 600 "$home/.ssh/authorized_keys"`, account.Name)
+
+	var b strings.Builder
+	b.WriteString(authorizedKeysHeader)
+	for _, key := range account.Keys {
+		b.WriteString(strings.TrimSpace(key) + "\n")
+	}
+
+	result, err := runWithInput(ctx, server, "sh -c "+ShellQuote(script), b.String())
+	if err != nil {
+		return fmt.Errorf("failed to write authorized_keys for %s: %w", account.Name, err)
+	}
+	if result.ExitCode != 0 {
+		return fmt.Errorf("writing authorized_keys for %s failed: %s", account.Name, errorDetail(result, nil))
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package server
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jonas-jonas/mah/pkg"
+	"github.com/jonas-jonas/mah/pkg/mahtest"
+)
+
+const (
+	aliceKey = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIOMqqnkVzrm0SdG6UOoqKLsabgH5C9okWi0dh2l9GKJl alice@laptop"
+	aliceOld = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIBm7BjbTi1uGWNgS5Eu6X7ak1UFBHn8uN3e4LvFmvwHA alice@old"
+	bobKey   = "ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAABAQC7 bob@desk"
+)
+
+func TestListTeamUsers(t *testing.T) {
+	output := "@@user alice\n" + authorizedKeysHeader + aliceKey + "\n\n" + aliceOld + "\n" +
+		"@@user bob\n\n" // no authorized_keys
+	srv := mahtest.NewServer("web-1").OnPrefix("sh -c ", mahtest.Response{Stdout: output})
+
+	accounts, err := ListTeamUsers(context.Background(), srv)
+	if err != nil {
+		t.Fatalf("ListTeamUsers() error = %v", err)
+	}
+
+	want := []pkg.UserAccount{
+		{Name: "alice", Keys: []string{aliceKey, aliceOld}},
+		{Name: "bob"},
+	}
+	if !reflect.DeepEqual(accounts, want) {
+		t.Errorf("accounts = %+v, want %+v", accounts, want)
+	}
+	if calls := srv.Calls(); len(calls) != 1 || !calls[0].Sudo {
+		t.Errorf("calls = %+v, want one command with sudo", calls)
+	}
+}
+
+func TestPlanUsers(t *testing.T) {
+	alice := pkg.UserAccount{Name: "alice", Keys: []string{aliceKey}}
+	bob := pkg.UserAccount{Name: "bob", Keys: []string{bobKey}}
+
+	tests := []struct {
+		name    string
+		desired []pkg.UserAccount
+		current []pkg.UserAccount
+		want    []pkg.UserChange
+	}{
+		{
+			name:    "in sync",
+			desired: []pkg.UserAccount{alice},
+			current: []pkg.UserAccount{{Name: "alice", Keys: []string{aliceKey + " "}}},
+		},
+		{
+			name:    "new member",
+			desired: []pkg.UserAccount{bob, alice},
+			current: []pkg.UserAccount{alice},
+			want:    []pkg.UserChange{{Action: pkg.UserCreate, Account: bob, AddKeys: bob.Keys}},
+		},
+		{
+			name:    "rotated key",
+			desired: []pkg.UserAccount{alice},
+			current: []pkg.UserAccount{{Name: "alice", Keys: []string{aliceOld}}},
+			want: []pkg.UserChange{
+				{Action: pkg.UserUpdate, Account: alice, AddKeys: []string{aliceKey}, RemoveKeys: []string{aliceOld}},
+			},
+		},
+		{
+			name:    "member left",
+			desired: []pkg.UserAccount{alice},
+			current: []pkg.UserAccount{bob, alice},
+			want:    []pkg.UserChange{{Action: pkg.UserRemove, Account: bob, RemoveKeys: bob.Keys}},
+		},
+		{
+			name:    "sorted by name",
+			desired: []pkg.UserAccount{bob},
+			current: []pkg.UserAccount{alice},
+			want: []pkg.UserChange{
+				{Action: pkg.UserRemove, Account: alice, RemoveKeys: alice.Keys},
+				{Action: pkg.UserCreate, Account: bob, AddKeys: bob.Keys},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := PlanUsers(tt.desired, tt.current); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("PlanUsers() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyUserChange(t *testing.T) {
+	alice := pkg.UserAccount{Name: "alice", Shell: "/bin/bash", Keys: []string{aliceKey}}
+
+	tests := []struct {
+		name     string
+		change   pkg.UserChange
+		wantCmds []string // command prefixes, in order
+		wantKeys bool
+		wantErr  bool
+	}{
+		{
+			name:     "create",
+			change:   pkg.UserChange{Action: pkg.UserCreate, Account: alice},
+			wantCmds: []string{"sudo sh -c 'set -e\nif ! getent group mah-users", "sudo sh -c 'set -e\nhome="},
+			wantKeys: true,
+		},
+		{
+			name:     "update",
+			change:   pkg.UserChange{Action: pkg.UserUpdate, Account: alice},
+			wantCmds: []string{"sudo sh -c 'set -e\nhome="},
+			wantKeys: true,
+		},
+		{
+			name:     "remove",
+			change:   pkg.UserChange{Action: pkg.UserRemove, Account: pkg.UserAccount{Name: "bob"}},
+			wantCmds: []string{"sudo sh -c 'if command -v userdel >/dev/null; then userdel bob; else deluser bob; fi'"},
+		},
+		{
+			name:    "invalid name",
+			change:  pkg.UserChange{Action: pkg.UserRemove, Account: pkg.UserAccount{Name: "bob; reboot"}},
+			wantErr: true,
+		},
+		{
+			name:    "root",
+			change:  pkg.UserChange{Action: pkg.UserRemove, Account: pkg.UserAccount{Name: "root"}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := mahtest.NewServer("web-1")
+
+			err := ApplyUserChange(context.Background(), srv, tt.change)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ApplyUserChange() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			cmds := srv.Commands()
+			if len(cmds) != len(tt.wantCmds) {
+				t.Fatalf("commands = %q, want %d", cmds, len(tt.wantCmds))
+			}
+			for i, prefix := range tt.wantCmds {
+				if !strings.HasPrefix(cmds[i], prefix) {
+					t.Errorf("command %d = %q, want prefix %q", i, cmds[i], prefix)
+				}
+			}
+
+			if tt.wantKeys {
+				calls := srv.Calls()
+				stdin := string(calls[len(calls)-1].Stdin)
+				if want := authorizedKeysHeader + aliceKey + "\n"; stdin != want {
+					t.Errorf("authorized_keys = %q, want %q", stdin, want)
+				}
+			}
+		})
+	}
+}
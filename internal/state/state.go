@@ -0,0 +1,207 @@
+package state
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/jonas-jonas/mah/pkg"
+)
+
+// historyLimit is how many revisions are kept per service and server
+const historyLimit = 20
+
+// Store records the services deployed by mah. Each server has a directory
+// under <state dir>/deployments with one file per service, holding its
+// revisions oldest first.
+type Store struct {
+	dir string
+}
+
+// NewStore returns a store in the runtime state directory
+func NewStore(stateDir string) *Store {
+	return &Store{dir: filepath.Join(stateDir, "deployments")}
+}
+
+// Record adds a revision, dropping the oldest ones beyond the history limit.
+// Environment values are never written; the env hash records them.
+func (s *Store) Record(deployment pkg.Deployment) error {
+	if deployment.Config != nil {
+		config := *deployment.Config
+		config.Environment = nil
+		deployment.Config = &config
+	}
+
+	path := s.path(deployment.Server, deployment.Service)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+
+	// Hold the lock from reading the history until it is replaced, so
+	// concurrent deploys never drop each other's revisions
+	unlock, err := lock(path + ".lock")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	history, err := s.History(deployment.Server, deployment.Service)
+	if err != nil {
+		return err
+	}
+	history = append(history, deployment)
+	if len(history) > historyLimit {
+		history = history[len(history)-historyLimit:]
+	}
+
+	data, err := json.MarshalIndent(history, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode deployment state: %w", err)
+	}
+
+	// Write atomically so concurrent commands never read a partial file
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write deployment state: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write deployment state: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write deployment state: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write deployment state: %w", err)
+	}
+	return nil
+}
+
+// lock takes an exclusive lock on the lock file at path, creating it when
+// needed. The returned func releases it.
+func lock(path string) (func(), error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open deployment state lock: %w", err)
+	}
+	if err := lockFile(f); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to lock deployment state: %w", err)
+	}
+	return func() {
+		unlockFile(f)
+		f.Close()
+	}, nil
+}
+
+// History returns the recorded revisions of a service on a server, oldest
+// first. It is empty when the service was never deployed there.
+func (s *Store) History(serverName, serviceName string) ([]pkg.Deployment, error) {
+	data, err := os.ReadFile(s.path(serverName, serviceName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read deployment state: %w", err)
+	}
+
+	var history []pkg.Deployment
+	if err := json.Unmarshal(data, &history); err != nil {
+		return nil, fmt.Errorf("failed to parse deployment state of %s on %s: %w", serviceName, serverName, err)
+	}
+	return history, nil
+}
+
+// Latest returns the current revision of a service on a server, or nil when
+// it was never deployed there
+func (s *Store) Latest(serverName, serviceName string) (*pkg.Deployment, error) {
+	history, err := s.History(serverName, serviceName)
+	if err != nil || len(history) == 0 {
+		return nil, err
+	}
+	return &history[len(history)-1], nil
+}
+
+// Servers returns the current revision of every service deployed to the
+// given servers, sorted by server and service
+func (s *Store) Servers(serverNames []string) ([]pkg.Deployment, error) {
+	var deployments []pkg.Deployment
+	for _, serverName := range serverNames {
+		entries, err := os.ReadDir(filepath.Join(s.dir, serverName))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read deployment state: %w", err)
+		}
+
+		for _, entry := range entries {
+			serviceName, ok := strings.CutSuffix(entry.Name(), ".json")
+			if !ok || entry.IsDir() {
+				continue
+			}
+			latest, err := s.Latest(serverName, serviceName)
+			if err != nil {
+				return nil, err
+			}
+			if latest != nil {
+				deployments = append(deployments, *latest)
+			}
+		}
+	}
+
+	sort.Slice(deployments, func(i, j int) bool {
+		if deployments[i].Server != deployments[j].Server {
+			return deployments[i].Server < deployments[j].Server
+		}
+		return deployments[i].Service < deployments[j].Service
+	})
+	return deployments, nil
+}
+
+// path returns the file holding the revisions of a service on a server
+func (s *Store) path(serverName, serviceName string) string {
+	return filepath.Join(s.dir, serverName, serviceName+".json")
+}
+
+// Hash returns the SHA-256 of content as "sha256:<hex>"
+func Hash(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// EnvHash hashes environment variables independently of their order
+func EnvHash(env map[string]string) string {
+	keys := make([]string, 0, len(env))
+	for key := range env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, key := range keys {
+		b.WriteString(key + "=" + env[key] + "\n")
+	}
+	return Hash(b.String())
+}
+
+// Operator identifies who is deploying, as user@host
+func Operator() string {
+	name := "unknown"
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	if host, err := os.Hostname(); err == nil {
+		return name + "@" + host
+	}
+	return name
+}
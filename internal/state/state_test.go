@@ -0,0 +1,121 @@
+package state
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jonas-jonas/mah/pkg"
+)
+
+func TestStoreRecord(t *testing.T) {
+	store := NewStore(t.TempDir())
+
+	if latest, err := store.Latest("web-1", "blog"); err != nil || latest != nil {
+		t.Fatalf("Latest() on empty store = %+v, %v, want nil", latest, err)
+	}
+
+	for i := 0; i < historyLimit+2; i++ {
+		err := store.Record(pkg.Deployment{
+			Service:    "blog",
+			Server:     "web-1",
+			Image:      fmt.Sprintf("ghost:5.%d", i),
+			DeployedAt: time.Unix(int64(i), 0).UTC(),
+			Config: &pkg.ServiceConfig{
+				Name:        "blog",
+				Environment: map[string]string{"DB_PASSWORD": "secret"},
+			},
+		})
+		if err != nil {
+			t.Fatalf("Record() error = %v", err)
+		}
+	}
+
+	history, err := store.History("web-1", "blog")
+	if err != nil {
+		t.Fatalf("History() error = %v", err)
+	}
+	if len(history) != historyLimit {
+		t.Fatalf("history has %d revisions, want %d", len(history), historyLimit)
+	}
+	if history[0].Image != "ghost:5.2" {
+		t.Errorf("oldest revision = %s, want ghost:5.2", history[0].Image)
+	}
+
+	latest, err := store.Latest("web-1", "blog")
+	if err != nil {
+		t.Fatalf("Latest() error = %v", err)
+	}
+	if latest.Image != fmt.Sprintf("ghost:5.%d", historyLimit+1) {
+		t.Errorf("latest revision = %s", latest.Image)
+	}
+	if latest.Config == nil || latest.Config.Environment != nil {
+		t.Errorf("recorded config = %+v, want one without environment", latest.Config)
+	}
+}
+
+func TestStoreRecordConcurrently(t *testing.T) {
+	store := NewStore(t.TempDir())
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			err := store.Record(pkg.Deployment{Service: "blog", Server: "web-1", Image: fmt.Sprintf("ghost:5.%d", i)})
+			if err != nil {
+				t.Errorf("Record() error = %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	history, err := store.History("web-1", "blog")
+	if err != nil {
+		t.Fatalf("History() error = %v", err)
+	}
+	if len(history) != 10 {
+		t.Errorf("history has %d revisions, want 10", len(history))
+	}
+}
+
+func TestStoreServers(t *testing.T) {
+	store := NewStore(t.TempDir())
+	for _, d := range []pkg.Deployment{
+		{Server: "web-2", Service: "blog", Image: "ghost:5"},
+		{Server: "web-1", Service: "shop", Image: "shop:1"},
+		{Server: "web-1", Service: "blog", Image: "ghost:4"},
+		{Server: "web-1", Service: "blog", Image: "ghost:5"},
+		{Server: "db-1", Service: "mysql", Image: "mysql:8"},
+	} {
+		if err := store.Record(d); err != nil {
+			t.Fatalf("Record() error = %v", err)
+		}
+	}
+
+	deployments, err := store.Servers([]string{"web-2", "web-1", "web-3"})
+	if err != nil {
+		t.Fatalf("Servers() error = %v", err)
+	}
+
+	var got []string
+	for _, d := range deployments {
+		got = append(got, d.Server+"/"+d.Service+"="+d.Image)
+	}
+	want := []string{"web-1/blog=ghost:5", "web-1/shop=shop:1", "web-2/blog=ghost:5"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Servers() = %v, want %v", got, want)
+	}
+}
+
+func TestEnvHash(t *testing.T) {
+	a := EnvHash(map[string]string{"A": "1", "B": "2"})
+	b := EnvHash(map[string]string{"B": "2", "A": "1"})
+	if a != b {
+		t.Errorf("EnvHash depends on order: %s != %s", a, b)
+	}
+	if c := EnvHash(map[string]string{"A": "1", "B": "3"}); c == a {
+		t.Error("EnvHash ignores values")
+	}
+}
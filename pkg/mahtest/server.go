@@ -0,0 +1,465 @@
+// Package mahtest provides a fake pkg.Server for testing code that manages
+// servers. The fake records every command, sudo flag and transferred file and
+// answers commands with scripted results, so tests can assert on exactly what
+// would run on a real machine.
+package mahtest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/jonas-jonas/mah/pkg"
+)
+
+// Operations recorded by Server
+const (
+	OpExecute     = "execute"
+	OpStream      = "stream"
+	OpInteractive = "interactive"
+	OpTransfer    = "transfer"
+	OpSync        = "sync"
+	OpFetchFile   = "fetch-file"
+	OpFetchDir    = "fetch-dir"
+)
+
+// ErrNotSupported is returned by operations the fake does not implement
+var ErrNotSupported = errors.New("not supported by mahtest.Server")
+
+// Call is a single recorded operation
+type Call struct {
+	Op      string
+	Cmd     string // command line for execute, stream and interactive
+	Sudo    bool
+	Local   string // local path for transfers
+	Remote  string // remote path for transfers
+	Stdin   []byte // input sent to a streamed command
+	Content []byte // file content uploaded by a transfer
+	Mode    fs.FileMode
+}
+
+// Response is the scripted result of a command
+type Response struct {
+	Stdout   string
+	Stderr   string
+	ExitCode int
+	Err      error // returned instead of a result, like a broken connection
+}
+
+type responder struct {
+	match func(cmd string) bool
+	resp  Response
+}
+
+// Server is a fake pkg.Server. Commands without a scripted response succeed
+// with no output.
+type Server struct {
+	id     string
+	host   string
+	distro string
+
+	mu         sync.Mutex
+	calls      []Call
+	responders []responder
+	files      map[string][]byte
+	connected  bool
+
+	// Resources is returned by GetResources
+	Resources *pkg.ResourceInfo
+}
+
+// NewServer creates a fake server with the given ID. Host defaults to the ID
+// and the distro to ubuntu.
+func NewServer(id string) *Server {
+	return &Server{
+		id:        id,
+		host:      id,
+		distro:    "ubuntu",
+		files:     make(map[string][]byte),
+		Resources: &pkg.ResourceInfo{},
+	}
+}
+
+// WithHost sets the host returned by Host
+func (s *Server) WithHost(host string) *Server {
+	s.host = host
+	return s
+}
+
+// WithDistro sets the distro returned by GetDistro
+func (s *Server) WithDistro(distro string) *Server {
+	s.distro = distro
+	return s
+}
+
+// On scripts the response to an exact command line. Responses registered
+// later take precedence.
+func (s *Server) On(cmd string, resp Response) *Server {
+	return s.OnMatch(func(c string) bool { return c == cmd }, resp)
+}
+
+// OnPrefix scripts the response to commands starting with prefix
+func (s *Server) OnPrefix(prefix string, resp Response) *Server {
+	return s.OnMatch(func(c string) bool { return strings.HasPrefix(c, prefix) }, resp)
+}
+
+// OnMatch scripts the response to commands accepted by match
+func (s *Server) OnMatch(match func(cmd string) bool, resp Response) *Server {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.responders = append(s.responders, responder{match: match, resp: resp})
+	return s
+}
+
+// SetFile places a file on the fake server for FetchFile and FetchDir
+func (s *Server) SetFile(remote string, content []byte) *Server {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.files[remote] = content
+	return s
+}
+
+// File returns the content of a file transferred to the fake server
+func (s *Server) File(remote string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	content, ok := s.files[remote]
+	return content, ok
+}
+
+// Calls returns all recorded operations in order
+func (s *Server) Calls() []Call {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Call(nil), s.calls...)
+}
+
+// Commands returns the command lines run so far, prefixed with "sudo " for
+// commands run with sudo
+func (s *Server) Commands() []string {
+	var cmds []string
+	for _, call := range s.Calls() {
+		if call.Cmd == "" {
+			continue
+		}
+		if call.Sudo {
+			cmds = append(cmds, "sudo "+call.Cmd)
+		} else {
+			cmds = append(cmds, call.Cmd)
+		}
+	}
+	return cmds
+}
+
+// Reset forgets all recorded operations
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls = nil
+}
+
+// Transcript renders the recorded operations as text, one operation per
+// line. Uploaded file contents and command input follow their operation,
+// indented by a tab.
+func (s *Server) Transcript() string {
+	var b strings.Builder
+	for _, call := range s.Calls() {
+		op := call.Op
+		if call.Sudo {
+			op += " sudo"
+		}
+
+		switch call.Op {
+		case OpExecute, OpStream, OpInteractive:
+			fmt.Fprintf(&b, "%s: %s\n", op, call.Cmd)
+			writeIndented(&b, call.Stdin)
+		case OpTransfer:
+			fmt.Fprintf(&b, "%s: %s (%04o)\n", op, call.Remote, call.Mode.Perm())
+			writeIndented(&b, call.Content)
+		default:
+			fmt.Fprintf(&b, "%s: %s -> %s\n", op, call.Local, call.Remote)
+		}
+	}
+	return b.String()
+}
+
+// writeIndented writes content as tab-indented lines below a transcript entry
+func writeIndented(b *strings.Builder, content []byte) {
+	if len(content) == 0 {
+		return
+	}
+	for _, line := range strings.Split(strings.TrimSuffix(string(content), "\n"), "\n") {
+		if line == "" {
+			b.WriteString("\n")
+			continue
+		}
+		fmt.Fprintf(b, "\t%s\n", line)
+	}
+}
+
+// record appends a call and returns the scripted response for its command
+func (s *Server) record(call Call) Response {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.calls = append(s.calls, call)
+
+	for i := len(s.responders) - 1; i >= 0; i-- {
+		if s.responders[i].match(call.Cmd) {
+			return s.responders[i].resp
+		}
+	}
+	return Response{}
+}
+
+// Connect marks the server as connected
+func (s *Server) Connect(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.connected = true
+	return nil
+}
+
+// Execute records a command and returns its scripted result
+func (s *Server) Execute(ctx context.Context, cmd string, sudo bool) (*pkg.Result, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	resp := s.record(Call{Op: OpExecute, Cmd: cmd, Sudo: sudo})
+	if resp.Err != nil {
+		return nil, resp.Err
+	}
+
+	return &pkg.Result{
+		ExitCode: resp.ExitCode,
+		Stdout:   resp.Stdout,
+		Stderr:   resp.Stderr,
+	}, nil
+}
+
+// Stream records a command and writes its scripted output to opts
+func (s *Server) Stream(ctx context.Context, cmd string, opts pkg.StreamOptions) (*pkg.Result, error) {
+	return s.stream(ctx, OpStream, cmd, opts)
+}
+
+// Interactive records a command like Stream
+func (s *Server) Interactive(ctx context.Context, cmd string, opts pkg.StreamOptions) (*pkg.Result, error) {
+	return s.stream(ctx, OpInteractive, cmd, opts)
+}
+
+func (s *Server) stream(ctx context.Context, op, cmd string, opts pkg.StreamOptions) (*pkg.Result, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	call := Call{Op: op, Cmd: cmd, Sudo: opts.Sudo}
+	if opts.Stdin != nil {
+		stdin, err := io.ReadAll(opts.Stdin)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read stdin: %w", err)
+		}
+		call.Stdin = stdin
+	}
+
+	resp := s.record(call)
+	if resp.Err != nil {
+		return nil, resp.Err
+	}
+
+	if opts.Stdout != nil {
+		io.WriteString(opts.Stdout, resp.Stdout)
+	}
+	if opts.Stderr != nil {
+		io.WriteString(opts.Stderr, resp.Stderr)
+	}
+
+	return &pkg.Result{ExitCode: resp.ExitCode}, nil
+}
+
+// TransferFile records an upload and keeps the file's content
+func (s *Server) TransferFile(ctx context.Context, local, remote string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	call, err := s.upload(local, remote)
+	if err != nil {
+		return err
+	}
+	s.record(call)
+	return nil
+}
+
+// upload reads a local file into the fake server's files
+func (s *Server) upload(local, remote string) (Call, error) {
+	info, err := os.Stat(local)
+	if err != nil {
+		return Call{}, fmt.Errorf("failed to stat local file: %w", err)
+	}
+	content, err := os.ReadFile(local)
+	if err != nil {
+		return Call{}, fmt.Errorf("failed to read local file %s: %w", local, err)
+	}
+
+	s.mu.Lock()
+	s.files[remote] = content
+	s.mu.Unlock()
+
+	return Call{Op: OpTransfer, Local: local, Remote: remote, Content: content, Mode: info.Mode()}, nil
+}
+
+// SyncDir records a sync and uploads every file under local
+func (s *Server) SyncDir(ctx context.Context, local, remote string, opts pkg.SyncOptions) (*pkg.SyncResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var uploads []Call
+	err := filepath.WalkDir(local, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(local, p)
+		if err != nil {
+			return err
+		}
+
+		call, err := s.upload(p, path.Join(remote, filepath.ToSlash(rel)))
+		if err != nil {
+			return err
+		}
+		uploads = append(uploads, call)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to sync %s: %w", local, err)
+	}
+
+	s.record(Call{Op: OpSync, Local: local, Remote: remote})
+
+	result := &pkg.SyncResult{}
+	for _, call := range uploads {
+		s.record(call)
+		result.Uploaded = append(result.Uploaded, call.Remote)
+	}
+	return result, nil
+}
+
+// FetchFile records a download of a file placed with SetFile or uploaded
+// earlier
+func (s *Server) FetchFile(ctx context.Context, remote, local string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.record(Call{Op: OpFetchFile, Local: local, Remote: remote})
+
+	content, ok := s.File(remote)
+	if !ok {
+		return fmt.Errorf("failed to stat remote file %s: %w", remote, fs.ErrNotExist)
+	}
+	return writeLocal(local, content)
+}
+
+// FetchDir records a download of all files under remote
+func (s *Server) FetchDir(ctx context.Context, remote, local string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.record(Call{Op: OpFetchDir, Local: local, Remote: remote})
+
+	s.mu.Lock()
+	var paths []string
+	prefix := strings.TrimSuffix(remote, "/") + "/"
+	for p := range s.files {
+		if strings.HasPrefix(p, prefix) {
+			paths = append(paths, p)
+		}
+	}
+	s.mu.Unlock()
+	sort.Strings(paths)
+
+	for _, p := range paths {
+		content, _ := s.File(p)
+		target := filepath.Join(local, filepath.FromSlash(strings.TrimPrefix(p, prefix)))
+		if err := writeLocal(target, content); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeLocal writes a downloaded file, creating its directory
+func writeLocal(local string, content []byte) error {
+	if err := os.MkdirAll(filepath.Dir(local), 0755); err != nil {
+		return fmt.Errorf("failed to create local directory: %w", err)
+	}
+	if err := os.WriteFile(local, bytes.Clone(content), 0644); err != nil {
+		return fmt.Errorf("failed to write local file %s: %w", local, err)
+	}
+	return nil
+}
+
+// Disconnect marks the server as disconnected
+func (s *Server) Disconnect() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.connected = false
+	return nil
+}
+
+// Connected reports whether Connect was called without a later Disconnect
+func (s *Server) Connected() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.connected
+}
+
+// Dial is not supported by the fake
+func (s *Server) Dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	return nil, ErrNotSupported
+}
+
+// Listen is not supported by the fake
+func (s *Server) Listen(ctx context.Context, network, addr string) (net.Listener, error) {
+	return nil, ErrNotSupported
+}
+
+// GetDistro returns the configured distro
+func (s *Server) GetDistro(ctx context.Context) (string, error) {
+	return s.distro, nil
+}
+
+// GetResources returns Resources
+func (s *Server) GetResources(ctx context.Context) (*pkg.ResourceInfo, error) {
+	return s.Resources, nil
+}
+
+// HealthCheck always succeeds
+func (s *Server) HealthCheck(ctx context.Context) error {
+	return nil
+}
+
+// ID returns the server identifier
+func (s *Server) ID() string {
+	return s.id
+}
+
+// Host returns the server hostname
+func (s *Server) Host() string {
+	return s.host
+}
+
+var _ pkg.Server = (*Server)(nil)
@@ -0,0 +1,91 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// outputMu serializes lines written by prefix writers, so output from
+// several servers never interleaves within a line
+var outputMu sync.Mutex
+
+// LineWriter is an io.Writer that calls fn for every complete line written
+// to it. Flush must be called to emit a trailing partial line.
+type LineWriter struct {
+	mu  sync.Mutex
+	buf []byte
+	fn  func(line string)
+}
+
+// NewLineWriter creates a LineWriter calling fn for each line
+func NewLineWriter(fn func(line string)) *LineWriter {
+	return &LineWriter{fn: fn}
+}
+
+// Write buffers p and emits all complete lines
+func (w *LineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		w.fn(strings.TrimSuffix(string(w.buf[:i]), "\r"))
+		w.buf = w.buf[i+1:]
+	}
+
+	return len(p), nil
+}
+
+// Flush emits any buffered partial line
+func (w *LineWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.buf) > 0 {
+		w.fn(strings.TrimSuffix(string(w.buf), "\r"))
+		w.buf = nil
+	}
+}
+
+// NewPrefixWriter returns a LineWriter that writes every line to out,
+// prefixed with "[prefix] "
+func NewPrefixWriter(out io.Writer, prefix string) *LineWriter {
+	return NewLineWriter(func(line string) {
+		outputMu.Lock()
+		defer outputMu.Unlock()
+		fmt.Fprintf(out, "[%s] %s\n", prefix, line)
+	})
+}
+
+// StreamPrefixed runs cmd on server with its output written live to out,
+// each line prefixed with the server ID. Stderr is also kept in the
+// returned Result for error reporting.
+func StreamPrefixed(ctx context.Context, server Server, cmd string, sudo bool, out io.Writer) (*Result, error) {
+	stdout := NewPrefixWriter(out, server.ID())
+	stderr := NewPrefixWriter(out, server.ID())
+	var captured strings.Builder
+
+	result, err := server.Stream(ctx, cmd, StreamOptions{
+		Sudo:   sudo,
+		Stdout: stdout,
+		Stderr: io.MultiWriter(stderr, &captured),
+	})
+	stdout.Flush()
+	stderr.Flush()
+	if err != nil {
+		return nil, err
+	}
+
+	if result.Stderr == "" {
+		result.Stderr = captured.String()
+	}
+	return result, nil
+}
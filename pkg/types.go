@@ -1,15 +1,29 @@
 package pkg
 
-import "context"
+import (
+	"context"
+	"io"
+	"net"
+	"time"
+)
 
 // Server represents a remote server that MAH can manage
 type Server interface {
 	// Core server operations
 	Connect(ctx context.Context) error
 	Execute(ctx context.Context, cmd string, sudo bool) (*Result, error)
+	Stream(ctx context.Context, cmd string, opts StreamOptions) (*Result, error)
+	Interactive(ctx context.Context, cmd string, opts StreamOptions) (*Result, error)
 	TransferFile(ctx context.Context, local, remote string) error
+	SyncDir(ctx context.Context, local, remote string, opts SyncOptions) (*SyncResult, error)
+	FetchFile(ctx context.Context, remote, local string) error
+	FetchDir(ctx context.Context, remote, local string) error
 	Disconnect() error
 
+	// Network connections through the server
+	Dial(ctx context.Context, network, addr string) (net.Conn, error)
+	Listen(ctx context.Context, network, addr string) (net.Listener, error)
+
 	// System information
 	GetDistro(ctx context.Context) (string, error)
 	GetResources(ctx context.Context) (*ResourceInfo, error)
@@ -43,7 +57,7 @@ type ContainerProvider interface {
 	Deploy(config *ServiceConfig) error
 	Scale(serviceName string, replicas int) error
 	Status(serviceName string) (*ServiceStatus, error)
-	Logs(serviceName string, follow bool) (<-chan string, error)
+	Logs(ctx context.Context, serviceName string, follow bool) (<-chan string, error)
 	Remove(serviceName string) error
 }
 
@@ -71,12 +85,46 @@ type Result struct {
 	Duration int64 // milliseconds
 }
 
-// ResourceInfo contains server resource information
+// StreamOptions configures a streaming command execution. Output is written
+// to Stdout and Stderr as it arrives instead of being buffered in the Result.
+type StreamOptions struct {
+	Sudo   bool
+	Stdin  io.Reader // optional input for the remote command
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// SyncOptions configures a directory sync
+type SyncOptions struct {
+	Delete   bool // remove remote files that do not exist locally
+	Checksum bool // compare file contents instead of modification times
+}
+
+// SyncResult summarizes a directory sync
+type SyncResult struct {
+	Uploaded  []string `json:"uploaded"`
+	Deleted   []string `json:"deleted"`
+	Unchanged int      `json:"unchanged"`
+}
+
+// ResourceInfo contains server resource information and facts about the
+// system, gathered in a single probe
 type ResourceInfo struct {
 	CPU    CPUInfo    `json:"cpu"`
 	Memory MemoryInfo `json:"memory"`
-	Disk   DiskInfo   `json:"disk"`
+	Disk   DiskInfo   `json:"disk"` // root filesystem
 	Load   LoadInfo   `json:"load"`
+
+	Hostname       string             `json:"hostname"`
+	Kernel         string             `json:"kernel"`
+	Uptime         int64              `json:"uptime"` // seconds
+	Mounts         []MountInfo        `json:"mounts"`
+	Interfaces     []NetworkInterface `json:"interfaces"`
+	ListeningPorts []ListeningPort    `json:"listening_ports"`
+	CgroupVersion  int                `json:"cgroup_version"`    // 1 or 2, 0 if unknown
+	Docker         string             `json:"docker,omitempty"`  // Docker version, empty when not installed
+	Compose        string             `json:"compose,omitempty"` // Docker Compose version
+	GatheredAt     time.Time          `json:"gathered_at"`
 }
 
 type CPUInfo struct {
@@ -106,6 +154,33 @@ type LoadInfo struct {
 	Load15 float64 `json:"load15"`
 }
 
+// MountInfo describes a mounted filesystem
+type MountInfo struct {
+	Device    string  `json:"device"`
+	Path      string  `json:"path"`
+	FSType    string  `json:"fstype"`
+	Total     int64   `json:"total"`     // bytes
+	Available int64   `json:"available"` // bytes
+	Used      int64   `json:"used"`      // bytes
+	Usage     float64 `json:"usage"`     // percentage
+}
+
+// NetworkInterface describes a network interface and its addresses
+type NetworkInterface struct {
+	Name      string   `json:"name"`
+	MAC       string   `json:"mac,omitempty"`
+	MTU       int      `json:"mtu"`
+	State     string   `json:"state"`     // operstate: up, down, unknown
+	Addresses []string `json:"addresses"` // CIDR notation
+}
+
+// ListeningPort describes a socket accepting connections
+type ListeningPort struct {
+	Protocol string `json:"protocol"` // tcp, tcp6, udp, udp6
+	Address  string `json:"address"`
+	Port     int    `json:"port"`
+}
+
 // DNSRecord represents a DNS record
 type DNSRecord struct {
 	Name  string `json:"name"`
@@ -154,6 +229,75 @@ type FirewallRule struct {
 	Comment  string `json:"comment"`
 }
 
+// SSHPolicy describes the sshd hardening applied to a server
+type SSHPolicy struct {
+	Port                   int      `json:"port,omitempty"` // 0 keeps the current port
+	AllowUsers             []string `json:"allow_users,omitempty"`
+	Ciphers                []string `json:"ciphers,omitempty"`
+	MaxAuthTries           int      `json:"max_auth_tries,omitempty"`
+	PermitRootLogin        string   `json:"permit_root_login,omitempty"`
+	PasswordAuthentication bool     `json:"password_authentication"`
+
+	// TestLogin opens a fresh SSH login on port after sshd restarts; the
+	// previous configuration is restored when it fails
+	TestLogin func(ctx context.Context, port int) error `json:"-"`
+}
+
+// Fail2banPolicy describes the fail2ban jails set up on a server
+type Fail2banPolicy struct {
+	SSHPort    int      `json:"ssh_port"`
+	BanTime    string   `json:"bantime,omitempty"`
+	FindTime   string   `json:"findtime,omitempty"`
+	MaxRetry   int      `json:"maxretry,omitempty"`
+	IgnoreIPs  []string `json:"ignore_ips,omitempty"`
+	TraefikLog string   `json:"traefik_log,omitempty"` // enables the traefik-auth jail
+}
+
+// DockerDaemon describes the Docker daemon settings written to daemon.json
+type DockerDaemon struct {
+	LogDriver           string        `json:"log_driver,omitempty"`   // default json-file
+	LogMaxSize          string        `json:"log_max_size,omitempty"` // json-file and local only
+	LogMaxFile          int           `json:"log_max_file,omitempty"` // json-file and local only
+	RegistryMirrors     []string      `json:"registry_mirrors,omitempty"`
+	DefaultAddressPools []AddressPool `json:"default_address_pools,omitempty"`
+	LiveRestore         bool          `json:"live_restore"`
+	UsernsRemap         string        `json:"userns_remap,omitempty"`
+}
+
+// AddressPool is a range Docker carves network subnets of Size bits from
+type AddressPool struct {
+	Base string `json:"base"` // CIDR
+	Size int    `json:"size"` // prefix length of each subnet
+}
+
+// Ban is an address banned by a fail2ban jail
+type Ban struct {
+	Jail string `json:"jail"`
+	IP   string `json:"ip"`
+}
+
+// UserAccount is a team member's account on a server
+type UserAccount struct {
+	Name  string   `json:"name"`
+	Shell string   `json:"shell,omitempty"` // only used when the account is created
+	Keys  []string `json:"keys"`            // authorized_keys lines
+}
+
+// User change actions
+const (
+	UserCreate = "create"
+	UserUpdate = "update"
+	UserRemove = "remove"
+)
+
+// UserChange is a change to a team account needed to match the configuration
+type UserChange struct {
+	Action     string      `json:"action"` // create, update, remove
+	Account    UserAccount `json:"account"`
+	AddKeys    []string    `json:"add_keys,omitempty"`
+	RemoveKeys []string    `json:"remove_keys,omitempty"`
+}
+
 // FirewallStatus represents current firewall status
 type FirewallStatus struct {
 	Active bool           `json:"active"`
@@ -177,4 +321,17 @@ type ServiceConfig struct {
 	Command     []string          `json:"command"`
 	Labels      map[string]string `json:"labels"`
 	Replicas    int               `json:"replicas"`
-}
\ No newline at end of file
+}
+
+// Deployment is one revision of a service deployed to a server
+type Deployment struct {
+	Service     string         `json:"service"`
+	Server      string         `json:"server"`
+	Image       string         `json:"image"`
+	ImageDigest string         `json:"image_digest,omitempty"` // empty for images without a registry digest
+	ComposeHash string         `json:"compose_hash"`
+	EnvHash     string         `json:"env_hash"`
+	DeployedAt  time.Time      `json:"deployed_at"`
+	DeployedBy  string         `json:"deployed_by"`
+	Config      *ServiceConfig `json:"config"` // without environment values
+}